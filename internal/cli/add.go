@@ -4,11 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"time"
 
 	"github.com/existflow/irontask/internal/database"
 	"github.com/existflow/irontask/internal/db"
 	"github.com/existflow/irontask/internal/model"
+	"github.com/existflow/irontask/internal/sync"
 	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 )
@@ -90,7 +90,7 @@ func runAdd(cmd *cobra.Command, args []string) error {
 	}
 
 	// Create task
-	now := time.Now().Format(time.RFC3339)
+	now := sync.Now(dbConn)
 	err = dbConn.CreateTask(context.Background(), database.CreateTaskParams{
 		ID:        uuid.New().String(),
 		ProjectID: projectID,
@@ -50,6 +50,11 @@ func runLogin(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	unlock, err := client.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	// Check for magic link flags
 	email, _ := cmd.Flags().GetString("email")
@@ -120,6 +125,11 @@ func runLogout(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	unlock, err := client.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	if !client.IsLoggedIn() {
 		fmt.Println("Not logged in.")
@@ -140,6 +150,11 @@ func runRegister(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	unlock, err := client.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	reader := bufio.NewReader(os.Stdin)
 
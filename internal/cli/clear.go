@@ -57,6 +57,11 @@ func runClear(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	unlock, err := client.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	if local {
 		fmt.Println("🧹 Clearing local data...")
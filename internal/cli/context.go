@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/existflow/irontask/internal/config"
 	"github.com/existflow/irontask/internal/db"
 	"github.com/spf13/cobra"
 )
@@ -52,13 +53,13 @@ func init() {
 	contextCmd.AddCommand(contextClearCmd)
 }
 
-// Context file path
+// Context file path, per profile as project ids differ between them
 func contextFilePath() (string, error) {
-	home, err := os.UserHomeDir()
+	dir, err := config.ProfileDir(config.ActiveProfile())
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(home, ".irontask", "context"), nil
+	return filepath.Join(dir, "context"), nil
 }
 
 // GetCurrentContext returns the current project context (empty means inbox)
@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/existflow/irontask/internal/config"
+	"github.com/existflow/irontask/internal/daemon"
+	"github.com/existflow/irontask/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Sync in the background",
+	Long: `Run a background process that pushes local changes a few seconds after
+they are made and pulls remote changes as they happen, for the active profile.
+
+While it runs, CLI commands and the TUI leave syncing to it. It reads the sync
+settings again before every sync, and commands that change them or the synced
+data, e.g. 'sync key rotate' or 'sync --pull', wait for its sync to finish and
+hold it back until they are done.
+
+Commands:
+  irontask daemon start             # Start in the background
+  irontask daemon start --foreground
+  irontask daemon status            # Show what the daemon is doing
+  irontask daemon stop              # Push pending changes and stop`,
+}
+
+var daemonStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the sync daemon",
+	Long: `Start the sync daemon of the active profile in the background.
+
+With --foreground it runs until interrupted, e.g. as a systemd or launchd
+service.`,
+	RunE: runDaemonStart,
+}
+
+var daemonStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the sync daemon",
+	RunE:  runDaemonStop,
+}
+
+var daemonStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state of the sync daemon",
+	RunE:  runDaemonStatus,
+}
+
+func init() {
+	daemonCmd.AddCommand(daemonStartCmd)
+	daemonCmd.AddCommand(daemonStopCmd)
+	daemonCmd.AddCommand(daemonStatusCmd)
+
+	daemonStartCmd.Flags().Bool("foreground", false, "Run in the foreground until interrupted")
+	daemonStatusCmd.Flags().Bool("json", false, "Print as JSON")
+}
+
+func runDaemonStart(cmd *cobra.Command, args []string) error {
+	if status, err := daemon.GetStatus(); err == nil {
+		fmt.Printf("Daemon already running (pid %d)\n", status.PID)
+		return nil
+	}
+
+	foreground, _ := cmd.Flags().GetBool("foreground")
+	if foreground {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		fmt.Printf("Sync daemon running for profile %s (pid %d), press Ctrl+C to stop\n",
+			config.ActiveProfile(), os.Getpid())
+		return daemon.Run(ctx)
+	}
+
+	// Check here, errors of the detached process are only logged
+	client, err := sync.NewClient()
+	if err != nil {
+		return err
+	}
+	if !client.IsLoggedIn() {
+		return fmt.Errorf("not logged in, run 'irontask auth login' first")
+	}
+	if !client.CanAutoSync() {
+		return fmt.Errorf("run 'irontask sync' once before starting the daemon")
+	}
+
+	status, err := daemon.Start([]string{"--profile", config.ActiveProfile(), "daemon", "start", "--foreground"})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[OK] Sync daemon started (pid %d)\n", status.PID)
+	return nil
+}
+
+func runDaemonStop(cmd *cobra.Command, args []string) error {
+	if err := daemon.Stop(); err != nil {
+		if errors.Is(err, daemon.ErrNotRunning) {
+			fmt.Println("Daemon is not running")
+			return nil
+		}
+		return err
+	}
+	fmt.Println("[OK] Sync daemon stopped")
+	return nil
+}
+
+func runDaemonStatus(cmd *cobra.Command, args []string) error {
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	status, err := daemon.GetStatus()
+	if err != nil && !errors.Is(err, daemon.ErrNotRunning) {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{
+			"running": status != nil,
+			"status":  status,
+		})
+	}
+
+	if status == nil {
+		fmt.Println("Daemon is not running (start it with 'irontask daemon start')")
+		return nil
+	}
+	fmt.Printf("Daemon:    running (pid %d, profile %s, since %s)\n",
+		status.PID, status.Profile, status.StartedAt.Local().Format("2006-01-02 15:04"))
+	switch {
+	case status.Offline:
+		fmt.Printf("Sync:      %s", status.Sync())
+		if !status.NextRetry.IsZero() {
+			fmt.Printf(", retrying in %s", time.Until(status.NextRetry).Round(time.Second))
+		}
+		fmt.Println()
+	case status.Syncing:
+		fmt.Println("Sync:      syncing")
+	default:
+		fmt.Println("Sync:      idle")
+	}
+	fmt.Printf("Pending:   %d\n", status.Pending)
+	fmt.Printf("Last Sync: %d\n", status.SyncVersion)
+	if status.Conflicts > 0 {
+		fmt.Printf("Conflicts: %d (run 'irontask sync conflicts')\n", status.Conflicts)
+	}
+	if status.LastError != "" && !status.Offline {
+		fmt.Printf("Error:     %s\n", status.LastError)
+	}
+	return nil
+}
@@ -4,11 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"time"
 
 	"github.com/existflow/irontask/internal/config"
 	"github.com/existflow/irontask/internal/database"
 	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/sync"
 	"github.com/spf13/cobra"
 )
 
@@ -66,10 +66,11 @@ func runDelete(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	now := sync.Now(dbConn)
 	if err := dbConn.DeleteTask(ctx, database.DeleteTaskParams{
 		ID:        task.ID,
-		DeletedAt: sql.NullString{String: time.Now().Format(time.RFC3339), Valid: true},
-		UpdatedAt: time.Now().Format(time.RFC3339),
+		DeletedAt: sql.NullString{String: now, Valid: true},
+		UpdatedAt: now,
 	}); err != nil {
 		return fmt.Errorf("failed to delete task: %w", err)
 	}
@@ -4,10 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"time"
 
 	"github.com/existflow/irontask/internal/database"
 	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/sync"
 	"github.com/spf13/cobra"
 )
 
@@ -64,7 +64,7 @@ func runDone(cmd *cobra.Command, args []string) error {
 	if err := dbConn.UpdateTaskStatus(ctx, database.UpdateTaskStatusParams{
 		ID:        task.ID,
 		Status:    sql.NullString{String: newStatus, Valid: true},
-		UpdatedAt: time.Now().Format(time.RFC3339),
+		UpdatedAt: sync.Now(dbConn),
 	}); err != nil {
 		return fmt.Errorf("failed to update task: %w", err)
 	}
@@ -5,11 +5,11 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/existflow/irontask/internal/database"
 	"github.com/existflow/irontask/internal/db"
 	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/internal/sync"
 	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 )
@@ -80,7 +80,7 @@ func runProjectNew(cmd *cobra.Command, args []string) error {
 		id = uuid.New().String()[:8]
 	}
 
-	now := time.Now().Format(time.RFC3339)
+	now := sync.Now(dbConn)
 	if err := dbConn.CreateProject(context.Background(), database.CreateProjectParams{
 		ID:        id,
 		Slug:      slug,
@@ -171,9 +171,11 @@ func runProjectDelete(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("project not found: %s", projectID)
 	}
 
+	now := sync.Now(dbConn)
 	if err := dbConn.DeleteProject(context.Background(), database.DeleteProjectParams{
 		ID:        projectID,
-		DeletedAt: sql.NullString{String: time.Now().Format(time.RFC3339), Valid: true},
+		DeletedAt: sql.NullString{String: now, Valid: true},
+		UpdatedAt: now,
 	}); err != nil {
 		logger.Error("Failed to delete project", logger.F("projectID", projectID), logger.F("error", err))
 		return fmt.Errorf("failed to delete project: %w", err)
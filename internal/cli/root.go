@@ -15,6 +15,7 @@ var (
 	logLevel   string
 	logFile    string
 	logConsole bool
+	profile    string
 )
 
 var rootCmd = &cobra.Command{
@@ -67,7 +68,13 @@ Run 'task' without arguments to launch the interactive TUI.`,
 			return fmt.Errorf("failed to initialize logger: %w", err)
 		}
 
-		logger.Info("IronTask started", logger.F("command", cmd.Name()))
+		if profile != "" {
+			if err := config.SetProfile(profile); err != nil {
+				return err
+			}
+		}
+
+		logger.Info("IronTask started", logger.F("command", cmd.Name()), logger.F("profile", config.ActiveProfile()))
 		return nil
 	},
 
@@ -111,6 +118,7 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "Log level (DEBUG, INFO, WARN, ERROR)")
 	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Path to log file")
 	rootCmd.PersistentFlags().BoolVar(&logConsole, "log-console", false, "Enable console logging")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "Sync profile to use for this command")
 
 	// Add subcommands
 	rootCmd.AddCommand(addCmd)
@@ -122,4 +130,5 @@ func init() {
 	rootCmd.AddCommand(syncCmd)
 	rootCmd.AddCommand(authCmd)
 	rootCmd.AddCommand(clearCmd)
+	rootCmd.AddCommand(daemonCmd)
 }
@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"syscall"
 
+	"github.com/existflow/irontask/internal/config"
+	"github.com/existflow/irontask/internal/daemon"
 	"github.com/existflow/irontask/internal/db"
 	"github.com/existflow/irontask/internal/sync"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 var syncCmd = &cobra.Command{
@@ -18,7 +22,12 @@ var syncCmd = &cobra.Command{
 
 Commands:
   irontask sync              # Sync now
-  irontask sync status       # Show sync status`,
+  irontask sync status       # Show sync status
+  irontask sync resolve      # Resolve conflicts field by field
+  irontask sync conflicts    # List, show and resolve stored conflicts
+  irontask sync verify       # Compare local data with the server
+  irontask sync log          # Show the history of syncs
+  irontask sync profile      # Manage sync profiles`,
 	RunE: runSync,
 }
 
@@ -29,8 +38,62 @@ var syncStatusCmd = &cobra.Command{
 }
 var syncKeyCmd = &cobra.Command{
 	Use:   "key",
-	Short: "Generate or show encryption key",
-	RunE:  runSyncKey,
+	Short: "Set up or show the end-to-end encryption key",
+	Long: `Set up the encryption key of the account on this device, or show its
+fingerprint once it is set up.
+
+Task contents and project names are encrypted with it. The server still sees
+task status, priority and due dates, and project ids, which are usually derived
+from the project name. Run 'irontask sync config --opaque' to hide them too.`,
+	RunE: runSyncKey,
+}
+
+var syncKeyRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the encryption key and re-encrypt all synced data",
+	Long: `Derive a new encryption key from a new password, re-encrypt every
+project and task with it and push them to the server.
+
+Other devices detect the new key on their next sync and ask for the new password.`,
+	RunE: runSyncKeyRotate,
+}
+
+var syncKeyRecoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: "Restore the encryption key from the recovery phrase",
+	Long: `Restore access to your encrypted data with the recovery phrase shown when
+the key was created, e.g. on a new device after forgetting the password.
+
+You choose a new encryption password, other devices keep working unchanged.`,
+	RunE: runSyncKeyRecover,
+}
+
+var syncKeyPasswdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "Change the encryption password",
+	Long: `Change the password protecting the encryption key. Your data stays
+encrypted with the same key, so nothing is re-uploaded.`,
+	RunE: runSyncKeyPasswd,
+}
+
+var syncResolveCmd = &cobra.Command{
+	Use:   "resolve",
+	Short: "Sync and resolve conflicts field by field",
+	Long: `Sync now and walk through the conflicts that could not be merged
+automatically. For each field changed on both devices, choose the local or
+the server value.`,
+	RunE: runSyncResolve,
+}
+
+var syncVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Compare local data with the server and repair differences",
+	Long: `Compare every synced project and task with the server and list the ones
+missing locally, missing on the server or at a different version.
+
+Repairing restores the server copy of items missing or outdated locally and
+pushes local items the server lacks or holds at an older version.`,
+	RunE: runSyncVerify,
 }
 
 var syncConfigCmd = &cobra.Command{
@@ -42,13 +105,31 @@ var syncConfigCmd = &cobra.Command{
 func init() {
 	syncCmd.AddCommand(syncStatusCmd)
 	syncCmd.AddCommand(syncKeyCmd)
+	syncKeyCmd.AddCommand(syncKeyRotateCmd)
+	syncKeyCmd.AddCommand(syncKeyRecoverCmd)
+	syncKeyCmd.AddCommand(syncKeyPasswdCmd)
+	syncCmd.AddCommand(syncResolveCmd)
+	syncCmd.AddCommand(syncVerifyCmd)
 	syncCmd.AddCommand(syncConfigCmd)
 
 	syncCmd.Flags().Bool("pull", false, "Force sync from remote (replaces local)")
 	syncCmd.Flags().Bool("push", false, "Force sync from local (replaces remote)")
+	syncCmd.Flags().Bool("reseed", false, "Upload every local project and task again, keeping the server copy")
+	syncCmd.Flags().Bool("rebuild-local", false, "Download everything from the server again, keeping unpushed local changes")
+	syncCmd.Flags().Bool("dry-run", false, "Show what would change on each side without changing anything")
+	syncCmd.Flags().Bool("force", false, "Do not ask for confirmation before --pull, --push, --reseed or --rebuild-local")
+
+	syncResolveCmd.Flags().Bool("local", false, "Keep the local value of every conflicting field")
+	syncResolveCmd.Flags().Bool("server", false, "Keep the server value of every conflicting field")
+
+	syncVerifyCmd.Flags().Bool("repair", false, "Repair differences without asking")
 
 	syncConfigCmd.Flags().String("server", "", "Set server URL")
 	syncConfigCmd.Flags().Bool("insecure", false, "Allow insecure (HTTP) connection")
+	syncConfigCmd.Flags().Bool("opaque", false, "Hide all metadata (names, status, priority, due dates) from the server")
+	syncConfigCmd.Flags().Int("batch-size", 0, "Items per sync request (default 500, max 1000)")
+	syncConfigCmd.Flags().String("folder", "", "Sync through a shared folder instead of the server, empty to use the server again")
+	syncConfigCmd.Flags().String("git", "", "Sync through a git repository (URL or local path) instead of the server, empty to use the server again")
 }
 
 func runSync(cmd *cobra.Command, args []string) error {
@@ -68,30 +149,335 @@ func runSync(cmd *cobra.Command, args []string) error {
 	mode := sync.SyncModeMerge
 	pull, _ := cmd.Flags().GetBool("pull")
 	push, _ := cmd.Flags().GetBool("push")
+	reseed, _ := cmd.Flags().GetBool("reseed")
+	rebuild, _ := cmd.Flags().GetBool("rebuild-local")
 
-	if pull && push {
-		return fmt.Errorf("cannot use both --pull and --push")
+	modes := 0
+	for _, set := range []bool{pull, push, reseed, rebuild} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return fmt.Errorf("use only one of --pull, --push, --reseed and --rebuild-local")
 	}
 
 	if pull {
 		mode = sync.SyncModeRemoteToLocal
-		fmt.Println("Forcing sync from remote (replacing local data)...")
 	} else if push {
 		mode = sync.SyncModeLocalToRemote
+	} else if reseed {
+		mode = sync.SyncModeReseed
+	} else if rebuild {
+		mode = sync.SyncModeRebuildLocal
+	}
+
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	force, _ := cmd.Flags().GetBool("force")
+	if dryRun || (mode != sync.SyncModeMerge && !force) {
+		plan, err := client.Preview(database, mode)
+		if err != nil {
+			return fmt.Errorf("preview failed: %w", err)
+		}
+		if dryRun {
+			printPlan(plan)
+			fmt.Println("\nDry run, nothing was changed.")
+			return nil
+		}
+
+		if pull {
+			fmt.Println("This replaces all local data with the server's.")
+		} else if rebuild {
+			fmt.Println("This replaces all synced local data with the server's, unpushed changes are kept.")
+		} else if reseed {
+			fmt.Println("This uploads all local data again, server changes it would overwrite become conflicts.")
+		} else {
+			fmt.Println("This replaces all server data with this device's.")
+		}
+		printPlanCounts(plan)
+		fmt.Print("Continue? [y/N]: ")
+		var confirm string
+		_, _ = fmt.Scanln(&confirm)
+		if confirm != "y" && confirm != "Y" {
+			fmt.Println("Cancelled")
+			return nil
+		}
+	}
+
+	if pull {
+		fmt.Println("Forcing sync from remote (replacing local data)...")
+	} else if push {
 		fmt.Println("Forcing sync from local (replacing remote data)...")
+	} else if reseed {
+		fmt.Println("Uploading all local data again...")
+	} else if rebuild {
+		fmt.Println("Rebuilding local data from remote (keeping unpushed changes)...")
 	} else {
 		fmt.Println("Synchronizing...")
 	}
 
+	if mode == sync.SyncModeMerge && daemon.Running() {
+		// Two syncs at once would race, let the daemon run it
+		result, err := daemon.Sync()
+		if err != nil {
+			return fmt.Errorf("sync failed: %w", err)
+		}
+		fmt.Printf("[OK] Sync complete! Pushed: %d, Pulled: %d\n", result.Pushed, result.Pulled)
+		if result.Conflicts > 0 {
+			fmt.Printf("%d conflicts need resolving, run 'irontask sync conflicts'\n", result.Conflicts)
+		}
+		printFailed(result.Failed)
+		return nil
+	}
+
 	result, err := client.Sync(database, mode)
 	if err != nil {
 		return fmt.Errorf("sync failed: %w", err)
 	}
 
 	fmt.Printf("[OK] Sync complete! Pushed: %d, Pulled: %d\n", result.Pushed, result.Pulled)
+	if len(result.Conflicts) > 0 {
+		fmt.Printf("%d conflicts need resolving, run 'irontask sync conflicts'\n", len(result.Conflicts))
+	}
+	printFailed(result.Failed)
 	return nil
 }
 
+// printPlan lists the changes a sync would make on each side
+func printPlan(plan *sync.SyncPlan) {
+	printPlanSide("This device", plan.Local)
+	printPlanSide("Server", plan.Remote)
+	if len(plan.Conflicts) > 0 {
+		fmt.Printf("\nChanged on both sides (%d):\n", len(plan.Conflicts))
+		printPlanItems("!", plan.Conflicts)
+	}
+	if plan.Local.Count()+plan.Remote.Count()+len(plan.Conflicts) == 0 {
+		fmt.Println("Nothing to sync")
+		return
+	}
+	fmt.Println()
+	printPlanCounts(plan)
+}
+
+func printPlanSide(heading string, changes sync.PlanChanges) {
+	if changes.Count() == 0 {
+		return
+	}
+	fmt.Printf("\n%s (%d):\n", heading, changes.Count())
+	printPlanItems("+", changes.Created)
+	printPlanItems("~", changes.Updated)
+	printPlanItems("-", changes.Deleted)
+}
+
+func printPlanItems(mark string, items []sync.PlanItem) {
+	for _, item := range items {
+		id := item.ID
+		if len(id) > 8 {
+			id = id[:8]
+		}
+		fmt.Printf("  %s %-7s  %-8s  %q\n", mark, item.Type, id, item.Name)
+	}
+}
+
+// printPlanCounts summarizes the changes a sync would make on each side
+func printPlanCounts(plan *sync.SyncPlan) {
+	for _, side := range []struct {
+		name    string
+		changes sync.PlanChanges
+	}{{"This device", plan.Local}, {"Server", plan.Remote}} {
+		fmt.Printf("%-12s %d created, %d updated, %d deleted\n", side.name+":",
+			len(side.changes.Created), len(side.changes.Updated), len(side.changes.Deleted))
+	}
+	if len(plan.Conflicts) > 0 {
+		fmt.Printf("%-12s %d\n", "Conflicts:", len(plan.Conflicts))
+	}
+}
+
+// printFailed lists the items a sync could not store, they are retried next time
+func printFailed(failed []sync.FailedItem) {
+	if len(failed) == 0 {
+		return
+	}
+	fmt.Printf("%d items failed to sync and will be retried:\n", len(failed))
+	for _, f := range failed {
+		fmt.Printf("  %s %s: %s\n", f.Type, f.ClientID, f.Error)
+	}
+}
+
+func runSyncResolve(cmd *cobra.Command, args []string) error {
+	client, err := sync.NewClient()
+	if err != nil {
+		return err
+	}
+	unlock, err := client.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	local, _ := cmd.Flags().GetBool("local")
+	server, _ := cmd.Flags().GetBool("server")
+	if local && server {
+		return fmt.Errorf("cannot use both --local and --server")
+	}
+
+	database, err := db.OpenDefault()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer func() {
+		_ = database.Close()
+	}()
+
+	fmt.Println("Synchronizing...")
+	result, err := client.SyncWithTrigger(database, sync.SyncModeMerge, sync.TriggerResolve)
+	if err != nil {
+		return fmt.Errorf("sync failed: %w", err)
+	}
+	if len(result.Conflicts) == 0 {
+		fmt.Println("[OK] No conflicts")
+		return nil
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	keepLocal := func(prompt string) bool {
+		if local || server {
+			return local
+		}
+		return askLocal(reader, prompt)
+	}
+
+	for i, conflict := range result.Conflicts {
+		name, _ := client.DescribeItem(conflict.ClientData)
+		fmt.Printf("\nConflict %d of %d: %s %q\n", i+1, len(result.Conflicts), conflict.Type, name)
+
+		if len(conflict.Fields) == 0 {
+			// Nothing to merge field by field, e.g. the item could not be decrypted
+			if keepLocal("  Keep [l]ocal or [s]erver version? ") {
+				err = client.KeepLocal(database, conflict)
+			} else {
+				err = client.Resolve(database, conflict, sync.ResolveServer)
+			}
+		} else {
+			choices := make(map[string]bool)
+			for _, f := range conflict.Fields {
+				fmt.Printf("\n  %s\n", f.Field)
+				fmt.Printf("    base:   %s\n", f.Base)
+				fmt.Printf("    local:  %s\n", f.Local)
+				fmt.Printf("    server: %s\n", f.Server)
+				choices[f.Field] = keepLocal("  Keep [l]ocal or [s]erver value? ")
+			}
+			err = client.ResolveConflict(database, conflict, choices)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", conflict.ClientID, err)
+		}
+	}
+
+	// Push the resolved versions
+	result, err = client.SyncWithTrigger(database, sync.SyncModeMerge, sync.TriggerResolve)
+	if err != nil {
+		return fmt.Errorf("sync failed: %w", err)
+	}
+	fmt.Printf("\n[OK] Conflicts resolved! Pushed: %d, Pulled: %d\n", result.Pushed, result.Pulled)
+	if len(result.Conflicts) > 0 {
+		fmt.Printf("%d new conflicts need resolving, run 'irontask sync conflicts'\n", len(result.Conflicts))
+	}
+	printFailed(result.Failed)
+	return nil
+}
+
+// askLocal prompts until the answer is l(ocal) or s(erver)
+func askLocal(reader *bufio.Reader, prompt string) bool {
+	for {
+		fmt.Print(prompt)
+		answer, err := reader.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "l", "local":
+			return true
+		case "s", "server":
+			return false
+		}
+		if err != nil {
+			return false // No input, keep the server value
+		}
+	}
+}
+
+func runSyncVerify(cmd *cobra.Command, args []string) error {
+	client, err := sync.NewClient()
+	if err != nil {
+		return err
+	}
+	unlock, err := client.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	database, err := db.OpenDefault()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer func() {
+		_ = database.Close()
+	}()
+
+	fmt.Println("Verifying...")
+	report, err := client.Verify(database)
+	if err != nil {
+		return fmt.Errorf("verify failed: %w", err)
+	}
+	if report.Pending > 0 {
+		fmt.Printf("%d unpushed changes were not compared, run 'irontask sync' first\n", report.Pending)
+	}
+	if report.Divergent() == 0 {
+		fmt.Printf("[OK] All %d items match the server\n", report.Checked)
+		return nil
+	}
+
+	printVerifyItems("Missing locally", report.Missing)
+	printVerifyItems("Missing on the server", report.Extra)
+	printVerifyItems("Different versions", report.Mismatched)
+
+	repair, _ := cmd.Flags().GetBool("repair")
+	if !repair {
+		fmt.Printf("\nRepair %d items? [y/N]: ", report.Divergent())
+		var confirm string
+		_, _ = fmt.Scanln(&confirm)
+		if confirm != "y" && confirm != "Y" {
+			fmt.Println("Cancelled")
+			return nil
+		}
+	}
+
+	result, err := client.Repair(database, report)
+	if err != nil {
+		return fmt.Errorf("repair failed: %w", err)
+	}
+	fmt.Printf("[OK] Repaired! Pushed: %d, Pulled: %d\n", result.Pushed, result.Pulled)
+	if len(result.Conflicts) > 0 {
+		fmt.Printf("%d conflicts need resolving, run 'irontask sync conflicts'\n", len(result.Conflicts))
+	}
+	printFailed(result.Failed)
+	return nil
+}
+
+// printVerifyItems lists divergent items under a heading
+func printVerifyItems(heading string, items []sync.VerifyItem) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Printf("\n%s (%d):\n", heading, len(items))
+	for _, v := range items {
+		id := v.ID
+		if len(id) > 8 {
+			id = id[:8]
+		}
+		fmt.Printf("  %-7s  %-8s  %q  local v%d, server v%d\n", v.Type, id, v.Name, v.LocalVersion, v.ServerVersion)
+	}
+}
+
 func runSyncStatus(cmd *cobra.Command, args []string) error {
 	client, err := sync.NewClient()
 	if err != nil {
@@ -100,11 +486,35 @@ func runSyncStatus(cmd *cobra.Command, args []string) error {
 
 	serverURL, userID, lastSync := client.GetStatus()
 
-	fmt.Printf("Server:    %s\n", serverURL)
+	fmt.Printf("Profile:   %s\n", config.ActiveProfile())
+	if client.Folder() != "" {
+		fmt.Printf("Folder:    %s\n", client.Folder())
+	} else if client.Git() != "" {
+		fmt.Printf("Git:       %s\n", client.Git())
+	} else {
+		fmt.Printf("Server:    %s\n", serverURL)
+	}
 	if client.IsLoggedIn() {
-		fmt.Printf("User ID:   %s\n", userID)
+		if userID != "" {
+			fmt.Printf("User ID:   %s\n", userID)
+		}
 		fmt.Printf("Last Sync: %d\n", lastSync)
 		fmt.Println("Status:    [OK] Logged in")
+		if status, err := daemon.GetStatus(); err == nil {
+			fmt.Printf("Daemon:    running (pid %d)\n", status.PID)
+		}
+		if client.HasEncryptionKey() {
+			fmt.Printf("Encryption: [OK] %s\n", client.KeyFingerprint())
+		} else {
+			fmt.Println("Encryption: Not configured (run 'irontask sync key')")
+		}
+		if database, err := db.OpenDefault(); err == nil {
+			conflicts, _ := sync.Conflicts(database)
+			_ = database.Close()
+			if len(conflicts) > 0 {
+				fmt.Printf("Conflicts: %d (run 'irontask sync conflicts')\n", len(conflicts))
+			}
+		}
 	} else {
 		fmt.Println("Status:    Not logged in")
 	}
@@ -117,36 +527,202 @@ func runSyncKey(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	unlock, err := client.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if client.HasEncryptionKey() {
+		fmt.Printf("Encryption key is configured (fingerprint: %s)\n", client.KeyFingerprint())
+		return nil
+	}
+
+	if !client.IsLoggedIn() {
+		return fmt.Errorf("not logged in, run 'irontask auth login' first")
+	}
 
-	key := client.GetEncryptionKey()
-	if key == "" {
-		// Generate new key
-		fmt.Print("Enter encryption password: ")
-		reader := bufio.NewReader(os.Stdin)
-		password, _ := reader.ReadString('\n')
-		password = strings.TrimSpace(password)
+	exists, err := client.RemoteKeyExists()
+	if err != nil {
+		return err
+	}
 
-		if len(password) < 8 {
-			return fmt.Errorf("password must be at least 8 characters")
+	if exists {
+		// Another device already set up encryption, derive the same key here
+		password := readPassword("Enter encryption password: ")
+		if err := client.UnlockEncryptionKey(password); err != nil {
+			return err
+		}
+		fmt.Println("\n[OK] Encryption key unlocked on this device!")
+	} else {
+		password, err := readNewPassword()
+		if err != nil {
+			return err
 		}
 
-		key, err = client.GenerateEncryptionKey(password)
+		phrase, err := client.CreateEncryptionKey(password)
 		if err != nil {
 			return err
 		}
 		fmt.Println("\n[OK] Encryption key generated!")
-		fmt.Println("\nIMPORTANT: Save this key! You need it to decrypt on other devices.")
+		fmt.Println("\nIMPORTANT: Remember this password! You need it to decrypt on other devices.")
+		printRecoveryPhrase(phrase)
+	}
+
+	fmt.Printf("\nKey fingerprint: %s\n", client.KeyFingerprint())
+	return nil
+}
+
+func runSyncKeyRotate(cmd *cobra.Command, args []string) error {
+	client, err := sync.NewClient()
+	if err != nil {
+		return err
+	}
+	unlock, err := client.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !client.IsLoggedIn() {
+		return fmt.Errorf("not logged in, run 'irontask auth login' first")
+	}
+	if !client.HasEncryptionKey() {
+		return sync.ErrNoEncryptionKey
+	}
+
+	database, err := db.OpenDefault()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = database.Close()
+	}()
+
+	password, err := readNewPassword()
+	if err != nil {
+		return err
+	}
+
+	oldFingerprint := client.KeyFingerprint()
+	fmt.Println("Re-encrypting synced data...")
+	result, phrase, err := client.RotateEncryptionKey(database, password)
+	if err != nil {
+		if phrase != "" {
+			// The new key is already active, its phrase must not get lost
+			printRecoveryPhrase(phrase)
+		}
+		return err
+	}
+
+	fmt.Printf("\n[OK] Encryption key rotated: %s -> %s\n", oldFingerprint, client.KeyFingerprint())
+	fmt.Printf("Re-encrypted and pushed %d items\n", result.Pushed)
+	fmt.Println("\nOther devices will ask for the new password on their next sync ('irontask sync key').")
+	printRecoveryPhrase(phrase)
+	return nil
+}
+
+func runSyncKeyRecover(cmd *cobra.Command, args []string) error {
+	client, err := sync.NewClient()
+	if err != nil {
+		return err
+	}
+	unlock, err := client.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !client.IsLoggedIn() {
+		return fmt.Errorf("not logged in, run 'irontask auth login' first")
+	}
+
+	fmt.Print("Enter recovery phrase: ")
+	reader := bufio.NewReader(os.Stdin)
+	phrase, _ := reader.ReadString('\n')
+
+	password, err := readNewPassword()
+	if err != nil {
+		return err
+	}
+
+	if err := client.RecoverEncryptionKey(phrase, password); err != nil {
+		return err
 	}
 
-	fmt.Printf("\nEncryption Key: %s\n", key)
+	fmt.Println("\n[OK] Encryption key recovered!")
+	fmt.Printf("Key fingerprint: %s\n", client.KeyFingerprint())
+	fmt.Println("\nUse the new password on devices you set up from now on.")
 	return nil
 }
 
+func runSyncKeyPasswd(cmd *cobra.Command, args []string) error {
+	client, err := sync.NewClient()
+	if err != nil {
+		return err
+	}
+	unlock, err := client.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !client.IsLoggedIn() {
+		return fmt.Errorf("not logged in, run 'irontask auth login' first")
+	}
+
+	oldPassword := readPassword("Enter current encryption password: ")
+	password, err := readNewPassword()
+	if err != nil {
+		return err
+	}
+
+	if err := client.ChangeEncryptionPassword(oldPassword, password); err != nil {
+		return err
+	}
+
+	fmt.Println("\n[OK] Encryption password changed!")
+	return nil
+}
+
+// readNewPassword prompts for a new encryption password twice
+func readNewPassword() (string, error) {
+	password := readPassword("Enter new encryption password: ")
+	if len(password) < 8 {
+		return "", fmt.Errorf("password must be at least 8 characters")
+	}
+	if confirm := readPassword("Confirm encryption password: "); confirm != password {
+		return "", fmt.Errorf("passwords do not match")
+	}
+	return password, nil
+}
+
+// printRecoveryPhrase shows a recovery phrase once, it is not stored anywhere
+func printRecoveryPhrase(phrase string) {
+	fmt.Println("\nRecovery phrase (write it down and keep it somewhere safe):")
+	fmt.Printf("\n  %s\n\n", phrase)
+	fmt.Println("If you forget your encryption password, 'irontask sync key recover' restores")
+	fmt.Println("access with this phrase. It is shown only once.")
+}
+
+// readPassword prompts for a password without echoing it
+func readPassword(prompt string) string {
+	fmt.Print(prompt)
+	passwordBytes, _ := term.ReadPassword(int(syscall.Stdin))
+	fmt.Println()
+	return strings.TrimSpace(string(passwordBytes))
+}
+
 func runSyncConfig(cmd *cobra.Command, args []string) error {
 	client, err := sync.NewClient()
 	if err != nil {
 		return err
 	}
+	unlock, err := client.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	server, _ := cmd.Flags().GetString("server")
 	if server != "" {
@@ -154,10 +730,103 @@ func runSyncConfig(cmd *cobra.Command, args []string) error {
 			return err
 		}
 		fmt.Printf("[OK] Server set to: %s\n", server)
-	} else {
+	}
+
+	if cmd.Flags().Changed("opaque") {
+		opaque, _ := cmd.Flags().GetBool("opaque")
+
+		database, err := db.OpenDefault()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = database.Close()
+		}()
+
+		if err := client.SetOpaque(database, opaque); err != nil {
+			return err
+		}
+		if opaque {
+			fmt.Println("[OK] Opaque mode enabled, the server only stores encrypted blobs")
+			fmt.Println("Enable it on your other devices too.")
+		} else {
+			fmt.Println("[OK] Opaque mode disabled")
+		}
+		fmt.Println("Everything is re-uploaded on the next sync.")
+	}
+
+	if cmd.Flags().Changed("batch-size") {
+		size, _ := cmd.Flags().GetInt("batch-size")
+		if err := client.SetBatchSize(size); err != nil {
+			return err
+		}
+		fmt.Printf("[OK] Batch size set to: %d\n", client.BatchSize())
+	}
+
+	if cmd.Flags().Changed("folder") {
+		folder, _ := cmd.Flags().GetString("folder")
+
+		database, err := db.OpenDefault()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = database.Close()
+		}()
+
+		if err := client.SetFolder(database, folder); err != nil {
+			return err
+		}
+		if client.Folder() != "" {
+			fmt.Printf("[OK] Syncing through folder: %s\n", client.Folder())
+			fmt.Println("Share it with your other devices (e.g. with Syncthing or Dropbox) and set it there too.")
+			if !client.HasEncryptionKey() {
+				fmt.Println("Set up encryption with 'irontask sync key', then run 'irontask sync'.")
+			}
+		} else {
+			fmt.Println("[OK] Syncing with the server again")
+		}
+		fmt.Println("Everything is re-uploaded on the next sync.")
+	}
+
+	if cmd.Flags().Changed("git") {
+		remote, _ := cmd.Flags().GetString("git")
+
+		database, err := db.OpenDefault()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = database.Close()
+		}()
+
+		if err := client.SetGit(database, remote); err != nil {
+			return err
+		}
+		if client.Git() != "" {
+			fmt.Printf("[OK] Syncing through git repository: %s\n", client.Git())
+			fmt.Println("Set the same repository on your other devices.")
+			if !client.HasEncryptionKey() {
+				fmt.Println("Set up encryption with 'irontask sync key', then run 'irontask sync'.")
+			}
+		} else {
+			fmt.Println("[OK] Syncing with the server again")
+		}
+		fmt.Println("Everything is re-uploaded on the next sync.")
+	}
+
+	if server == "" && !cmd.Flags().Changed("opaque") && !cmd.Flags().Changed("batch-size") && !cmd.Flags().Changed("folder") && !cmd.Flags().Changed("git") {
 		// Just show config
 		url, _, _ := client.GetStatus()
 		fmt.Printf("Server: %s\n", url)
+		if client.Folder() != "" {
+			fmt.Printf("Folder: %s\n", client.Folder())
+		}
+		if client.Git() != "" {
+			fmt.Printf("Git: %s\n", client.Git())
+		}
+		fmt.Printf("Opaque: %v\n", client.IsOpaque())
+		fmt.Printf("Batch size: %d\n", client.BatchSize())
 	}
 
 	return nil
@@ -0,0 +1,273 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+var syncConflictsCmd = &cobra.Command{
+	Use:   "conflicts",
+	Short: "List, show and resolve conflicts of the last sync",
+	Long: `Conflicts that could not be merged automatically are kept on this device
+until they are resolved. Conflicting items are not changed by later syncs.
+
+Examples:
+  irontask sync conflicts                    # List unresolved conflicts
+  irontask sync conflicts show 1a2b          # Compare the versions of an item
+  irontask sync conflicts resolve 1a2b --keep merged
+  irontask sync conflicts resolve --all --keep server`,
+	RunE: runSyncConflictsList,
+}
+
+var syncConflictsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List unresolved conflicts",
+	RunE:  runSyncConflictsList,
+}
+
+var syncConflictsShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show the base, local, server and merged version of a conflicting item",
+	Long: `Show every synced field of a conflicting item side by side: the version
+last synced (base), this device's, the server's and the result of
+'--keep merged'. Fields changed on both sides are marked with '!'.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runSyncConflictsShow,
+}
+
+var syncConflictsResolveCmd = &cobra.Command{
+	Use:   "resolve [<id>...]",
+	Short: "Resolve conflicts and sync the result",
+	Long: `Resolve conflicts by id (or id prefix), or all of them with --all, then sync.
+
+  --keep local    keep this device's version
+  --keep server   keep the server's version
+  --keep merged   keep the changes of both sides, fields changed on both
+                  sides take the newer edit`,
+	RunE: runSyncConflictsResolve,
+}
+
+func init() {
+	syncCmd.AddCommand(syncConflictsCmd)
+	syncConflictsCmd.AddCommand(syncConflictsListCmd)
+	syncConflictsCmd.AddCommand(syncConflictsShowCmd)
+	syncConflictsCmd.AddCommand(syncConflictsResolveCmd)
+
+	syncConflictsCmd.Flags().Bool("json", false, "Print as JSON")
+	syncConflictsListCmd.Flags().Bool("json", false, "Print as JSON")
+
+	syncConflictsResolveCmd.Flags().String("keep", "", "Version to keep: local, server or merged")
+	syncConflictsResolveCmd.Flags().Bool("all", false, "Resolve every conflict")
+}
+
+// conflictSummary is a listed conflict
+type conflictSummary struct {
+	ID            string    `json:"id"`
+	Type          string    `json:"type"`
+	Name          string    `json:"name"`
+	Fields        []string  `json:"fields"`
+	ServerVersion int64     `json:"server_version"`
+	DetectedAt    time.Time `json:"detected_at"`
+}
+
+func runSyncConflictsList(cmd *cobra.Command, args []string) error {
+	client, err := sync.NewClient()
+	if err != nil {
+		return err
+	}
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	database, err := db.OpenDefault()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer func() {
+		_ = database.Close()
+	}()
+
+	conflicts, err := sync.Conflicts(database)
+	if err != nil {
+		return fmt.Errorf("failed to read conflicts: %w", err)
+	}
+
+	summaries := []conflictSummary{}
+	for _, conflict := range conflicts {
+		name, _ := client.DescribeItem(conflict.ClientData)
+		fields := []string{}
+		for _, f := range conflict.Fields {
+			fields = append(fields, f.Field)
+		}
+		summaries = append(summaries, conflictSummary{
+			ID:            conflict.ClientID,
+			Type:          conflict.Type,
+			Name:          name,
+			Fields:        fields,
+			ServerVersion: conflict.ServerVersion,
+			DetectedAt:    conflict.DetectedAt,
+		})
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summaries)
+	}
+
+	if len(summaries) == 0 {
+		fmt.Println("[OK] No conflicts")
+		return nil
+	}
+	for _, s := range summaries {
+		fields := strings.Join(s.Fields, ", ")
+		if fields == "" {
+			fields = "whole item"
+		}
+		fmt.Printf("  %-8s  %-7s  %-30s  %-24s  %s\n",
+			shortID(s.ID), s.Type, cell(s.Name, 30), cell(fields, 24),
+			s.DetectedAt.Local().Format("2006-01-02 15:04"))
+	}
+	fmt.Printf("\n%d conflicts, see 'irontask sync conflicts show <id>'\n", len(summaries))
+	return nil
+}
+
+func runSyncConflictsShow(cmd *cobra.Command, args []string) error {
+	client, err := sync.NewClient()
+	if err != nil {
+		return err
+	}
+
+	database, err := db.OpenDefault()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer func() {
+		_ = database.Close()
+	}()
+
+	conflict, err := sync.FindConflict(database, args[0])
+	if err != nil {
+		return err
+	}
+
+	name, _ := client.DescribeItem(conflict.ClientData)
+	fmt.Printf("%s %s %q\n", conflict.Type, conflict.ClientID, name)
+	fmt.Printf("Detected %s, server version %d\n\n",
+		conflict.DetectedAt.Local().Format("2006-01-02 15:04"), conflict.ServerVersion)
+
+	diff, err := client.DiffConflict(database, conflict.ConflictItem)
+	if err != nil {
+		return fmt.Errorf("cannot compare versions: %w", err)
+	}
+
+	fmt.Printf("  %-10s  %-20s  %-20s  %-20s  %-20s\n", "FIELD", "BASE", "LOCAL", "SERVER", "MERGED")
+	for _, d := range diff {
+		mark := " "
+		if d.Conflict {
+			mark = "!"
+		}
+		fmt.Printf("%s %-10s  %-20s  %-20s  %-20s  %-20s\n",
+			mark, d.Field, cell(d.Base, 20), cell(d.Local, 20), cell(d.Server, 20), cell(d.Merged, 20))
+	}
+	fmt.Printf("\nResolve with 'irontask sync conflicts resolve %s --keep local|server|merged'\n", shortID(conflict.ClientID))
+	return nil
+}
+
+func runSyncConflictsResolve(cmd *cobra.Command, args []string) error {
+	client, err := sync.NewClient()
+	if err != nil {
+		return err
+	}
+	unlock, err := client.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keep, _ := cmd.Flags().GetString("keep")
+	all, _ := cmd.Flags().GetBool("all")
+	how := sync.Resolution(keep)
+	switch how {
+	case sync.ResolveLocal, sync.ResolveServer, sync.ResolveMerged:
+	case "":
+		return fmt.Errorf("choose the version to keep with --keep local, server or merged")
+	default:
+		return fmt.Errorf("invalid --keep %q, use local, server or merged", keep)
+	}
+	if all == (len(args) > 0) {
+		return fmt.Errorf("name the conflicts to resolve or use --all")
+	}
+
+	database, err := db.OpenDefault()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer func() {
+		_ = database.Close()
+	}()
+
+	var conflicts []sync.StoredConflict
+	if all {
+		conflicts, err = sync.Conflicts(database)
+		if err != nil {
+			return fmt.Errorf("failed to read conflicts: %w", err)
+		}
+	} else {
+		for _, ref := range args {
+			conflict, err := sync.FindConflict(database, ref)
+			if err != nil {
+				return err
+			}
+			conflicts = append(conflicts, *conflict)
+		}
+	}
+	if len(conflicts) == 0 {
+		fmt.Println("[OK] No conflicts")
+		return nil
+	}
+
+	for _, conflict := range conflicts {
+		if err := client.Resolve(database, conflict.ConflictItem, how); err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", conflict.ClientID, err)
+		}
+	}
+	fmt.Printf("Resolved %d conflicts, keeping the %s version\n", len(conflicts), how)
+
+	if !client.IsLoggedIn() {
+		return nil
+	}
+	fmt.Println("Synchronizing...")
+	result, err := client.SyncWithTrigger(database, sync.SyncModeMerge, sync.TriggerResolve)
+	if err != nil {
+		return fmt.Errorf("sync failed, the resolved versions are pushed by the next sync: %w", err)
+	}
+	fmt.Printf("[OK] Sync complete! Pushed: %d, Pulled: %d\n", result.Pushed, result.Pulled)
+	if len(result.Conflicts) > 0 {
+		fmt.Printf("%d conflicts need resolving, run 'irontask sync conflicts'\n", len(result.Conflicts))
+	}
+	printFailed(result.Failed)
+	return nil
+}
+
+// shortID returns the first 8 characters of an item id
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// cell fits a value on one line of at most width characters
+func cell(s string, width int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	if r := []rune(s); len(r) > width {
+		return string(r[:width-1]) + "…"
+	}
+	return s
+}
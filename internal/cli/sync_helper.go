@@ -3,6 +3,7 @@ package cli
 import (
 	"fmt"
 
+	"github.com/existflow/irontask/internal/daemon"
 	"github.com/existflow/irontask/internal/db"
 	"github.com/existflow/irontask/internal/sync"
 )
@@ -15,11 +16,19 @@ func MaybeSyncCLI(dbConn *db.DB, forceSync bool) *sync.Client {
 		return nil
 	}
 
+	// A running daemon keeps the data fresh
+	if daemon.Running() {
+		if forceSync {
+			syncWithDaemon()
+		}
+		return client
+	}
+
 	shouldSync := forceSync || client.ShouldAutoSync()
 
 	if shouldSync {
 		fmt.Println("Syncing...")
-		result, err := client.Sync(dbConn, sync.SyncModeMerge)
+		result, err := client.SyncWithTrigger(dbConn, sync.SyncModeMerge, sync.TriggerCLI)
 		if err != nil {
 			fmt.Printf("Sync failed: %v\n", err)
 		} else {
@@ -42,11 +51,21 @@ func MaybeSyncAfterChange(dbConn *db.DB, forceSync bool) {
 		return
 	}
 
+	// A running daemon pushes the change, tell it not to wait
+	if daemon.Running() {
+		if forceSync {
+			syncWithDaemon()
+		} else if err := daemon.Trigger(); err != nil {
+			fmt.Printf("Failed to notify the sync daemon: %v\n", err)
+		}
+		return
+	}
+
 	shouldSync := forceSync || client.ShouldAutoSync()
 
 	if shouldSync {
 		fmt.Println("Syncing changes...")
-		result, err := client.Sync(dbConn, sync.SyncModeMerge)
+		result, err := client.SyncWithTrigger(dbConn, sync.SyncModeMerge, sync.TriggerCLI)
 		if err != nil {
 			fmt.Printf("Sync failed: %v\n", err)
 		} else {
@@ -55,3 +74,14 @@ func MaybeSyncAfterChange(dbConn *db.DB, forceSync bool) {
 		}
 	}
 }
+
+// syncWithDaemon has the running daemon sync now and waits for it
+func syncWithDaemon() {
+	fmt.Println("Syncing with the daemon...")
+	result, err := daemon.Sync()
+	if err != nil {
+		fmt.Printf("Sync failed: %v\n", err)
+		return
+	}
+	fmt.Printf("[OK] Synced (↑%d ↓%d)\n", result.Pushed, result.Pulled)
+}
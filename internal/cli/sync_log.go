@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+var syncLogCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Show the history of syncs on this device",
+	Long: `Show what each sync did: when and how it was started, how many items it
+pushed and pulled, conflicts, errors and the server versions it synced.
+
+Examples:
+  irontask sync log                      # Last 20 syncs
+  irontask sync log --since 24h --changes
+  irontask sync log --errors --trigger auto
+  irontask sync log --json -n 0          # Whole history as JSON`,
+	RunE: runSyncLog,
+}
+
+func init() {
+	syncCmd.AddCommand(syncLogCmd)
+
+	syncLogCmd.Flags().IntP("limit", "n", 20, "Number of syncs to show, 0 for all")
+	syncLogCmd.Flags().String("since", "", "Only syncs since a duration ago (24h, 7d) or a date (2006-01-02)")
+	syncLogCmd.Flags().String("trigger", "", "Only syncs started by manual, auto, cli, resolve, repair, key-rotate or opaque")
+	syncLogCmd.Flags().String("mode", "", "Only syncs in mode merge, pull, push, reseed or rebuild")
+	syncLogCmd.Flags().Bool("errors", false, "Only failed or interrupted syncs")
+	syncLogCmd.Flags().Bool("changes", false, "Only syncs that pushed or pulled something")
+	syncLogCmd.Flags().Bool("json", false, "Print as JSON")
+}
+
+func runSyncLog(cmd *cobra.Command, args []string) error {
+	filter := sync.SyncRunFilter{}
+	filter.Limit, _ = cmd.Flags().GetInt("limit")
+	filter.Errors, _ = cmd.Flags().GetBool("errors")
+	filter.Changes, _ = cmd.Flags().GetBool("changes")
+	filter.Mode, _ = cmd.Flags().GetString("mode")
+	trigger, _ := cmd.Flags().GetString("trigger")
+	filter.Trigger = sync.SyncTrigger(trigger)
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	if since, _ := cmd.Flags().GetString("since"); since != "" {
+		t, err := parseSince(since)
+		if err != nil {
+			return err
+		}
+		filter.Since = t
+	}
+
+	database, err := db.OpenDefault()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer func() {
+		_ = database.Close()
+	}()
+
+	runs, err := sync.SyncRuns(database, filter)
+	if err != nil {
+		return fmt.Errorf("failed to read sync history: %w", err)
+	}
+
+	if asJSON {
+		if runs == nil {
+			runs = []sync.SyncRun{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(runs)
+	}
+
+	if len(runs) == 0 {
+		fmt.Println("No syncs recorded")
+		return nil
+	}
+	for _, run := range runs {
+		to := "?"
+		if run.FinishedAt != nil {
+			to = fmt.Sprintf("v%d", run.ToVersion)
+		}
+		fmt.Printf("#%-5d %s  %-10s %-7s  ↑%-4d ↓%-4d  v%d → %s  %s\n",
+			run.ID,
+			run.StartedAt.Local().Format("2006-01-02 15:04:05"),
+			run.Trigger,
+			run.Mode,
+			run.Pushed,
+			run.Pulled,
+			run.FromVersion,
+			to,
+			runOutcome(run))
+	}
+	return nil
+}
+
+// runOutcome describes how a sync ended and how long it took
+func runOutcome(run sync.SyncRun) string {
+	if run.FinishedAt == nil {
+		return "interrupted"
+	}
+	took := run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond)
+	switch {
+	case run.Error != "":
+		return fmt.Sprintf("failed after %s: %s", took, run.Error)
+	case run.Conflicts > 0 || run.Failed > 0:
+		return fmt.Sprintf("%d conflicts, %d failed (%s)", run.Conflicts, run.Failed, took)
+	}
+	return fmt.Sprintf("ok (%s)", took)
+}
+
+// parseSince parses a duration before now, in days (7d) or as a Go duration,
+// or a date
+func parseSince(s string) (time.Time, error) {
+	if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && strings.HasSuffix(s, "d") {
+		return time.Now().AddDate(0, 0, -days), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q, use a duration (24h, 7d) or a date (2006-01-02)", s)
+}
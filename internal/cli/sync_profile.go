@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/existflow/irontask/internal/config"
+	"github.com/existflow/irontask/internal/sync"
+	"github.com/spf13/cobra"
+)
+
+var syncProfileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Manage sync profiles",
+	Long: `Each profile has its own server, account, encryption key and local
+database, e.g. a work account on a self-hosted server and a personal one.
+
+Commands:
+  irontask sync profile list         # List profiles
+  irontask sync profile add <name>   # Create a profile
+  irontask sync profile use <name>   # Switch to a profile
+
+Use --profile <name> to run a single command with another profile.`,
+	RunE: runSyncProfileList,
+}
+
+var syncProfileListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List sync profiles",
+	RunE:  runSyncProfileList,
+}
+
+var syncProfileAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Create a sync profile",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSyncProfileAdd,
+}
+
+var syncProfileUseCmd = &cobra.Command{
+	Use:   "use <name>",
+	Short: "Switch to a sync profile",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runSyncProfileUse,
+}
+
+func init() {
+	syncCmd.AddCommand(syncProfileCmd)
+	syncProfileCmd.AddCommand(syncProfileListCmd)
+	syncProfileCmd.AddCommand(syncProfileAddCmd)
+	syncProfileCmd.AddCommand(syncProfileUseCmd)
+
+	syncProfileAddCmd.Flags().String("server", "", "Server URL of the profile")
+}
+
+func runSyncProfileList(cmd *cobra.Command, args []string) error {
+	names, err := config.ListProfiles()
+	if err != nil {
+		return err
+	}
+
+	active := config.ActiveProfile()
+	for _, name := range names {
+		client, err := sync.NewProfileClient(name)
+		if err != nil {
+			return err
+		}
+		marker := " "
+		if name == active {
+			marker = "*"
+		}
+		status := "not logged in"
+		if client.IsLoggedIn() {
+			status = "logged in"
+		}
+		url, _, _ := client.GetStatus()
+		fmt.Printf("%s %-12s  %-40s  %s\n", marker, name, url, status)
+	}
+	return nil
+}
+
+func runSyncProfileAdd(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	if err := config.AddProfile(name); err != nil {
+		return err
+	}
+
+	if server, _ := cmd.Flags().GetString("server"); server != "" {
+		client, err := sync.NewProfileClient(name)
+		if err != nil {
+			return err
+		}
+		if err := client.SetServer(server); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("[OK] Profile %q created\n", name)
+	fmt.Printf("Switch to it with 'irontask sync profile use %s', then log in.\n", name)
+	return nil
+}
+
+func runSyncProfileUse(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	if err := config.UseProfile(name); err != nil {
+		return err
+	}
+	fmt.Printf("[OK] Using profile %q\n", name)
+	return nil
+}
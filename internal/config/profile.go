@@ -0,0 +1,152 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultProfile is the profile of installations set up before profiles
+// existed, its files stay directly in ~/.irontask
+const DefaultProfile = "default"
+
+// profileName limits names to what is safe as a directory name
+var profileName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// profileOverride is the profile selected for this run, e.g. with --profile
+var profileOverride string
+
+// SetProfile selects the profile for the rest of this run
+func SetProfile(name string) error {
+	if err := checkProfile(name); err != nil {
+		return err
+	}
+	profileOverride = name
+	return nil
+}
+
+// ActiveProfile returns the profile in use: the one selected for this run,
+// IRONTASK_PROFILE, or the one chosen with UseProfile
+func ActiveProfile() string {
+	if profileOverride != "" {
+		return profileOverride
+	}
+	if name := os.Getenv("IRONTASK_PROFILE"); name != "" {
+		return name
+	}
+	path, err := activeProfilePath()
+	if err != nil {
+		return DefaultProfile
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return DefaultProfile
+	}
+	if name := strings.TrimSpace(string(data)); name != "" {
+		return name
+	}
+	return DefaultProfile
+}
+
+// UseProfile makes name the active profile of later runs
+func UseProfile(name string) error {
+	if err := checkProfile(name); err != nil {
+		return err
+	}
+	path, err := activeProfilePath()
+	if err != nil {
+		return err
+	}
+	if name == DefaultProfile {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(name), 0644)
+}
+
+// ProfileDir returns the directory holding the database and sync settings
+// of a profile
+func ProfileDir(name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if name == DefaultProfile {
+		return filepath.Join(home, ".irontask"), nil
+	}
+	if !profileName.MatchString(name) {
+		return "", fmt.Errorf("invalid profile name %q", name)
+	}
+	return filepath.Join(home, ".irontask", "profiles", name), nil
+}
+
+// AddProfile creates a new, empty profile
+func AddProfile(name string) error {
+	if !profileName.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q, use lowercase letters, digits, - and _", name)
+	}
+	if name == DefaultProfile {
+		return fmt.Errorf("profile %q already exists", name)
+	}
+	dir, err := ProfileDir(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("profile %q already exists", name)
+	}
+	return os.MkdirAll(dir, 0700)
+}
+
+// ListProfiles returns the names of all profiles, the default one first
+func ListProfiles() ([]string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(home, ".irontask", "profiles"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() && profileName.MatchString(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return append([]string{DefaultProfile}, names...), nil
+}
+
+// checkProfile returns an error unless the profile exists
+func checkProfile(name string) error {
+	if name == DefaultProfile {
+		return nil
+	}
+	dir, err := ProfileDir(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("profile %q not found, create it with 'irontask sync profile add %s'", name, name)
+	}
+	return nil
+}
+
+// activeProfilePath returns the file naming the active profile
+func activeProfilePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".irontask", "profile"), nil
+}
@@ -0,0 +1,174 @@
+// Package daemon syncs a profile in the background and answers CLI commands
+// and the TUI over a Unix domain socket in the profile directory. Every
+// connection carries one JSON request and one JSON response.
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/existflow/irontask/internal/config"
+	"github.com/existflow/irontask/internal/sync"
+)
+
+// ErrNotRunning is returned when no daemon serves the active profile
+var ErrNotRunning = errors.New("daemon is not running")
+
+// Requests understood by the daemon
+const (
+	cmdStatus  = "status"  // Report the Status
+	cmdSync    = "sync"    // Sync now and report the Result
+	cmdTrigger = "trigger" // Sync after the debounce delay, without waiting
+	cmdStop    = "stop"    // Shut down after pushing pending changes
+)
+
+// Timeouts of requests, a sync may take long on a slow connection
+const (
+	dialTimeout    = time.Second
+	requestTimeout = 5 * time.Second
+	syncTimeout    = 5 * time.Minute
+)
+
+// Status is the state of a running daemon
+type Status struct {
+	PID          int       `json:"pid"`
+	Profile      string    `json:"profile"`
+	StartedAt    time.Time `json:"started_at"`
+	Syncing      bool      `json:"syncing"`
+	Offline      bool      `json:"offline"`
+	OfflineSince time.Time `json:"offline_since"`
+	NextRetry    time.Time `json:"next_retry"` // Zero unless a retry is scheduled
+	Pending      int       `json:"pending"`    // Local changes not pushed yet
+	Conflicts    int       `json:"conflicts"`  // Unresolved conflicts
+	SyncVersion  int64     `json:"sync_version"`
+	Pulled       int64     `json:"pulled"`     // Syncs that pulled changes, followers reload when it grows
+	Conflicted   int64     `json:"conflicted"` // Syncs that reported conflicts
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// Sync returns the state of background syncing as AutoSync reports it
+func (s Status) Sync() sync.Status {
+	status := sync.Status{
+		Syncing:      s.Syncing,
+		Offline:      s.Offline,
+		OfflineSince: s.OfflineSince,
+		Pending:      s.Pending,
+		NextRetry:    s.NextRetry,
+	}
+	if s.LastError != "" {
+		status.LastError = errors.New(s.LastError)
+	}
+	return status
+}
+
+// Result is the outcome of a sync run by the daemon
+type Result struct {
+	Pushed    int               `json:"pushed"`
+	Pulled    int               `json:"pulled"`
+	Conflicts int               `json:"conflicts"`
+	Failed    []sync.FailedItem `json:"failed,omitempty"`
+}
+
+type request struct {
+	Command string `json:"command"`
+}
+
+type response struct {
+	Status *Status `json:"status,omitempty"`
+	Result *Result `json:"result,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// SocketPath returns the socket of the active profile's daemon
+func SocketPath() (string, error) {
+	dir, err := config.ProfileDir(config.ActiveProfile())
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "daemon.sock"), nil
+}
+
+// Running reports whether a daemon serves the active profile
+func Running() bool {
+	_, err := GetStatus()
+	return err == nil
+}
+
+// GetStatus asks the daemon for its state
+func GetStatus() (*Status, error) {
+	resp, err := call(cmdStatus, requestTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Status, nil
+}
+
+// Sync asks the daemon to sync now and waits for the result
+func Sync() (*Result, error) {
+	resp, err := call(cmdSync, syncTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Result, nil
+}
+
+// Trigger asks the daemon to sync soon, e.g. after a local change
+func Trigger() error {
+	_, err := call(cmdTrigger, requestTimeout)
+	return err
+}
+
+// Stop asks the daemon to shut down and waits until it is gone
+func Stop() error {
+	if _, err := call(cmdStop, syncTimeout); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(syncTimeout); time.Now().Before(deadline); {
+		if !Running() {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("daemon did not stop")
+}
+
+// call sends one request to the daemon
+func call(command string, timeout time.Duration) (*response, error) {
+	path, err := SocketPath()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", path, dialTimeout)
+	if err != nil {
+		return nil, ErrNotRunning
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := json.NewEncoder(conn).Encode(request{Command: command}); err != nil {
+		return nil, err
+	}
+	var resp response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("invalid daemon response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// removeStale removes the socket of a daemon that did not shut down cleanly
+func removeStale(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
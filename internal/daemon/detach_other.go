@@ -0,0 +1,8 @@
+//go:build !unix
+
+package daemon
+
+import "os/exec"
+
+// detach needs nothing where closing the terminal does not stop its children
+func detach(cmd *exec.Cmd) {}
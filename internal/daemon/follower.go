@@ -0,0 +1,125 @@
+package daemon
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/internal/sync"
+)
+
+// followInterval is how often a follower asks the daemon for its state
+const followInterval = time.Second
+
+// errStopped is reported by followers once their daemon is gone
+var errStopped = errors.New("sync daemon stopped")
+
+// Follower lets the TUI show the state of a running daemon and hand its
+// changes to it, in place of an AutoSync of its own
+type Follower struct {
+	db         *db.DB
+	onPull     func()                    // Called when the daemon pulled remote changes
+	onConflict func([]sync.ConflictItem) // Called when a sync of the daemon left conflicts
+	status     atomic.Pointer[Status]
+	stopCh     chan struct{}
+}
+
+// Follow starts following the daemon of the active profile. Stored
+// conflicts are reported right away.
+func Follow(dbConn *db.DB, onPull func(), onConflict func([]sync.ConflictItem)) *Follower {
+	f := &Follower{
+		db:         dbConn,
+		onPull:     onPull,
+		onConflict: onConflict,
+		stopCh:     make(chan struct{}),
+	}
+	f.status.Store(&Status{})
+	go f.loop()
+	return f
+}
+
+func (f *Follower) loop() {
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+
+	var prev *Status
+	for {
+		status, err := GetStatus()
+		if err != nil {
+			stopped := *f.status.Load()
+			stopped.Syncing = false
+			stopped.LastError = errStopped.Error()
+			f.status.Store(&stopped)
+		} else {
+			f.status.Store(status)
+			f.notify(prev, status)
+			prev = status
+		}
+
+		select {
+		case <-ticker.C:
+		case <-f.stopCh:
+			return
+		}
+	}
+}
+
+// notify calls the callbacks for what the daemon did since the last status
+func (f *Follower) notify(prev, status *Status) {
+	if prev != nil && status.Pulled > prev.Pulled && f.onPull != nil {
+		f.onPull()
+	}
+	if status.Conflicts == 0 || f.onConflict == nil {
+		return
+	}
+	if prev != nil && status.Conflicted <= prev.Conflicted {
+		return
+	}
+	stored, err := sync.Conflicts(f.db)
+	if err != nil {
+		logger.Warn("Failed to read conflicts", logger.F("error", err))
+		return
+	}
+	conflicts := make([]sync.ConflictItem, 0, len(stored))
+	for _, c := range stored {
+		conflicts = append(conflicts, c.ConflictItem)
+	}
+	f.onConflict(conflicts)
+}
+
+// TriggerSync asks the daemon to sync soon
+func (f *Follower) TriggerSync() {
+	go func() {
+		if err := Trigger(); err != nil {
+			logger.Warn("Failed to notify the sync daemon", logger.F("error", err))
+		}
+	}()
+}
+
+// IsPending returns true if the daemon is syncing or about to
+func (f *Follower) IsPending() bool {
+	return f.status.Load().Syncing
+}
+
+// IsOffline returns true if the last sync of the daemon could not reach the
+// server
+func (f *Follower) IsOffline() bool {
+	return f.status.Load().Offline
+}
+
+// GetLastError returns the last sync error of the daemon
+func (f *Follower) GetLastError() error {
+	return f.status.Load().Sync().LastError
+}
+
+// Status returns the state of background syncing in the daemon
+func (f *Follower) Status() sync.Status {
+	return f.status.Load().Sync()
+}
+
+// Stop stops following the daemon, the daemon keeps running
+func (f *Follower) Stop() {
+	close(f.stopCh)
+}
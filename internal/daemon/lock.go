@@ -0,0 +1,41 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/existflow/irontask/internal/config"
+)
+
+// lock takes the daemon lock of the active profile, held while a daemon runs.
+// It fails at once if another daemon holds it, e.g. one starting at the same
+// time that has not opened its socket yet. Closing the file releases it.
+func lock() (*os.File, error) {
+	dir, err := config.ProfileDir(config.ActiveProfile())
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "daemon.lock")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open daemon lock: %w", err)
+	}
+
+	locked, err := tryLockFile(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	if !locked {
+		_ = f.Close()
+		if status, err := GetStatus(); err == nil {
+			return nil, fmt.Errorf("daemon already running (pid %d)", status.PID)
+		}
+		return nil, fmt.Errorf("daemon already running")
+	}
+	return f, nil
+}
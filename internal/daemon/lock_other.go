@@ -0,0 +1,11 @@
+//go:build !unix
+
+package daemon
+
+import "os"
+
+// tryLockFile locks nothing where flock is missing, the socket check of Run
+// is all that keeps a second daemon out
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,252 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/existflow/irontask/internal/config"
+	"github.com/existflow/irontask/internal/database"
+	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/internal/sync"
+)
+
+// watchInterval is how often the database is checked for local changes
+const watchInterval = time.Second
+
+// server is a running daemon
+type server struct {
+	client     *sync.Client
+	db         *db.DB
+	auto       *sync.AutoSync
+	startedAt  time.Time
+	lastMark   database.GetUnsyncedMarkRow // Local changes seen by the last check
+	pulled     atomic.Int64
+	conflicted atomic.Int64
+	stop       chan struct{} // Receives stop requests
+	done       chan struct{} // Closed when the daemon shuts down
+}
+
+// Run syncs the active profile in the background until ctx ends or a stop
+// request arrives. Local changes are pushed shortly after they are made,
+// remote changes are pulled as AutoSync does in the TUI.
+func Run(ctx context.Context) error {
+	// Held until the daemon exits, a second daemon must not replace the socket
+	lockFile, err := lock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = lockFile.Close()
+	}()
+	if status, err := GetStatus(); err == nil {
+		return fmt.Errorf("daemon already running (pid %d)", status.PID)
+	}
+
+	client, err := sync.NewClient()
+	if err != nil {
+		return err
+	}
+	if !client.IsLoggedIn() {
+		return fmt.Errorf("not logged in, run 'irontask auth login' first")
+	}
+	if !client.CanAutoSync() {
+		return fmt.Errorf("run 'irontask sync' once before starting the daemon")
+	}
+
+	dbConn, err := db.OpenDefault()
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+
+	path, err := SocketPath()
+	if err != nil {
+		return err
+	}
+	if err := removeStale(path); err != nil {
+		return err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	_ = os.Chmod(path, 0600)
+
+	s := &server{
+		client:    client,
+		db:        dbConn,
+		auto:      sync.NewAutoSync(client, dbConn),
+		startedAt: time.Now(),
+		stop:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	s.auto.SetOnPull(func() {
+		s.pulled.Add(1)
+	})
+	s.auto.SetOnConflict(func([]sync.ConflictItem) {
+		s.conflicted.Add(1)
+	})
+	s.auto.TriggerSync()
+
+	watched := make(chan struct{})
+	go func() {
+		s.watch()
+		close(watched)
+	}()
+	go s.serve(ln)
+
+	logger.Info("Daemon started",
+		logger.F("pid", os.Getpid()),
+		logger.F("profile", config.ActiveProfile()),
+		logger.F("socket", path))
+
+	select {
+	case <-ctx.Done():
+	case <-s.stop:
+	}
+
+	logger.Info("Stopping daemon")
+	close(s.done)
+	<-watched
+
+	// Push changes made since the last check before leaving, requests are
+	// answered until then
+	s.checkChanges()
+	s.auto.Stop()
+	_ = s.auto.SyncNowIfPending()
+	_ = ln.Close()
+
+	logger.Info("Daemon stopped")
+	return nil
+}
+
+// watch triggers a sync whenever local changes appear
+func (s *server) watch() {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.checkChanges()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// checkChanges triggers a sync if unpushed changes were made since the last
+// check. Changes that stay unpushed, e.g. conflicts or while offline, do not
+// trigger again, retries and polling cover them.
+func (s *server) checkChanges() {
+	mark, err := s.db.GetUnsyncedMark(context.Background())
+	if err != nil {
+		logger.Warn("Failed to check for local changes", logger.F("error", err))
+		return
+	}
+	if mark == s.lastMark {
+		return
+	}
+	s.lastMark = mark
+	if mark.Count > 0 && !s.auto.IsOffline() {
+		logger.Debug("Local changes found, syncing", logger.F("pending", mark.Count))
+		s.auto.TriggerSync()
+	}
+}
+
+// serve answers requests until the listener is closed
+func (s *server) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+			default:
+				logger.Error("Daemon stopped accepting requests", logger.F("error", err))
+			}
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle answers one request
+func (s *server) handle(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetReadDeadline(time.Now().Add(requestTimeout))
+
+	var req request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		logger.Warn("Invalid daemon request", logger.F("error", err))
+		return
+	}
+	logger.Debug("Daemon request", logger.F("command", req.Command))
+
+	var resp response
+	switch req.Command {
+	case cmdStatus:
+		resp.Status = s.status()
+	case cmdSync:
+		result, err := s.auto.SyncNow(sync.TriggerCLI)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = &Result{
+			Pushed:    result.Pushed,
+			Pulled:    result.Pulled,
+			Conflicts: len(result.Conflicts),
+			Failed:    result.Failed,
+		}
+	case cmdTrigger:
+		s.auto.TriggerSync()
+	case cmdStop:
+		select {
+		case s.stop <- struct{}{}:
+		default:
+		}
+	default:
+		resp.Error = fmt.Sprintf("unknown command %q", req.Command)
+	}
+
+	_ = conn.SetWriteDeadline(time.Now().Add(requestTimeout))
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		logger.Warn("Failed to answer daemon request", logger.F("error", err))
+	}
+}
+
+// status returns the state of the daemon
+func (s *server) status() *Status {
+	syncStatus := s.auto.Status()
+	_, _, lastSync := s.client.GetStatus()
+	status := &Status{
+		PID:          os.Getpid(),
+		Profile:      config.ActiveProfile(),
+		StartedAt:    s.startedAt,
+		Syncing:      syncStatus.Syncing,
+		Offline:      syncStatus.Offline,
+		OfflineSince: syncStatus.OfflineSince,
+		NextRetry:    syncStatus.NextRetry,
+		Pending:      syncStatus.Pending,
+		SyncVersion:  lastSync,
+		Pulled:       s.pulled.Load(),
+		Conflicted:   s.conflicted.Load(),
+	}
+	if syncStatus.LastError != nil {
+		status.LastError = syncStatus.LastError.Error()
+	}
+	if conflicts, err := s.db.ListSyncConflicts(context.Background()); err == nil {
+		status.Conflicts = len(conflicts)
+	}
+	return status
+}
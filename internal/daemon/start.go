@@ -0,0 +1,44 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// startTimeout is how long Start waits for a new daemon to answer
+const startTimeout = 10 * time.Second
+
+// Start runs the current executable with args as a daemon detached from the
+// terminal and waits until it answers
+func Start(args []string) (*Status, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(exe, args...)
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start daemon: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	deadline := time.After(startTimeout)
+	for {
+		select {
+		case err := <-exited:
+			return nil, fmt.Errorf("daemon exited (%v), see the log file for details", err)
+		case <-deadline:
+			return nil, fmt.Errorf("daemon did not answer within %s", startTimeout)
+		case <-time.After(100 * time.Millisecond):
+			if status, err := GetStatus(); err == nil {
+				return status, nil
+			}
+		}
+	}
+}
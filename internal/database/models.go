@@ -18,6 +18,38 @@ type Project struct {
 	UpdatedAt   string         `json:"updated_at"`
 	DeletedAt   sql.NullString `json:"deleted_at"`
 	SyncVersion sql.NullInt64  `json:"sync_version"`
+	BaseVersion sql.NullInt64  `json:"base_version"`
+}
+
+type SyncBase struct {
+	ItemType string `json:"item_type"`
+	ItemID   string `json:"item_id"`
+	Version  int64  `json:"version"`
+	Deleted  bool   `json:"deleted"`
+	Data     string `json:"data"`
+}
+
+type SyncConflict struct {
+	ItemType      string `json:"item_type"`
+	ItemID        string `json:"item_id"`
+	ServerVersion int64  `json:"server_version"`
+	DetectedAt    string `json:"detected_at"`
+	Data          string `json:"data"`
+}
+
+type SyncRun struct {
+	ID          int64          `json:"id"`
+	StartedAt   string         `json:"started_at"`
+	FinishedAt  sql.NullString `json:"finished_at"`
+	Source      string         `json:"source"`
+	Mode        string         `json:"mode"`
+	Pushed      int64          `json:"pushed"`
+	Pulled      int64          `json:"pulled"`
+	Conflicts   int64          `json:"conflicts"`
+	Failed      int64          `json:"failed"`
+	Error       sql.NullString `json:"error"`
+	FromVersion int64          `json:"from_version"`
+	ToVersion   int64          `json:"to_version"`
 }
 
 type SyncState struct {
@@ -37,4 +69,5 @@ type Task struct {
 	UpdatedAt   string         `json:"updated_at"`
 	DeletedAt   sql.NullString `json:"deleted_at"`
 	SyncVersion sql.NullInt64  `json:"sync_version"`
+	BaseVersion sql.NullInt64  `json:"base_version"`
 }
@@ -11,35 +11,87 @@ import (
 
 type Querier interface {
 	ClearProjects(ctx context.Context) error
+	ClearSyncBase(ctx context.Context) error
+	ClearSyncConflicts(ctx context.Context) error
 	ClearTasks(ctx context.Context) error
 	CountTasks(ctx context.Context, projectID string) (CountTasksRow, error)
+	// Local changes not pushed yet
+	CountUnsynced(ctx context.Context) (int64, error)
 	// sync_version is NULL for new items, will be set after successful push
 	CreateProject(ctx context.Context, arg CreateProjectParams) error
 	// sync_version is NULL for new items, will be set after successful push
 	CreateTask(ctx context.Context, arg CreateTaskParams) error
+	// Drop the base snapshots of items that no longer exist
+	DeleteOrphanSyncBase(ctx context.Context) error
 	// Set sync_version to NULL to mark as "needs push". Server will assign new version.
 	DeleteProject(ctx context.Context, arg DeleteProjectParams) error
+	// Drop the base snapshot of an item, its next merge has none
+	DeleteSyncBase(ctx context.Context, arg DeleteSyncBaseParams) error
+	DeleteSyncConflict(ctx context.Context, arg DeleteSyncConflictParams) error
+	// Drop every project without unpushed changes that no task refers to
+	DeleteSyncedProjects(ctx context.Context) error
+	// Drop every task without unpushed changes, before pulling everything again
+	DeleteSyncedTasks(ctx context.Context) error
 	// Set sync_version to NULL to mark as "needs push". Server will assign new version.
 	DeleteTask(ctx context.Context, arg DeleteTaskParams) error
+	FinishSyncRun(ctx context.Context, arg FinishSyncRunParams) error
 	GetProject(ctx context.Context, id string) (Project, error)
+	// Including deleted projects, a pulled change may delete or restore them
+	GetProjectForSync(ctx context.Context, id string) (Project, error)
 	// Get projects that need to be pushed (sync_version is NULL means "dirty")
 	GetProjectsToSync(ctx context.Context) ([]Project, error)
+	// Last synced version of an item, the base of three-way merges
+	GetSyncBase(ctx context.Context, arg GetSyncBaseParams) (SyncBase, error)
+	GetSyncState(ctx context.Context, key string) (sql.NullString, error)
 	GetTask(ctx context.Context, id string) (Task, error)
+	// Including deleted tasks, a pulled change may delete or restore them
+	GetTaskForSync(ctx context.Context, id string) (Task, error)
 	GetTaskPartial(ctx context.Context, dollar_1 sql.NullString) (Task, error)
 	// Get tasks that need to be pushed (sync_version is NULL means "dirty")
 	GetTasksToSync(ctx context.Context) ([]Task, error)
+	// Count and latest edit of local changes not pushed yet, it moves with every
+	// local change
+	GetUnsyncedMark(ctx context.Context) (GetUnsyncedMarkRow, error)
 	ListProjects(ctx context.Context) ([]Project, error)
+	ListSyncConflicts(ctx context.Context) ([]SyncConflict, error)
+	// Live and unpushed items, compared with the server digest
+	ListSyncDigestItems(ctx context.Context) ([]ListSyncDigestItemsRow, error)
+	// Runs started since a time, newest first
+	ListSyncRuns(ctx context.Context, startedAt string) ([]SyncRun, error)
 	ListTasks(ctx context.Context, arg ListTasksParams) ([]Task, error)
+	// Mark every synced project as "needs push", e.g. to re-upload it encrypted
+	MarkProjectsDirty(ctx context.Context) error
+	// Mark every synced task as "needs push", e.g. to re-upload it encrypted
+	MarkTasksDirty(ctx context.Context) error
 	OverwriteProject(ctx context.Context, arg OverwriteProjectParams) error
 	OverwriteTask(ctx context.Context, arg OverwriteTaskParams) error
+	// Deleted projects count, tasks may still reference them
+	ProjectExists(ctx context.Context, id string) (int64, error)
+	// Keep only the most recent runs
+	PruneSyncRuns(ctx context.Context, limit int64) error
+	// Hard-delete projects whose deletion the server acknowledged, once no task refers to them
+	PurgeDeletedProjects(ctx context.Context) (int64, error)
+	// Hard-delete tasks whose deletion the server acknowledged
+	PurgeDeletedTasks(ctx context.Context) (int64, error)
+	// Keep a conflicting local change: base it on the server version and push it again
+	RebaseProject(ctx context.Context, arg RebaseProjectParams) error
+	// Keep a conflicting local change: base it on the server version and push it again
+	RebaseTask(ctx context.Context, arg RebaseTaskParams) error
+	SetSyncState(ctx context.Context, arg SetSyncStateParams) error
+	StartSyncRun(ctx context.Context, arg StartSyncRunParams) (int64, error)
 	// Set sync_version to NULL to mark as "needs push". Server will assign new version.
 	UpdateProject(ctx context.Context, arg UpdateProjectParams) error
+	// base_version is the server version local changes are based on, sent as base for the next push
 	UpdateProjectSyncVersion(ctx context.Context, arg UpdateProjectSyncVersionParams) error
 	// Set sync_version to NULL to mark as "needs push". Server will assign new version.
 	UpdateTask(ctx context.Context, arg UpdateTaskParams) error
 	// Set sync_version to NULL to mark as "needs push". Server will assign new version.
 	UpdateTaskStatus(ctx context.Context, arg UpdateTaskStatusParams) error
+	// base_version is the server version local changes are based on, sent as base for the next push
 	UpdateTaskSyncVersion(ctx context.Context, arg UpdateTaskSyncVersionParams) error
+	UpsertSyncBase(ctx context.Context, arg UpsertSyncBaseParams) error
+	// Keeps the time a conflict was first detected
+	UpsertSyncConflict(ctx context.Context, arg UpsertSyncConflictParams) error
 }
 
 var _ Querier = (*Queries)(nil)
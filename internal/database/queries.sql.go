@@ -19,6 +19,24 @@ func (q *Queries) ClearProjects(ctx context.Context) error {
 	return err
 }
 
+const clearSyncBase = `-- name: ClearSyncBase :exec
+DELETE FROM sync_base
+`
+
+func (q *Queries) ClearSyncBase(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, clearSyncBase)
+	return err
+}
+
+const clearSyncConflicts = `-- name: ClearSyncConflicts :exec
+DELETE FROM sync_conflicts
+`
+
+func (q *Queries) ClearSyncConflicts(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, clearSyncConflicts)
+	return err
+}
+
 const clearTasks = `-- name: ClearTasks :exec
 DELETE FROM tasks
 `
@@ -48,6 +66,20 @@ func (q *Queries) CountTasks(ctx context.Context, projectID string) (CountTasksR
 	return i, err
 }
 
+const countUnsynced = `-- name: CountUnsynced :one
+SELECT
+    (SELECT COUNT(*) FROM projects WHERE projects.sync_version IS NULL)
+  + (SELECT COUNT(*) FROM tasks WHERE tasks.sync_version IS NULL)
+`
+
+// Local changes not pushed yet
+func (q *Queries) CountUnsynced(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUnsynced)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
 const createProject = `-- name: CreateProject :exec
 INSERT INTO projects (id, slug, name, color, archived, created_at, updated_at)
 VALUES (?, ?, ?, ?, ?, ?, ?)
@@ -110,6 +142,18 @@ func (q *Queries) CreateTask(ctx context.Context, arg CreateTaskParams) error {
 	return err
 }
 
+const deleteOrphanSyncBase = `-- name: DeleteOrphanSyncBase :exec
+DELETE FROM sync_base
+WHERE (item_type = 'project' AND item_id NOT IN (SELECT id FROM projects))
+   OR (item_type = 'task' AND item_id NOT IN (SELECT id FROM tasks))
+`
+
+// Drop the base snapshots of items that no longer exist
+func (q *Queries) DeleteOrphanSyncBase(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteOrphanSyncBase)
+	return err
+}
+
 const deleteProject = `-- name: DeleteProject :exec
 UPDATE projects
 SET deleted_at = ?, updated_at = ?, sync_version = NULL
@@ -128,6 +172,59 @@ func (q *Queries) DeleteProject(ctx context.Context, arg DeleteProjectParams) er
 	return err
 }
 
+const deleteSyncBase = `-- name: DeleteSyncBase :exec
+DELETE FROM sync_base
+WHERE item_type = ? AND item_id = ?
+`
+
+type DeleteSyncBaseParams struct {
+	ItemType string `json:"item_type"`
+	ItemID   string `json:"item_id"`
+}
+
+// Drop the base snapshot of an item, its next merge has none
+func (q *Queries) DeleteSyncBase(ctx context.Context, arg DeleteSyncBaseParams) error {
+	_, err := q.db.ExecContext(ctx, deleteSyncBase, arg.ItemType, arg.ItemID)
+	return err
+}
+
+const deleteSyncConflict = `-- name: DeleteSyncConflict :exec
+DELETE FROM sync_conflicts
+WHERE item_type = ? AND item_id = ?
+`
+
+type DeleteSyncConflictParams struct {
+	ItemType string `json:"item_type"`
+	ItemID   string `json:"item_id"`
+}
+
+func (q *Queries) DeleteSyncConflict(ctx context.Context, arg DeleteSyncConflictParams) error {
+	_, err := q.db.ExecContext(ctx, deleteSyncConflict, arg.ItemType, arg.ItemID)
+	return err
+}
+
+const deleteSyncedProjects = `-- name: DeleteSyncedProjects :exec
+DELETE FROM projects
+WHERE sync_version IS NOT NULL
+  AND id NOT IN (SELECT project_id FROM tasks)
+`
+
+// Drop every project without unpushed changes that no task refers to
+func (q *Queries) DeleteSyncedProjects(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteSyncedProjects)
+	return err
+}
+
+const deleteSyncedTasks = `-- name: DeleteSyncedTasks :exec
+DELETE FROM tasks WHERE sync_version IS NOT NULL
+`
+
+// Drop every task without unpushed changes, before pulling everything again
+func (q *Queries) DeleteSyncedTasks(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteSyncedTasks)
+	return err
+}
+
 const deleteTask = `-- name: DeleteTask :exec
 UPDATE tasks
 SET deleted_at = ?, updated_at = ?, sync_version = NULL
@@ -146,8 +243,39 @@ func (q *Queries) DeleteTask(ctx context.Context, arg DeleteTaskParams) error {
 	return err
 }
 
+const finishSyncRun = `-- name: FinishSyncRun :exec
+UPDATE sync_runs
+SET finished_at = ?, pushed = ?, pulled = ?, conflicts = ?, failed = ?, error = ?, to_version = ?
+WHERE id = ?
+`
+
+type FinishSyncRunParams struct {
+	FinishedAt sql.NullString `json:"finished_at"`
+	Pushed     int64          `json:"pushed"`
+	Pulled     int64          `json:"pulled"`
+	Conflicts  int64          `json:"conflicts"`
+	Failed     int64          `json:"failed"`
+	Error      sql.NullString `json:"error"`
+	ToVersion  int64          `json:"to_version"`
+	ID         int64          `json:"id"`
+}
+
+func (q *Queries) FinishSyncRun(ctx context.Context, arg FinishSyncRunParams) error {
+	_, err := q.db.ExecContext(ctx, finishSyncRun,
+		arg.FinishedAt,
+		arg.Pushed,
+		arg.Pulled,
+		arg.Conflicts,
+		arg.Failed,
+		arg.Error,
+		arg.ToVersion,
+		arg.ID,
+	)
+	return err
+}
+
 const getProject = `-- name: GetProject :one
-SELECT id, slug, name, color, archived, created_at, updated_at, deleted_at, sync_version FROM projects
+SELECT id, slug, name, color, archived, created_at, updated_at, deleted_at, sync_version, base_version FROM projects
 WHERE id = ? AND deleted_at IS NULL LIMIT 1
 `
 
@@ -164,12 +292,37 @@ func (q *Queries) GetProject(ctx context.Context, id string) (Project, error) {
 		&i.UpdatedAt,
 		&i.DeletedAt,
 		&i.SyncVersion,
+		&i.BaseVersion,
+	)
+	return i, err
+}
+
+const getProjectForSync = `-- name: GetProjectForSync :one
+SELECT id, slug, name, color, archived, created_at, updated_at, deleted_at, sync_version, base_version FROM projects
+WHERE id = ? LIMIT 1
+`
+
+// Including deleted projects, a pulled change may delete or restore them
+func (q *Queries) GetProjectForSync(ctx context.Context, id string) (Project, error) {
+	row := q.db.QueryRowContext(ctx, getProjectForSync, id)
+	var i Project
+	err := row.Scan(
+		&i.ID,
+		&i.Slug,
+		&i.Name,
+		&i.Color,
+		&i.Archived,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.SyncVersion,
+		&i.BaseVersion,
 	)
 	return i, err
 }
 
 const getProjectsToSync = `-- name: GetProjectsToSync :many
-SELECT id, slug, name, color, archived, created_at, updated_at, deleted_at, sync_version FROM projects
+SELECT id, slug, name, color, archived, created_at, updated_at, deleted_at, sync_version, base_version FROM projects
 WHERE sync_version IS NULL
 ORDER BY updated_at
 `
@@ -194,6 +347,7 @@ func (q *Queries) GetProjectsToSync(ctx context.Context) ([]Project, error) {
 			&i.UpdatedAt,
 			&i.DeletedAt,
 			&i.SyncVersion,
+			&i.BaseVersion,
 		); err != nil {
 			return nil, err
 		}
@@ -208,8 +362,44 @@ func (q *Queries) GetProjectsToSync(ctx context.Context) ([]Project, error) {
 	return items, nil
 }
 
+const getSyncBase = `-- name: GetSyncBase :one
+SELECT item_type, item_id, version, deleted, data FROM sync_base
+WHERE item_type = ? AND item_id = ?
+`
+
+type GetSyncBaseParams struct {
+	ItemType string `json:"item_type"`
+	ItemID   string `json:"item_id"`
+}
+
+// Last synced version of an item, the base of three-way merges
+func (q *Queries) GetSyncBase(ctx context.Context, arg GetSyncBaseParams) (SyncBase, error) {
+	row := q.db.QueryRowContext(ctx, getSyncBase, arg.ItemType, arg.ItemID)
+	var i SyncBase
+	err := row.Scan(
+		&i.ItemType,
+		&i.ItemID,
+		&i.Version,
+		&i.Deleted,
+		&i.Data,
+	)
+	return i, err
+}
+
+const getSyncState = `-- name: GetSyncState :one
+SELECT value FROM sync_state
+WHERE key = ?
+`
+
+func (q *Queries) GetSyncState(ctx context.Context, key string) (sql.NullString, error) {
+	row := q.db.QueryRowContext(ctx, getSyncState, key)
+	var value sql.NullString
+	err := row.Scan(&value)
+	return value, err
+}
+
 const getTask = `-- name: GetTask :one
-SELECT id, project_id, content, status, priority, due_date, tags, created_at, updated_at, deleted_at, sync_version FROM tasks
+SELECT id, project_id, content, status, priority, due_date, tags, created_at, updated_at, deleted_at, sync_version, base_version FROM tasks
 WHERE id = ? AND deleted_at IS NULL LIMIT 1
 `
 
@@ -228,12 +418,39 @@ func (q *Queries) GetTask(ctx context.Context, id string) (Task, error) {
 		&i.UpdatedAt,
 		&i.DeletedAt,
 		&i.SyncVersion,
+		&i.BaseVersion,
+	)
+	return i, err
+}
+
+const getTaskForSync = `-- name: GetTaskForSync :one
+SELECT id, project_id, content, status, priority, due_date, tags, created_at, updated_at, deleted_at, sync_version, base_version FROM tasks
+WHERE id = ? LIMIT 1
+`
+
+// Including deleted tasks, a pulled change may delete or restore them
+func (q *Queries) GetTaskForSync(ctx context.Context, id string) (Task, error) {
+	row := q.db.QueryRowContext(ctx, getTaskForSync, id)
+	var i Task
+	err := row.Scan(
+		&i.ID,
+		&i.ProjectID,
+		&i.Content,
+		&i.Status,
+		&i.Priority,
+		&i.DueDate,
+		&i.Tags,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.SyncVersion,
+		&i.BaseVersion,
 	)
 	return i, err
 }
 
 const getTaskPartial = `-- name: GetTaskPartial :one
-SELECT id, project_id, content, status, priority, due_date, tags, created_at, updated_at, deleted_at, sync_version FROM tasks 
+SELECT id, project_id, content, status, priority, due_date, tags, created_at, updated_at, deleted_at, sync_version, base_version FROM tasks 
 WHERE id LIKE ? || '%' AND deleted_at IS NULL LIMIT 1
 `
 
@@ -252,12 +469,13 @@ func (q *Queries) GetTaskPartial(ctx context.Context, dollar_1 sql.NullString) (
 		&i.UpdatedAt,
 		&i.DeletedAt,
 		&i.SyncVersion,
+		&i.BaseVersion,
 	)
 	return i, err
 }
 
 const getTasksToSync = `-- name: GetTasksToSync :many
-SELECT id, project_id, content, status, priority, due_date, tags, created_at, updated_at, deleted_at, sync_version FROM tasks
+SELECT id, project_id, content, status, priority, due_date, tags, created_at, updated_at, deleted_at, sync_version, base_version FROM tasks
 WHERE sync_version IS NULL
 ORDER BY updated_at
 `
@@ -284,6 +502,7 @@ func (q *Queries) GetTasksToSync(ctx context.Context) ([]Task, error) {
 			&i.UpdatedAt,
 			&i.DeletedAt,
 			&i.SyncVersion,
+			&i.BaseVersion,
 		); err != nil {
 			return nil, err
 		}
@@ -298,8 +517,31 @@ func (q *Queries) GetTasksToSync(ctx context.Context) ([]Task, error) {
 	return items, nil
 }
 
+const getUnsyncedMark = `-- name: GetUnsyncedMark :one
+SELECT COUNT(*) AS count, CAST(COALESCE(MAX(updated_at), '') AS TEXT) AS latest
+FROM (
+    SELECT updated_at FROM projects WHERE sync_version IS NULL
+    UNION ALL
+    SELECT updated_at FROM tasks WHERE sync_version IS NULL
+)
+`
+
+type GetUnsyncedMarkRow struct {
+	Count  int64  `json:"count"`
+	Latest string `json:"latest"`
+}
+
+// Count and latest edit of local changes not pushed yet, it moves with every
+// local change
+func (q *Queries) GetUnsyncedMark(ctx context.Context) (GetUnsyncedMarkRow, error) {
+	row := q.db.QueryRowContext(ctx, getUnsyncedMark)
+	var i GetUnsyncedMarkRow
+	err := row.Scan(&i.Count, &i.Latest)
+	return i, err
+}
+
 const listProjects = `-- name: ListProjects :many
-SELECT id, slug, name, color, archived, created_at, updated_at, deleted_at, sync_version FROM projects
+SELECT id, slug, name, color, archived, created_at, updated_at, deleted_at, sync_version, base_version FROM projects
 WHERE deleted_at IS NULL
 ORDER BY name
 `
@@ -323,6 +565,128 @@ func (q *Queries) ListProjects(ctx context.Context) ([]Project, error) {
 			&i.UpdatedAt,
 			&i.DeletedAt,
 			&i.SyncVersion,
+			&i.BaseVersion,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSyncConflicts = `-- name: ListSyncConflicts :many
+SELECT item_type, item_id, server_version, detected_at, data FROM sync_conflicts
+ORDER BY detected_at, item_type, item_id
+`
+
+func (q *Queries) ListSyncConflicts(ctx context.Context) ([]SyncConflict, error) {
+	rows, err := q.db.QueryContext(ctx, listSyncConflicts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SyncConflict
+	for rows.Next() {
+		var i SyncConflict
+		if err := rows.Scan(
+			&i.ItemType,
+			&i.ItemID,
+			&i.ServerVersion,
+			&i.DetectedAt,
+			&i.Data,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSyncDigestItems = `-- name: ListSyncDigestItems :many
+SELECT 'project' AS item_type, id, sync_version, deleted_at FROM projects
+WHERE deleted_at IS NULL OR sync_version IS NULL
+UNION ALL
+SELECT 'task' AS item_type, id, sync_version, deleted_at FROM tasks
+WHERE deleted_at IS NULL OR sync_version IS NULL
+`
+
+type ListSyncDigestItemsRow struct {
+	ItemType    string         `json:"item_type"`
+	ID          string         `json:"id"`
+	SyncVersion sql.NullInt64  `json:"sync_version"`
+	DeletedAt   sql.NullString `json:"deleted_at"`
+}
+
+// Live and unpushed items, compared with the server digest
+func (q *Queries) ListSyncDigestItems(ctx context.Context) ([]ListSyncDigestItemsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listSyncDigestItems)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListSyncDigestItemsRow
+	for rows.Next() {
+		var i ListSyncDigestItemsRow
+		if err := rows.Scan(
+			&i.ItemType,
+			&i.ID,
+			&i.SyncVersion,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSyncRuns = `-- name: ListSyncRuns :many
+SELECT id, started_at, finished_at, source, mode, pushed, pulled, conflicts, failed, error, from_version, to_version FROM sync_runs
+WHERE started_at >= ?
+ORDER BY id DESC
+`
+
+// Runs started since a time, newest first
+func (q *Queries) ListSyncRuns(ctx context.Context, startedAt string) ([]SyncRun, error) {
+	rows, err := q.db.QueryContext(ctx, listSyncRuns, startedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SyncRun
+	for rows.Next() {
+		var i SyncRun
+		if err := rows.Scan(
+			&i.ID,
+			&i.StartedAt,
+			&i.FinishedAt,
+			&i.Source,
+			&i.Mode,
+			&i.Pushed,
+			&i.Pulled,
+			&i.Conflicts,
+			&i.Failed,
+			&i.Error,
+			&i.FromVersion,
+			&i.ToVersion,
 		); err != nil {
 			return nil, err
 		}
@@ -338,7 +702,7 @@ func (q *Queries) ListProjects(ctx context.Context) ([]Project, error) {
 }
 
 const listTasks = `-- name: ListTasks :many
-SELECT id, project_id, content, status, priority, due_date, tags, created_at, updated_at, deleted_at, sync_version FROM tasks
+SELECT id, project_id, content, status, priority, due_date, tags, created_at, updated_at, deleted_at, sync_version, base_version FROM tasks
 WHERE deleted_at IS NULL
   AND (?1 IS NULL OR project_id = ?1)
   AND (?2 OR status != 'done')
@@ -371,6 +735,7 @@ func (q *Queries) ListTasks(ctx context.Context, arg ListTasksParams) ([]Task, e
 			&i.UpdatedAt,
 			&i.DeletedAt,
 			&i.SyncVersion,
+			&i.BaseVersion,
 		); err != nil {
 			return nil, err
 		}
@@ -385,9 +750,29 @@ func (q *Queries) ListTasks(ctx context.Context, arg ListTasksParams) ([]Task, e
 	return items, nil
 }
 
+const markProjectsDirty = `-- name: MarkProjectsDirty :exec
+UPDATE projects SET sync_version = NULL WHERE sync_version IS NOT NULL
+`
+
+// Mark every synced project as "needs push", e.g. to re-upload it encrypted
+func (q *Queries) MarkProjectsDirty(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, markProjectsDirty)
+	return err
+}
+
+const markTasksDirty = `-- name: MarkTasksDirty :exec
+UPDATE tasks SET sync_version = NULL WHERE sync_version IS NOT NULL
+`
+
+// Mark every synced task as "needs push", e.g. to re-upload it encrypted
+func (q *Queries) MarkTasksDirty(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, markTasksDirty)
+	return err
+}
+
 const overwriteProject = `-- name: OverwriteProject :exec
 UPDATE projects
-SET slug = ?, name = ?, color = ?, updated_at = ?, sync_version = ?
+SET slug = ?, name = ?, color = ?, archived = ?, updated_at = ?, deleted_at = ?, sync_version = ?, base_version = ?
 WHERE id = ?
 `
 
@@ -395,8 +780,11 @@ type OverwriteProjectParams struct {
 	Slug        string         `json:"slug"`
 	Name        string         `json:"name"`
 	Color       sql.NullString `json:"color"`
+	Archived    bool           `json:"archived"`
 	UpdatedAt   string         `json:"updated_at"`
+	DeletedAt   sql.NullString `json:"deleted_at"`
 	SyncVersion sql.NullInt64  `json:"sync_version"`
+	BaseVersion sql.NullInt64  `json:"base_version"`
 	ID          string         `json:"id"`
 }
 
@@ -405,8 +793,11 @@ func (q *Queries) OverwriteProject(ctx context.Context, arg OverwriteProjectPara
 		arg.Slug,
 		arg.Name,
 		arg.Color,
+		arg.Archived,
 		arg.UpdatedAt,
+		arg.DeletedAt,
 		arg.SyncVersion,
+		arg.BaseVersion,
 		arg.ID,
 	)
 	return err
@@ -414,7 +805,7 @@ func (q *Queries) OverwriteProject(ctx context.Context, arg OverwriteProjectPara
 
 const overwriteTask = `-- name: OverwriteTask :exec
 UPDATE tasks
-SET project_id = ?, content = ?, status = ?, priority = ?, due_date = ?, tags = ?, updated_at = ?, sync_version = ?
+SET project_id = ?, content = ?, status = ?, priority = ?, due_date = ?, tags = ?, updated_at = ?, deleted_at = ?, sync_version = ?, base_version = ?
 WHERE id = ?
 `
 
@@ -426,7 +817,9 @@ type OverwriteTaskParams struct {
 	DueDate     sql.NullString `json:"due_date"`
 	Tags        sql.NullString `json:"tags"`
 	UpdatedAt   string         `json:"updated_at"`
+	DeletedAt   sql.NullString `json:"deleted_at"`
 	SyncVersion sql.NullInt64  `json:"sync_version"`
+	BaseVersion sql.NullInt64  `json:"base_version"`
 	ID          string         `json:"id"`
 }
 
@@ -439,12 +832,139 @@ func (q *Queries) OverwriteTask(ctx context.Context, arg OverwriteTaskParams) er
 		arg.DueDate,
 		arg.Tags,
 		arg.UpdatedAt,
+		arg.DeletedAt,
 		arg.SyncVersion,
+		arg.BaseVersion,
 		arg.ID,
 	)
 	return err
 }
 
+const projectExists = `-- name: ProjectExists :one
+SELECT COUNT(*) FROM projects
+WHERE id = ?
+`
+
+// Deleted projects count, tasks may still reference them
+func (q *Queries) ProjectExists(ctx context.Context, id string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, projectExists, id)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const pruneSyncRuns = `-- name: PruneSyncRuns :exec
+DELETE FROM sync_runs
+WHERE id NOT IN (SELECT id FROM sync_runs ORDER BY id DESC LIMIT ?)
+`
+
+// Keep only the most recent runs
+func (q *Queries) PruneSyncRuns(ctx context.Context, limit int64) error {
+	_, err := q.db.ExecContext(ctx, pruneSyncRuns, limit)
+	return err
+}
+
+const purgeDeletedProjects = `-- name: PurgeDeletedProjects :execrows
+DELETE FROM projects
+WHERE deleted_at IS NOT NULL AND sync_version IS NOT NULL
+  AND id NOT IN (SELECT project_id FROM tasks)
+`
+
+// Hard-delete projects whose deletion the server acknowledged, once no task refers to them
+func (q *Queries) PurgeDeletedProjects(ctx context.Context) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedProjects)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const purgeDeletedTasks = `-- name: PurgeDeletedTasks :execrows
+DELETE FROM tasks
+WHERE deleted_at IS NOT NULL AND sync_version IS NOT NULL
+`
+
+// Hard-delete tasks whose deletion the server acknowledged
+func (q *Queries) PurgeDeletedTasks(ctx context.Context) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedTasks)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rebaseProject = `-- name: RebaseProject :exec
+UPDATE projects SET base_version = ?, sync_version = NULL WHERE id = ?
+`
+
+type RebaseProjectParams struct {
+	BaseVersion sql.NullInt64 `json:"base_version"`
+	ID          string        `json:"id"`
+}
+
+// Keep a conflicting local change: base it on the server version and push it again
+func (q *Queries) RebaseProject(ctx context.Context, arg RebaseProjectParams) error {
+	_, err := q.db.ExecContext(ctx, rebaseProject, arg.BaseVersion, arg.ID)
+	return err
+}
+
+const rebaseTask = `-- name: RebaseTask :exec
+UPDATE tasks SET base_version = ?, sync_version = NULL WHERE id = ?
+`
+
+type RebaseTaskParams struct {
+	BaseVersion sql.NullInt64 `json:"base_version"`
+	ID          string        `json:"id"`
+}
+
+// Keep a conflicting local change: base it on the server version and push it again
+func (q *Queries) RebaseTask(ctx context.Context, arg RebaseTaskParams) error {
+	_, err := q.db.ExecContext(ctx, rebaseTask, arg.BaseVersion, arg.ID)
+	return err
+}
+
+const setSyncState = `-- name: SetSyncState :exec
+INSERT INTO sync_state (key, value)
+VALUES (?, ?)
+ON CONFLICT (key) DO UPDATE
+SET value = excluded.value
+`
+
+type SetSyncStateParams struct {
+	Key   string         `json:"key"`
+	Value sql.NullString `json:"value"`
+}
+
+func (q *Queries) SetSyncState(ctx context.Context, arg SetSyncStateParams) error {
+	_, err := q.db.ExecContext(ctx, setSyncState, arg.Key, arg.Value)
+	return err
+}
+
+const startSyncRun = `-- name: StartSyncRun :one
+INSERT INTO sync_runs (started_at, source, mode, from_version)
+VALUES (?, ?, ?, ?)
+RETURNING id
+`
+
+type StartSyncRunParams struct {
+	StartedAt   string `json:"started_at"`
+	Source      string `json:"source"`
+	Mode        string `json:"mode"`
+	FromVersion int64  `json:"from_version"`
+}
+
+func (q *Queries) StartSyncRun(ctx context.Context, arg StartSyncRunParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, startSyncRun,
+		arg.StartedAt,
+		arg.Source,
+		arg.Mode,
+		arg.FromVersion,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
 const updateProject = `-- name: UpdateProject :exec
 UPDATE projects
 SET slug = ?, name = ?, color = ?, updated_at = ?, sync_version = NULL
@@ -472,16 +992,18 @@ func (q *Queries) UpdateProject(ctx context.Context, arg UpdateProjectParams) er
 }
 
 const updateProjectSyncVersion = `-- name: UpdateProjectSyncVersion :exec
-UPDATE projects SET sync_version = ? WHERE id = ?
+UPDATE projects SET sync_version = ?, base_version = ? WHERE id = ?
 `
 
 type UpdateProjectSyncVersionParams struct {
 	SyncVersion sql.NullInt64 `json:"sync_version"`
+	BaseVersion sql.NullInt64 `json:"base_version"`
 	ID          string        `json:"id"`
 }
 
+// base_version is the server version local changes are based on, sent as base for the next push
 func (q *Queries) UpdateProjectSyncVersion(ctx context.Context, arg UpdateProjectSyncVersionParams) error {
-	_, err := q.db.ExecContext(ctx, updateProjectSyncVersion, arg.SyncVersion, arg.ID)
+	_, err := q.db.ExecContext(ctx, updateProjectSyncVersion, arg.SyncVersion, arg.BaseVersion, arg.ID)
 	return err
 }
 
@@ -536,15 +1058,70 @@ func (q *Queries) UpdateTaskStatus(ctx context.Context, arg UpdateTaskStatusPara
 }
 
 const updateTaskSyncVersion = `-- name: UpdateTaskSyncVersion :exec
-UPDATE tasks SET sync_version = ? WHERE id = ?
+UPDATE tasks SET sync_version = ?, base_version = ? WHERE id = ?
 `
 
 type UpdateTaskSyncVersionParams struct {
 	SyncVersion sql.NullInt64 `json:"sync_version"`
+	BaseVersion sql.NullInt64 `json:"base_version"`
 	ID          string        `json:"id"`
 }
 
+// base_version is the server version local changes are based on, sent as base for the next push
 func (q *Queries) UpdateTaskSyncVersion(ctx context.Context, arg UpdateTaskSyncVersionParams) error {
-	_, err := q.db.ExecContext(ctx, updateTaskSyncVersion, arg.SyncVersion, arg.ID)
+	_, err := q.db.ExecContext(ctx, updateTaskSyncVersion, arg.SyncVersion, arg.BaseVersion, arg.ID)
+	return err
+}
+
+const upsertSyncBase = `-- name: UpsertSyncBase :exec
+INSERT INTO sync_base (item_type, item_id, version, deleted, data)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (item_type, item_id) DO UPDATE
+SET version = excluded.version, deleted = excluded.deleted, data = excluded.data
+`
+
+type UpsertSyncBaseParams struct {
+	ItemType string `json:"item_type"`
+	ItemID   string `json:"item_id"`
+	Version  int64  `json:"version"`
+	Deleted  bool   `json:"deleted"`
+	Data     string `json:"data"`
+}
+
+func (q *Queries) UpsertSyncBase(ctx context.Context, arg UpsertSyncBaseParams) error {
+	_, err := q.db.ExecContext(ctx, upsertSyncBase,
+		arg.ItemType,
+		arg.ItemID,
+		arg.Version,
+		arg.Deleted,
+		arg.Data,
+	)
+	return err
+}
+
+const upsertSyncConflict = `-- name: UpsertSyncConflict :exec
+INSERT INTO sync_conflicts (item_type, item_id, server_version, detected_at, data)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (item_type, item_id) DO UPDATE
+SET server_version = excluded.server_version, data = excluded.data
+`
+
+type UpsertSyncConflictParams struct {
+	ItemType      string `json:"item_type"`
+	ItemID        string `json:"item_id"`
+	ServerVersion int64  `json:"server_version"`
+	DetectedAt    string `json:"detected_at"`
+	Data          string `json:"data"`
+}
+
+// Keeps the time a conflict was first detected
+func (q *Queries) UpsertSyncConflict(ctx context.Context, arg UpsertSyncConflictParams) error {
+	_, err := q.db.ExecContext(ctx, upsertSyncConflict,
+		arg.ItemType,
+		arg.ItemID,
+		arg.ServerVersion,
+		arg.DetectedAt,
+		arg.Data,
+	)
 	return err
 }
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/existflow/irontask/internal/config"
 	"github.com/existflow/irontask/internal/database"
 	"github.com/existflow/irontask/internal/logger"
 	_ "modernc.org/sqlite"
@@ -17,13 +18,14 @@ type DB struct {
 	*database.Queries
 }
 
-// DefaultDBPath returns the default database path (~/.irontask/tasks.sqlite)
+// DefaultDBPath returns the database path of the active profile
+// (~/.irontask/tasks.sqlite for the default profile)
 func DefaultDBPath() (string, error) {
-	home, err := os.UserHomeDir()
+	dir, err := config.ProfileDir(config.ActiveProfile())
 	if err != nil {
-		return "", fmt.Errorf("failed to get home directory: %w", err)
+		return "", fmt.Errorf("failed to get profile directory: %w", err)
 	}
-	return filepath.Join(home, ".irontask", "tasks.sqlite"), nil
+	return filepath.Join(dir, "tasks.sqlite"), nil
 }
 
 // Open opens or creates the SQLite database
@@ -37,8 +39,9 @@ func Open(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("failed to create database directory: %w", err)
 	}
 
-	// Open database
-	sqlDB, err := sql.Open("sqlite", dbPath)
+	// Open database. Sync applies pulled changes in transactions, other writers
+	// wait for them instead of failing with "database is locked".
+	sqlDB, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		logger.Error("Failed to open database", logger.F("path", dbPath), logger.F("error", err))
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -9,6 +9,9 @@ func (db *DB) migrate() error {
 		migrationCreateTasks,
 		migrationCreateSyncState,
 		migrationServerSideSyncVersion, // v2: Server-side sync versioning
+		migrationCreateSyncBase,        // v4: Base snapshots for three-way merges
+		migrationCreateSyncRuns,        // v5: Sync history
+		migrationCreateSyncConflicts,   // v6: Unresolved conflicts
 	}
 
 	for i, m := range migrations {
@@ -17,6 +20,58 @@ func (db *DB) migrate() error {
 		}
 	}
 
+	for _, c := range columnMigrations {
+		if err := db.addColumn(c.table, c.column, c.definition, c.backfill); err != nil {
+			return fmt.Errorf("adding %s.%s failed: %w", c.table, c.column, err)
+		}
+	}
+
+	return nil
+}
+
+// columnMigrations add columns to existing tables. SQLite has no
+// ADD COLUMN IF NOT EXISTS, so each one only runs while the column is missing,
+// followed by its backfill.
+var columnMigrations = []struct {
+	table, column, definition, backfill string
+}{
+	// v3: Server version local changes are based on, for conflict detection
+	{"projects", "base_version", "INTEGER", "UPDATE projects SET base_version = sync_version"},
+	{"tasks", "base_version", "INTEGER", "UPDATE tasks SET base_version = sync_version"},
+}
+
+// addColumn adds a column unless the table already has it
+func (db *DB) addColumn(table, column, definition, backfill string) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return err
+	}
+	if backfill != "" {
+		if _, err := db.Exec(backfill); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -82,3 +137,49 @@ UPDATE tasks SET sync_version = NULL WHERE sync_version = 0 OR sync_version IS N
 INSERT OR IGNORE INTO projects (id, slug, name, color, created_at, updated_at)
 VALUES ('inbox', 'inbox', 'Inbox', '#6C757D', datetime('now'), datetime('now'));
 `
+
+// migrationCreateSyncBase stores the last synced version of each item, the
+// base of three-way merges
+const migrationCreateSyncBase = `
+CREATE TABLE IF NOT EXISTS sync_base (
+    item_type TEXT NOT NULL,
+    item_id TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    deleted INTEGER NOT NULL DEFAULT 0,
+    data TEXT NOT NULL,
+    PRIMARY KEY (item_type, item_id)
+);
+`
+
+// migrationCreateSyncRuns records what every sync did, shown by sync log
+const migrationCreateSyncRuns = `
+CREATE TABLE IF NOT EXISTS sync_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    started_at TEXT NOT NULL,
+    finished_at TEXT,
+    source TEXT NOT NULL,
+    mode TEXT NOT NULL,
+    pushed INTEGER NOT NULL DEFAULT 0,
+    pulled INTEGER NOT NULL DEFAULT 0,
+    conflicts INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    from_version INTEGER NOT NULL,
+    to_version INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_sync_runs_started ON sync_runs(started_at);
+`
+
+// migrationCreateSyncConflicts keeps the conflicts of the last sync until they
+// are resolved, shown by sync conflicts
+const migrationCreateSyncConflicts = `
+CREATE TABLE IF NOT EXISTS sync_conflicts (
+    item_type TEXT NOT NULL,
+    item_id TEXT NOT NULL,
+    server_version INTEGER NOT NULL,
+    detected_at TEXT NOT NULL,
+    data TEXT NOT NULL,
+    PRIMARY KEY (item_type, item_id)
+);
+`
@@ -0,0 +1,56 @@
+// Package digest summarizes a synced dataset in a fixed number of bucket
+// hashes, so the server and a client can find the items they disagree on
+// without exchanging every item.
+package digest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// Buckets is the number of bucket hashes of a digest
+const Buckets = 64
+
+// Entry is one live item of a dataset as the server knows it
+type Entry struct {
+	Type        string `json:"type"`
+	ClientID    string `json:"client_id"`
+	SyncVersion int64  `json:"sync_version"`
+}
+
+// Bucket returns the bucket an item falls into
+func Bucket(itemType, clientID string) int {
+	sum := sha256.Sum256([]byte(itemType + ":" + clientID))
+	return int(sum[0]) % Buckets
+}
+
+// Sum returns the hash of each bucket, empty for buckets without items. Equal
+// hashes mean both sides hold the same items at the same versions.
+func Sum(entries []Entry) []string {
+	buckets := make([][]Entry, Buckets)
+	for _, e := range entries {
+		b := Bucket(e.Type, e.ClientID)
+		buckets[b] = append(buckets[b], e)
+	}
+
+	sums := make([]string, Buckets)
+	for i, bucket := range buckets {
+		if len(bucket) == 0 {
+			continue
+		}
+		sort.Slice(bucket, func(a, b int) bool {
+			if bucket[a].Type != bucket[b].Type {
+				return bucket[a].Type < bucket[b].Type
+			}
+			return bucket[a].ClientID < bucket[b].ClientID
+		})
+		h := sha256.New()
+		for _, e := range bucket {
+			_, _ = fmt.Fprintf(h, "%s:%s:%d\n", e.Type, e.ClientID, e.SyncVersion)
+		}
+		sums[i] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums
+}
@@ -0,0 +1,158 @@
+// Package protocol defines the sync API spoken between the client and the
+// server: the wire types, the protocol version and the features a server
+// offers. Both sides build on these types so they cannot drift apart.
+package protocol
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Version is the sync protocol version of this build. It changes when a
+// request or response changes in a way older peers cannot handle.
+const Version = 1
+
+// MinVersion is the oldest protocol version this build still speaks
+const MinVersion = 1
+
+// VersionHeader carries the protocol version of the sender, on requests and
+// responses. Requests without it come from clients older than protocol
+// versions and are taken as version 1.
+const VersionHeader = "X-IronTask-Protocol"
+
+// Features a server may offer. Clients check them before relying on a feature
+// and fall back to what the server supports.
+const (
+	FeaturePagination  = "pagination"  // Paged pulls with limit and cursor
+	FeatureCompression = "compression" // Gzip request bodies, also announced with Accept-Encoding
+	FeatureOpaque      = "opaque"      // Opaque items, every field in the blob
+	FeatureDigest      = "digest"      // Bucket digests for sync verify
+	FeatureStream      = "stream"      // Change notifications over server-sent events
+)
+
+// Features are all features of this build
+var Features = []string{
+	FeaturePagination,
+	FeatureCompression,
+	FeatureOpaque,
+	FeatureDigest,
+	FeatureStream,
+}
+
+// Capabilities describes what a server supports, served at
+// /api/v1/capabilities
+type Capabilities struct {
+	Version      int      `json:"version"`     // Newest protocol version of the server
+	MinVersion   int      `json:"min_version"` // Oldest protocol version the server accepts
+	Features     []string `json:"features"`
+	MaxPullLimit int      `json:"max_pull_limit,omitempty"` // Largest pull page
+	MaxPushItems int      `json:"max_push_items,omitempty"` // Largest push batch
+}
+
+// Legacy returns the capabilities of servers from before capabilities were
+// served. They speak version 1 and offer none of the features, each came
+// with the capabilities or later.
+func Legacy() *Capabilities {
+	return &Capabilities{
+		Version:    1,
+		MinVersion: 1,
+		Features:   []string{},
+	}
+}
+
+// Has reports whether the server offers a feature
+func (c *Capabilities) Has(feature string) bool {
+	return slices.Contains(c.Features, feature)
+}
+
+// Check returns an error unless this build and the server share a protocol
+// version
+func (c *Capabilities) Check() error {
+	if c.MinVersion > Version {
+		return fmt.Errorf("the server needs sync protocol %d or newer, this IronTask speaks %d, please upgrade it", c.MinVersion, Version)
+	}
+	if c.Version < MinVersion {
+		return fmt.Errorf("the server speaks sync protocol %d, this IronTask needs %d or newer, please upgrade the server", c.Version, MinVersion)
+	}
+	return nil
+}
+
+// SyncItem is an encrypted project or task as it is pushed and pulled
+type SyncItem struct {
+	ID               string `json:"id"`
+	ClientID         string `json:"client_id"`
+	Type             string `json:"type"` // "project" or "task"
+	Slug             string `json:"slug,omitempty"`
+	Name             string `json:"name,omitempty"`
+	ProjectID        string `json:"project_id,omitempty"`
+	EncryptedData    string `json:"encrypted_data,omitempty"`    // For projects
+	EncryptedContent string `json:"encrypted_content,omitempty"` // For tasks (content only)
+	Status           string `json:"status,omitempty"`
+	Priority         int    `json:"priority,omitempty"`
+	DueDate          string `json:"due_date,omitempty"`
+	SyncVersion      int64  `json:"sync_version"`
+	BaseVersion      int64  `json:"base_version"` // Server version the change is based on, 0 for new items
+	Deleted          bool   `json:"deleted"`
+	ClientUpdatedAt  string `json:"client_updated_at,omitempty"` // Client timestamp, for display only
+	Clock            string `json:"clock,omitempty"`             // Hybrid logical clock of the change, orders edits across devices
+	Blob             string `json:"blob,omitempty"`              // Opaque items: every field encrypted, all metadata above empty
+}
+
+// SyncPullResponse is one page of changes
+type SyncPullResponse struct {
+	Items       []SyncItem `json:"items"`
+	SyncVersion int64      `json:"sync_version"`          // Version of the last item, changes up to it are complete
+	NextCursor  string     `json:"next_cursor,omitempty"` // Set while more pages follow
+	// Pushed changes of the device a concurrent change replaced, set by
+	// backends that find concurrent changes only while reading them
+	Conflicts []ConflictItem `json:"conflicts,omitempty"`
+}
+
+// SyncPushRequest is a batch of local changes
+type SyncPushRequest struct {
+	Items []SyncItem `json:"items"`
+}
+
+// ConflictItem is a pushed change whose base version is outdated
+type ConflictItem struct {
+	ClientID      string   `json:"client_id"`
+	Type          string   `json:"type"`
+	ServerVersion int64    `json:"server_version"`
+	ServerData    SyncItem `json:"server_data"`
+	ClientData    SyncItem `json:"client_data"`
+	// Common state both sides changed, set by pulls that report conflicts
+	BaseData *SyncItem `json:"base_data,omitempty"`
+}
+
+// FailedItem is a pushed item the server could not store
+type FailedItem struct {
+	ClientID string `json:"client_id"`
+	Type     string `json:"type"`
+	Error    string `json:"error"`
+}
+
+// SyncPushResponse is the outcome of a push
+type SyncPushResponse struct {
+	Updated   []SyncItem     `json:"updated"`
+	Conflicts []ConflictItem `json:"conflicts,omitempty"`
+	Failed    []FailedItem   `json:"failed,omitempty"` // Rolled back, the others are stored
+}
+
+// SyncDigestResponse summarizes the live items of an account, with the items
+// of the buckets asked for
+type SyncDigestResponse struct {
+	Buckets []string   `json:"buckets"`
+	Count   int        `json:"count"`
+	Items   []SyncItem `json:"items,omitempty"`
+}
+
+// KeyMaterial is the client's encryption key setup (salt, key check).
+// The server stores it as an opaque string and never interprets it.
+type KeyMaterial struct {
+	KeyData string `json:"key_data"`
+}
+
+// StreamEvent tells that another device pushed changes
+type StreamEvent struct {
+	SyncVersion int64 `json:"sync_version"`
+}
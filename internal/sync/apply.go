@@ -0,0 +1,228 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/existflow/irontask/internal/database"
+	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/logger"
+)
+
+// errMissingProject is returned for a task whose project has not been pulled yet
+var errMissingProject = errors.New("project of task not found")
+
+// ApplyServerItem writes a server item into the local database, replacing the
+// local version and adopting the server's sync_version
+func (c *Client) ApplyServerItem(dbConn *db.DB, item SyncItem) error {
+	return c.applyItem(context.Background(), dbConn.Queries, item, true)
+}
+
+// applyItem decrypts a sync item and upserts it locally. Unpushed local changes
+// are kept unless overwriteDirty is set, they are pending conflicts. Deleted
+// items are stored as tombstones until compaction, or skipped if unknown.
+func (c *Client) applyItem(ctx context.Context, q *database.Queries, item SyncItem, overwriteDirty bool) error {
+	p, err := c.openItem(item)
+	if errors.Is(err, ErrUnknownKey) && item.Deleted && item.Blob == "" {
+		// Deleted before the key was rotated, the local copy is deleted as it is
+		p, err = localPayload(ctx, q, item)
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Debug("Skipping deleted item of a rotated key", logger.F("type", item.Type), logger.F("id", item.ClientID))
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	id := p.ID
+	state := itemState{Fields: *p, Deleted: item.Deleted} // Recorded as the base of later merges
+	updatedAt := remoteStamp(ctx, q, p.UpdatedAt)
+	deletedAt := sql.NullString{String: updatedAt, Valid: item.Deleted}
+
+	switch item.Type {
+	case "project":
+		color := "#4ECDC4"
+		if p.Color != "" {
+			color = p.Color
+		}
+		slug := p.Slug
+		if slug == "" {
+			slug = id // Fallback for old data
+		}
+		name := p.Name
+		if name == "" {
+			name = slug
+		}
+		createdAt := p.CreatedAt
+		if createdAt == "" {
+			createdAt = updatedAt // Pushed by a version that did not sync it
+		}
+
+		// Upsert project with server sync_version
+		existing, err := q.GetProjectForSync(ctx, id)
+		if err == nil && !existing.SyncVersion.Valid && !overwriteDirty {
+			logger.Debug("Keeping unpushed local project", logger.F("id", id), logger.F("syncVersion", item.SyncVersion))
+			return nil
+		}
+		if err != nil && !item.Deleted {
+			// Not found, create
+			logger.Debug("Creating project from sync", logger.F("id", id), logger.F("name", name), logger.F("syncVersion", item.SyncVersion))
+			if err := q.CreateProject(ctx, database.CreateProjectParams{
+				ID:        id,
+				Slug:      slug,
+				Name:      name,
+				Color:     sql.NullString{String: color, Valid: true},
+				Archived:  p.Archived,
+				CreatedAt: createdAt,
+				UpdatedAt: updatedAt,
+			}); err != nil {
+				return err
+			}
+			// Set sync_version from server
+			if err := q.UpdateProjectSyncVersion(ctx, database.UpdateProjectSyncVersionParams{
+				ID:          id,
+				SyncVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
+				BaseVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
+			}); err != nil {
+				return err
+			}
+			return saveBase(ctx, q, item.Type, id, item.SyncVersion, state)
+		}
+		if err != nil {
+			// Deleted projects are stored too, live tasks may still refer to
+			// them. Compaction drops them otherwise.
+			logger.Debug("Creating deleted project from sync", logger.F("id", id), logger.F("syncVersion", item.SyncVersion))
+			if err := q.CreateProject(ctx, database.CreateProjectParams{
+				ID:        id,
+				Slug:      slug,
+				Name:      name,
+				Color:     sql.NullString{String: color, Valid: true},
+				Archived:  p.Archived,
+				CreatedAt: createdAt,
+				UpdatedAt: updatedAt,
+			}); err != nil {
+				return err
+			}
+		}
+
+		// Exists, update with server data and sync_version
+		logger.Debug("Updating project from sync", logger.F("id", id), logger.F("name", name), logger.F("syncVersion", item.SyncVersion))
+		if err := q.OverwriteProject(ctx, database.OverwriteProjectParams{
+			ID:          id,
+			Slug:        slug,
+			Name:        name,
+			Color:       sql.NullString{String: color, Valid: true},
+			Archived:    p.Archived,
+			UpdatedAt:   updatedAt,
+			DeletedAt:   deletedAt,
+			SyncVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
+			BaseVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
+		}); err != nil {
+			return err
+		}
+		return saveBase(ctx, q, item.Type, id, item.SyncVersion, state)
+
+	case "task":
+		status := p.Status
+		if status == "" {
+			status = "process"
+		}
+		tags := sql.NullString{String: p.Tags, Valid: p.Tags != ""}
+		createdAt := p.CreatedAt
+		if createdAt == "" {
+			createdAt = updatedAt // Pushed by a version that did not sync it
+		}
+
+		// Upsert task with server sync_version
+		existing, err := q.GetTaskForSync(ctx, id)
+		if err != nil && item.Deleted {
+			logger.Debug("Skipping deleted task", logger.F("id", id), logger.F("syncVersion", item.SyncVersion))
+			return nil
+		}
+		if n, err := q.ProjectExists(ctx, p.ProjectID); err == nil && n == 0 {
+			return errMissingProject
+		}
+		if err != nil {
+			// Create
+			logger.Debug("Creating task from sync", logger.F("id", id), logger.F("syncVersion", item.SyncVersion))
+			if err := q.CreateTask(ctx, database.CreateTaskParams{
+				ID:        id,
+				ProjectID: p.ProjectID,
+				Content:   p.Content,
+				Status:    sql.NullString{String: status, Valid: true},
+				Priority:  p.Priority,
+				DueDate:   sql.NullString{String: p.DueDate, Valid: p.DueDate != ""},
+				Tags:      tags,
+				CreatedAt: createdAt,
+				UpdatedAt: updatedAt,
+			}); err != nil {
+				return err
+			}
+			// Set sync_version from server
+			if err := q.UpdateTaskSyncVersion(ctx, database.UpdateTaskSyncVersionParams{
+				ID:          id,
+				SyncVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
+				BaseVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
+			}); err != nil {
+				return err
+			}
+			return saveBase(ctx, q, item.Type, id, item.SyncVersion, state)
+		}
+
+		if !existing.SyncVersion.Valid && !overwriteDirty {
+			logger.Debug("Keeping unpushed local task", logger.F("id", id), logger.F("syncVersion", item.SyncVersion))
+			return nil
+		}
+
+		// Exists, update with server data and sync_version
+		logger.Debug("Updating task from sync", logger.F("id", id), logger.F("syncVersion", item.SyncVersion))
+		if err := q.OverwriteTask(ctx, database.OverwriteTaskParams{
+			ID:          id,
+			ProjectID:   p.ProjectID,
+			Content:     p.Content,
+			Status:      sql.NullString{String: status, Valid: true},
+			Priority:    p.Priority,
+			DueDate:     sql.NullString{String: p.DueDate, Valid: p.DueDate != ""},
+			Tags:        tags,
+			UpdatedAt:   updatedAt,
+			DeletedAt:   deletedAt,
+			SyncVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
+			BaseVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
+		}); err != nil {
+			return err
+		}
+		return saveBase(ctx, q, item.Type, id, item.SyncVersion, state)
+	}
+
+	return nil
+}
+
+// localPayload returns the local copy of an item in the form of its decrypted
+// fields, for deletions whose own fields cannot be read anymore
+func localPayload(ctx context.Context, q *database.Queries, item SyncItem) (*itemPayload, error) {
+	p := &itemPayload{ID: item.ClientID}
+	switch item.Type {
+	case "project":
+		row, err := q.GetProjectForSync(ctx, item.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		p.Slug, p.Name, p.Color, p.Archived = row.Slug, row.Name, row.Color.String, row.Archived
+		p.CreatedAt, p.UpdatedAt = row.CreatedAt, row.UpdatedAt
+	case "task":
+		row, err := q.GetTaskForSync(ctx, item.ClientID)
+		if err != nil {
+			return nil, err
+		}
+		p.ProjectID, p.Content, p.Status, p.Priority = row.ProjectID, row.Content, row.Status.String, row.Priority
+		p.DueDate, p.Tags = row.DueDate.String, row.Tags.String
+		p.CreatedAt, p.UpdatedAt = row.CreatedAt, row.UpdatedAt
+	default:
+		return nil, sql.ErrNoRows
+	}
+	if item.Clock != "" {
+		p.UpdatedAt = item.Clock // When it was deleted
+	}
+	return p, nil
+}
@@ -1,6 +1,8 @@
 package sync
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -8,6 +10,12 @@ import (
 	"github.com/existflow/irontask/internal/logger"
 )
 
+// Delays before reopening a dropped change stream
+const (
+	streamMinRetry = 5 * time.Second
+	streamMaxRetry = 5 * time.Minute
+)
+
 // AutoSync manages automatic background syncing
 type AutoSync struct {
 	client       *Client
@@ -16,7 +24,16 @@ type AutoSync struct {
 	pollInterval time.Duration
 	pending      bool
 	syncing      bool // Prevents concurrent sync operations
+	again        bool // A sync was requested while one was running
+	streaming    bool // Change notifications arrive, polling pauses
+	offline      bool // The server could not be reached, retries are scheduled
+	offlineSince time.Time
+	failures     int         // Consecutive failed syncs while offline
+	retry        *time.Timer // Next retry while offline
+	nextRetry    time.Time
+	retryPolicy  RetryPolicy
 	mu           sync.Mutex
+	runMu        sync.Mutex // Held while a sync runs
 	stopCh       chan struct{}
 	onPull       func()               // Callback when remote changes are pulled
 	onConflict   func([]ConflictItem) // Callback when conflicts are detected
@@ -34,11 +51,13 @@ func NewAutoSync(client *Client, database *db.DB) *AutoSync {
 		db:           database,
 		debounceTime: 2 * time.Second,  // Wait 2s after last change before syncing
 		pollInterval: 30 * time.Second, // Poll for remote changes every 30s
+		retryPolicy:  DefaultRetryPolicy,
 		stopCh:       make(chan struct{}),
 	}
 
-	// Start background polling for remote changes
+	// Listen for remote changes, polling covers the time the stream is down
 	go a.pollLoop()
+	go a.streamLoop()
 
 	return a
 }
@@ -57,6 +76,13 @@ func (a *AutoSync) SetOnConflict(callback func([]ConflictItem)) {
 	a.onConflict = callback
 }
 
+// SetRetryPolicy sets how failed syncs are retried while the server is unreachable
+func (a *AutoSync) SetRetryPolicy(policy RetryPolicy) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.retryPolicy = policy
+}
+
 // pollLoop periodically checks for remote changes
 func (a *AutoSync) pollLoop() {
 	logger.Debug("Starting auto-sync poll loop")
@@ -67,7 +93,11 @@ func (a *AutoSync) pollLoop() {
 		select {
 		case <-ticker.C:
 			logger.Debug("Auto-sync poll tick")
-			if a.client.CanAutoSync() {
+			if a.isStreaming() {
+				logger.Debug("Sync stream connected, skipping poll")
+			} else if a.IsOffline() {
+				logger.Debug("Offline, retries are scheduled, skipping poll")
+			} else if a.client.CanAutoSync() {
 				logger.Debug("Triggering auto-sync from poll")
 				a.doSync()
 			} else {
@@ -80,13 +110,77 @@ func (a *AutoSync) pollLoop() {
 	}
 }
 
+// streamLoop keeps a change stream open and syncs on every notification. A
+// dropped stream is reopened after a growing delay, polling runs meanwhile.
+func (a *AutoSync) streamLoop() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-a.stopCh
+		cancel()
+	}()
+
+	device := deviceID(ctx, a.db.Queries)
+	delay := streamMinRetry
+	for {
+		wait := streamMinRetry
+		// Sync folders and repositories send no notifications, polling finds
+		// their changes
+		if a.client.CanAutoSync() && a.client.UsesServer() {
+			err := a.client.Stream(ctx, device, func() {
+				a.setStreaming(true)
+				delay = streamMinRetry
+				go a.doSync() // Catch up on changes missed while disconnected
+			}, func(StreamEvent) {
+				go a.doSync()
+			})
+			a.setStreaming(false)
+			if ctx.Err() != nil {
+				logger.Info("Auto-sync stream loop stopped")
+				return
+			}
+			if errors.Is(err, errStreamUnsupported) {
+				// Check again now and then, the server may be upgraded
+				logger.Debug("Server sends no change notifications, polling")
+				wait = streamMaxRetry
+			} else {
+				logger.Warn("Sync stream dropped, polling until it reconnects",
+					logger.F("error", err),
+					logger.F("retryIn", delay.String()))
+				wait = delay
+				delay = min(delay*2, streamMaxRetry)
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-a.stopCh:
+			logger.Info("Auto-sync stream loop stopped")
+			return
+		}
+	}
+}
+
+func (a *AutoSync) setStreaming(streaming bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.streaming = streaming
+}
+
+func (a *AutoSync) isStreaming() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.streaming
+}
+
 // doSync performs the actual sync, with locking to prevent concurrent syncs
 func (a *AutoSync) doSync() {
 	a.mu.Lock()
 	if a.syncing {
-		logger.Debug("Sync already in progress, skipping")
+		logger.Debug("Sync already in progress, running again afterwards")
+		a.again = true
 		a.mu.Unlock()
-		return // Already syncing, skip
+		return // Already syncing, it may have missed the new changes
 	}
 	a.syncing = true
 	a.mu.Unlock()
@@ -97,24 +191,49 @@ func (a *AutoSync) doSync() {
 	defer func() {
 		a.mu.Lock()
 		a.syncing = false
+		again := a.again
+		a.again = false
 		a.mu.Unlock()
 		duration := time.Since(startTime)
 		logger.Debug("Auto-sync completed", logger.F("duration", duration.String()))
+		if again {
+			go a.doSync()
+		}
 	}()
 
-	result, err := a.client.Sync(a.db, SyncModeMerge)
+	_, _ = a.run(TriggerAuto)
+}
+
+// SyncNow syncs right away, after a sync already running, and returns the
+// result
+func (a *AutoSync) SyncNow(trigger SyncTrigger) (*SyncResult, error) {
+	return a.run(trigger)
+}
+
+// run syncs once and notifies the callbacks, one sync at a time
+func (a *AutoSync) run(trigger SyncTrigger) (*SyncResult, error) {
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+
+	result, err := a.client.SyncWithTrigger(a.db, SyncModeMerge, trigger)
 	a.mu.Lock()
 	a.lastError = err
 	a.mu.Unlock()
 
+	if IsOffline(err) {
+		a.scheduleRetry()
+		return nil, err
+	}
+	a.setOnline()
 	if err != nil {
 		logger.Error("Auto-sync failed", logger.F("error", err))
-		return
+		return nil, err
 	}
 
 	logger.Info("Auto-sync successful",
 		logger.F("pushed", result.Pushed),
-		logger.F("pulled", result.Pulled))
+		logger.F("pulled", result.Pulled),
+		logger.F("failed", len(result.Failed)))
 
 	// If we pulled changes, notify the callback
 	if result.Pulled > 0 {
@@ -139,6 +258,63 @@ func (a *AutoSync) doSync() {
 			conflictCallback(result.Conflicts)
 		}
 	}
+	return result, nil
+}
+
+// scheduleRetry marks the server unreachable and retries after a growing delay.
+// Local changes stay dirty meanwhile and are pushed by the first retry that
+// gets through.
+func (a *AutoSync) scheduleRetry() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	select {
+	case <-a.stopCh:
+		return
+	default:
+	}
+
+	if !a.offline {
+		a.offline = true
+		a.offlineSince = time.Now()
+	}
+	a.failures++
+	a.again = false // The retry covers it
+	delay := a.retryPolicy.Delay(a.failures)
+	if a.retry != nil {
+		a.retry.Stop()
+	}
+	a.nextRetry = time.Now().Add(delay)
+	a.retry = time.AfterFunc(delay, func() {
+		select {
+		case <-a.stopCh:
+		default:
+			a.doSync()
+		}
+	})
+
+	logger.Warn("Server unreachable, retrying sync later",
+		logger.F("error", a.lastError),
+		logger.F("failures", a.failures),
+		logger.F("retryIn", delay.Round(time.Second).String()))
+}
+
+// setOnline clears the offline state after the server was reached
+func (a *AutoSync) setOnline() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.offline {
+		return
+	}
+	logger.Info("Server reachable again",
+		logger.F("offlineFor", time.Since(a.offlineSince).Round(time.Second).String()))
+	a.offline = false
+	a.offlineSince = time.Time{}
+	a.failures = 0
+	a.nextRetry = time.Time{}
+	if a.retry != nil {
+		a.retry.Stop()
+		a.retry = nil
+	}
 }
 
 // TriggerSync marks that a sync is needed (debounced)
@@ -175,10 +351,18 @@ func (a *AutoSync) debouncedSync() {
 	}
 }
 
-// Stop stops the auto-sync manager
+// Stop stops the auto-sync manager, after a sync already running
 func (a *AutoSync) Stop() {
 	logger.Info("Stopping auto-sync")
 	close(a.stopCh)
+	a.mu.Lock()
+	if a.retry != nil {
+		a.retry.Stop()
+	}
+	a.mu.Unlock()
+
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
 }
 
 // SyncNowIfPending performs immediate sync if there are pending changes
@@ -194,7 +378,9 @@ func (a *AutoSync) SyncNowIfPending() error {
 	}
 
 	logger.Info("Executing pending sync immediately")
-	_, err := a.client.Sync(a.db, SyncModeMerge)
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+	_, err := a.client.SyncWithTrigger(a.db, SyncModeMerge, TriggerAuto)
 	if err != nil {
 		logger.Error("Immediate sync failed", logger.F("error", err))
 	} else {
@@ -216,3 +402,29 @@ func (a *AutoSync) GetLastError() error {
 	defer a.mu.Unlock()
 	return a.lastError
 }
+
+// IsOffline returns true if the last sync could not reach the server
+func (a *AutoSync) IsOffline() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.offline
+}
+
+// Status returns the state of background syncing, including the number of
+// local changes waiting to be pushed
+func (a *AutoSync) Status() Status {
+	a.mu.Lock()
+	status := Status{
+		Syncing:      a.pending || a.syncing,
+		Offline:      a.offline,
+		OfflineSince: a.offlineSince,
+		NextRetry:    a.nextRetry,
+		LastError:    a.lastError,
+	}
+	a.mu.Unlock()
+
+	if n, err := a.db.Queries.CountUnsynced(context.Background()); err == nil {
+		status.Pending = int(n)
+	}
+	return status
+}
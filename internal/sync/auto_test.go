@@ -0,0 +1,221 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/existflow/irontask/internal/database"
+	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/protocol"
+)
+
+// fakeServer keeps pushed items in memory and serves them to pulls
+type fakeServer struct {
+	mu      sync.Mutex
+	version int64
+	items   map[string]protocol.SyncItem // By type and client id
+	keyData string                       // Key material, none while empty
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/api/v1/capabilities":
+		_ = json.NewEncoder(w).Encode(protocol.Capabilities{
+			Version:    protocol.Version,
+			MinVersion: protocol.MinVersion,
+			Features:   []string{protocol.FeaturePagination, protocol.FeatureOpaque},
+		})
+	case r.URL.Path == "/api/v1/sync" && r.Method == http.MethodPost:
+		var req protocol.SyncPushRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := protocol.SyncPushResponse{}
+		for _, item := range req.Items {
+			s.version++
+			item.SyncVersion = s.version
+			s.items[item.Type+":"+item.ClientID] = item
+			resp.Updated = append(resp.Updated, item)
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	case r.URL.Path == "/api/v1/keys" && r.Method == http.MethodPut:
+		var km protocol.KeyMaterial
+		if err := json.NewDecoder(r.Body).Decode(&km); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.keyData = km.KeyData
+	case r.URL.Path == "/api/v1/keys":
+		if s.keyData == "" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(protocol.KeyMaterial{KeyData: s.keyData})
+	case r.URL.Path == "/api/v1/sync":
+		since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		resp := protocol.SyncPullResponse{Items: []protocol.SyncItem{}, SyncVersion: s.version}
+		for _, item := range s.items {
+			if item.SyncVersion > since {
+				resp.Items = append(resp.Items, item)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *fakeServer) has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.items[key]
+	return ok
+}
+
+// newTestClient returns a client of the server at url whose settings are
+// saved in dir, syncs read them again. Every test client shares one key.
+func newTestClient(t *testing.T, dir, url string) *Client {
+	t.Helper()
+	crypto, err := NewCryptoFromKey(make([]byte, keySize), KDFParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Client{
+		configPath: filepath.Join(dir, "sync.json"),
+		config:     &Config{ServerURL: url, Token: "token", BlobsMigrated: true},
+		crypto:     crypto,
+		protocol:   newProtocolTransport(http.DefaultTransport),
+	}
+	c.httpClient = &http.Client{Timeout: 5 * time.Second, Transport: c.protocol}
+	if err := c.saveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// waitFor polls cond until it holds or the timeout passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestAutoSyncOffline stops the server while a change is waiting, checks that
+// the sync backs off and reports the change as pending, then starts the server
+// again and checks that the retry pushes it
+func TestAutoSyncOffline(t *testing.T) {
+	dir := t.TempDir()
+	dbConn, err := db.Open(filepath.Join(dir, "tasks.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = dbConn.Close()
+	}()
+
+	fake := &fakeServer{items: make(map[string]protocol.SyncItem)}
+	srv := httptest.NewServer(fake)
+	addr := srv.Listener.Addr().String()
+
+	client := newTestClient(t, dir, srv.URL)
+	if _, err := client.Sync(dbConn, SyncModeMerge); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := RetryPolicy{Base: 50 * time.Millisecond, Max: 200 * time.Millisecond}
+	for failures, want := range map[int]time.Duration{
+		1: 50 * time.Millisecond,
+		2: 100 * time.Millisecond,
+		3: 200 * time.Millisecond,
+		4: 200 * time.Millisecond,
+	} {
+		if got := policy.Delay(failures); got != want {
+			t.Errorf("Delay(%d) = %s, want %s", failures, got, want)
+		}
+	}
+
+	auto := NewAutoSync(client, dbConn)
+	defer auto.Stop()
+	auto.SetRetryPolicy(policy)
+	auto.debounceTime = 10 * time.Millisecond
+
+	// Server down, a local change waits
+	srv.Close()
+	now := time.Now().UTC().Format(time.RFC3339)
+	if err := dbConn.CreateTask(context.Background(), database.CreateTaskParams{
+		ID:        "offline-task",
+		ProjectID: "inbox",
+		Content:   "Written offline",
+		Priority:  4,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	auto.TriggerSync()
+
+	waitFor(t, "offline state", auto.IsOffline)
+	status := auto.Status()
+	if status.OfflineSince.IsZero() || status.NextRetry.IsZero() {
+		t.Errorf("offline status without times: %+v", status)
+	}
+	if status.Pending != 1 {
+		t.Errorf("pending = %d, want 1", status.Pending)
+	}
+	if !strings.HasPrefix(status.String(), "1 change pending, offline since ") {
+		t.Errorf("status = %q", status.String())
+	}
+
+	// Retries keep failing with growing delays, the offline time stays
+	waitFor(t, "three failed retries", func() bool {
+		auto.mu.Lock()
+		defer auto.mu.Unlock()
+		return auto.failures >= 3
+	})
+	if since := auto.Status().OfflineSince; !since.Equal(status.OfflineSince) {
+		t.Errorf("offline since moved from %s to %s", status.OfflineSince, since)
+	}
+	if fake.has("task:offline-task") {
+		t.Fatal("task pushed while the server was down")
+	}
+
+	// Server back on the same address, the next retry pushes the change
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv = httptest.NewUnstartedServer(fake)
+	_ = srv.Listener.Close()
+	srv.Listener = ln
+	srv.Start()
+	defer srv.Close()
+
+	waitFor(t, "the retry", func() bool {
+		return !auto.IsOffline()
+	})
+	if !fake.has("task:offline-task") {
+		t.Fatal("task not pushed after the server came back")
+	}
+	status = auto.Status()
+	if status.Pending != 0 || !status.NextRetry.IsZero() || !status.OfflineSince.IsZero() {
+		t.Errorf("status after the retry: %+v", status)
+	}
+}
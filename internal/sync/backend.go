@@ -0,0 +1,297 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/internal/protocol"
+)
+
+// Backend stores the encrypted items of an account and hands out the changes
+// of other devices. Versions are assigned by the backend, increase with every
+// stored change and are the cursor of pulls and the base of pushed changes.
+type Backend interface {
+	// Push stores a batch of changes. Items whose stored version moved on
+	// since their base version are returned as conflicts.
+	Push(items []SyncItem, device string) (*SyncPushResponse, error)
+	// Pull returns one page of changes after since, a cursor continues a
+	// paged pull. An empty device does not record the pull.
+	Pull(since int64, cursor, device string) (*SyncPullResponse, error)
+	// Clear removes every item
+	Clear() error
+	// Digest summarizes the live items, with the items of the given buckets
+	Digest(buckets []int) (*SyncDigestResponse, error)
+	// KeyData returns the stored key material, empty if none exists yet
+	KeyData() (string, error)
+	// PutKeyData stores the key material
+	PutKeyData(data string) error
+}
+
+// backend returns the backend the client syncs with
+func (c *Client) backend() Backend {
+	if c.config.Folder != "" {
+		return newFolderBackend(c, c.config.Folder)
+	}
+	if c.config.Git != "" {
+		return newGitBackend(c, c.config.Git)
+	}
+	return &httpBackend{c: c}
+}
+
+// errNoDigest is returned by servers too old for sync verify
+var errNoDigest = errors.New("the server does not support verification, please upgrade it")
+
+// httpBackend syncs with the IronTask server
+type httpBackend struct {
+	c *Client
+}
+
+// Push sends one batch of local changes to the server. The device id keeps
+// the server from notifying this device of its own changes.
+func (b *httpBackend) Push(items []SyncItem, device string) (*SyncPushResponse, error) {
+	body, _ := json.Marshal(protocol.SyncPushRequest{Items: items})
+
+	url := b.c.config.ServerURL + "/api/v1/sync"
+	logger.Debug("HTTP Request",
+		logger.F("method", "POST"),
+		logger.F("url", url),
+		logger.F("bodySize", len(body)))
+
+	req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+b.c.config.Token)
+	req.Header.Set("X-Device-ID", device)
+
+	resp, err := b.c.httpClient.Do(req)
+	if err != nil {
+		logger.Error("HTTP request failed", logger.F("error", err), logger.F("url", url))
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	logger.Debug("HTTP Response",
+		logger.F("status", resp.StatusCode),
+		logger.F("statusText", resp.Status))
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		logger.Error("Push failed",
+			logger.F("status", resp.StatusCode),
+			logger.F("response", string(respBody)))
+		return nil, fmt.Errorf("server error: %s", string(respBody))
+	}
+
+	var result SyncPushResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid push response: %w", err)
+	}
+
+	logger.Info("Push completed",
+		logger.F("updated", len(result.Updated)),
+		logger.F("conflicts", len(result.Conflicts)),
+		logger.F("failed", len(result.Failed)))
+
+	return &result, nil
+}
+
+// Pull gets one page of remote changes, the first one starts at since. The
+// device id lets the server track how far this device pulled, without it the
+// pull is not recorded.
+func (b *httpBackend) Pull(since int64, cursor, device string) (*SyncPullResponse, error) {
+	url := fmt.Sprintf("%s/api/v1/sync?since=%d", b.c.config.ServerURL, since)
+	if b.c.serverHas(protocol.FeaturePagination) {
+		// Older servers send every change at once
+		limit, _ := b.c.batchSizes()
+		url += fmt.Sprintf("&limit=%d", limit)
+		if cursor != "" {
+			url += "&cursor=" + cursor
+		}
+	}
+
+	logger.Debug("Pulling changes from server", logger.F("since", since), logger.F("cursor", cursor))
+	logger.Debug("HTTP Request",
+		logger.F("method", "GET"),
+		logger.F("url", url))
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Authorization", "Bearer "+b.c.config.Token)
+	if device != "" {
+		req.Header.Set("X-Device-ID", device)
+	}
+
+	resp, err := b.c.httpClient.Do(req)
+	if err != nil {
+		logger.Error("HTTP request failed", logger.F("error", err), logger.F("url", url))
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	logger.Debug("HTTP Response",
+		logger.F("status", resp.StatusCode),
+		logger.F("statusText", resp.Status))
+
+	if resp.StatusCode == http.StatusGone {
+		return nil, errResyncRequired
+	}
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		logger.Error("Pull failed",
+			logger.F("status", resp.StatusCode),
+			logger.F("response", string(respBody)))
+		return nil, fmt.Errorf("server error: %s", string(respBody))
+	}
+
+	var result SyncPullResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid pull response: %w", err)
+	}
+
+	logger.Info("Received items from server",
+		logger.F("itemCount", len(result.Items)),
+		logger.F("syncVersion", result.SyncVersion),
+		logger.F("more", result.NextCursor != ""))
+
+	return &result, nil
+}
+
+// Clear wipes all data of the account on the server
+func (b *httpBackend) Clear() error {
+	req, err := http.NewRequest("POST", b.c.config.ServerURL+"/api/v1/clear", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.c.config.Token)
+
+	resp, err := b.c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("remote clear failed: %s", string(body))
+	}
+
+	return nil
+}
+
+// Digest gets the server digest, with the items of the given buckets
+func (b *httpBackend) Digest(buckets []int) (*SyncDigestResponse, error) {
+	caps, err := b.c.ServerCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	if !caps.Has(protocol.FeatureDigest) {
+		return nil, errNoDigest
+	}
+
+	params := url.Values{}
+	for _, bucket := range buckets {
+		params.Add("bucket", strconv.Itoa(bucket))
+	}
+	u := b.c.config.ServerURL + "/api/v1/sync/digest"
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	logger.Debug("HTTP Request",
+		logger.F("method", "GET"),
+		logger.F("url", u))
+
+	req, _ := http.NewRequest("GET", u, nil)
+	req.Header.Set("Authorization", "Bearer "+b.c.config.Token)
+
+	resp, err := b.c.httpClient.Do(req)
+	if err != nil {
+		logger.Error("HTTP request failed", logger.F("error", err), logger.F("url", u))
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNoDigest
+	}
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server error: %s", string(respBody))
+	}
+
+	var result SyncDigestResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid digest: %w", err)
+	}
+	return &result, nil
+}
+
+// KeyData gets the key material from the server
+func (b *httpBackend) KeyData() (string, error) {
+	req, err := http.NewRequest("GET", b.c.config.ServerURL+"/api/v1/keys", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.c.config.Token)
+
+	resp, err := b.c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("fetching key failed: %s", string(respBody))
+	}
+
+	var result protocol.KeyMaterial
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.KeyData, nil
+}
+
+// PutKeyData stores the key material on the server
+func (b *httpBackend) PutKeyData(data string) error {
+	body, _ := json.Marshal(protocol.KeyMaterial{KeyData: data})
+
+	req, err := http.NewRequest("PUT", b.c.config.ServerURL+"/api/v1/keys", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+b.c.config.Token)
+
+	resp, err := b.c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("storing key failed: %s", string(respBody))
+	}
+	return nil
+}
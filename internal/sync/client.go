@@ -3,7 +3,6 @@ package sync
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,41 +11,67 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/existflow/irontask/internal/config"
 	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/protocol"
 )
 
 // Config holds sync configuration
 type Config struct {
-	ServerURL     string `json:"server_url"`
-	Token         string `json:"token"`
-	UserID        string `json:"user_id"`
-	LastSync      int64  `json:"last_sync"`
-	LastSyncTime  int64  `json:"last_sync_time"` // Unix timestamp of last sync
-	HasSyncedOnce bool   `json:"has_synced_once"`
-	EncryptionKey string `json:"encryption_key,omitempty"` // Base64 encoded
-	Salt          string `json:"salt,omitempty"`           // Base64 encoded salt for key derivation
+	ServerURL     string     `json:"server_url"`
+	Token         string     `json:"token"`
+	UserID        string     `json:"user_id"`
+	LastSync      int64      `json:"last_sync"`
+	LastSyncTime  int64      `json:"last_sync_time"` // Unix timestamp of last sync
+	HasSyncedOnce bool       `json:"has_synced_once"`
+	EncryptionKey string     `json:"encryption_key,omitempty"` // Base64 encoded derived key, cached after 'sync key'
+	KeyParams     *KDFParams `json:"key_params,omitempty"`     // How EncryptionKey was derived
+	KeyID         string     `json:"key_id,omitempty"`         // Id of EncryptionKey, compared with the server on every sync
+	Salt          string     `json:"salt,omitempty"`           // Base64 encoded PBKDF2 salt of keys cached before KeyParams
+	BlobsMigrated bool       `json:"blobs_migrated,omitempty"` // Plaintext blobs from older versions were re-uploaded encrypted
+	Opaque        bool       `json:"opaque,omitempty"`         // Push every field inside the encrypted blob, see SetOpaque
+	ReplaceRemote bool       `json:"replace_remote,omitempty"` // Next push must replace the server copy entirely
+	BatchSize     int        `json:"batch_size,omitempty"`     // Items per pull page and push request, see SetBatchSize
+	Folder        string     `json:"folder,omitempty"`         // Shared folder synced through instead of the server, see SetFolder
+	Git           string     `json:"git,omitempty"`            // Git repository synced through instead of the server, see SetGit
 }
 
+const (
+	defaultBatchSize = 500
+	maxBatchSize     = 1000 // Largest page the server accepts
+)
+
 // Client is the sync client
 type Client struct {
 	config     *Config
 	configPath string
 	httpClient *http.Client
+	crypto     *Crypto // Cached crypto built from the configured key
+	protocol   *protocolTransport
+	caps       serverCaps  // Capabilities of the server, fetched on first use
+	lock       profileLock // See Lock
 }
 
-// NewClient creates a new sync client
+// NewClient creates a sync client for the active profile
 func NewClient() (*Client, error) {
-	home, err := os.UserHomeDir()
+	return NewProfileClient(config.ActiveProfile())
+}
+
+// NewProfileClient creates a sync client for a profile, each profile has its
+// own server, account, key and sync cursor
+func NewProfileClient(profile string) (*Client, error) {
+	dir, err := config.ProfileDir(profile)
 	if err != nil {
 		return nil, err
 	}
 
-	configPath := filepath.Join(home, ".irontask", "sync.json")
+	configPath := filepath.Join(dir, "sync.json")
 
 	c := &Client{
 		configPath: configPath,
-		httpClient: &http.Client{Timeout: 30 * time.Second},
+		protocol:   newProtocolTransport(newGzipTransport(http.DefaultTransport)),
 	}
+	c.httpClient = &http.Client{Timeout: 30 * time.Second, Transport: c.protocol}
 
 	// Load existing config
 	c.loadConfig()
@@ -97,9 +122,149 @@ func (c *Client) SetServer(url string) error {
 	return c.saveConfig()
 }
 
-// IsLoggedIn returns true if user is logged in
+// SetBatchSize sets how many items are pulled or pushed per request, 0 restores
+// the default
+func (c *Client) SetBatchSize(n int) error {
+	if n < 0 || n > maxBatchSize {
+		return fmt.Errorf("batch size must be between 1 and %d", maxBatchSize)
+	}
+	c.config.BatchSize = n
+	return c.saveConfig()
+}
+
+// BatchSize returns the configured batch size or the default
+func (c *Client) BatchSize() int {
+	if c.config.BatchSize <= 0 || c.config.BatchSize > maxBatchSize {
+		return defaultBatchSize
+	}
+	return c.config.BatchSize
+}
+
+// SetFolder syncs through a shared folder instead of the server, or with the
+// server again if path is empty
+func (c *Client) SetFolder(dbConn *db.DB, path string) error {
+	if path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			return fmt.Errorf("sync folder not available: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", abs)
+		}
+		path = abs
+	}
+	git := c.config.Git
+	if path != "" {
+		git = ""
+	}
+	return c.switchBackend(dbConn, path, git)
+}
+
+// Folder returns the shared folder synced through, empty when syncing with the
+// server
+func (c *Client) Folder() string {
+	return c.config.Folder
+}
+
+// SetGit syncs through a git repository instead of the server, or with the
+// server again if remote is empty. The remote is any URL git can fetch and
+// push without asking for a password, or the path of a local repository.
+func (c *Client) SetGit(dbConn *db.DB, remote string) error {
+	if remote != "" {
+		if info, err := os.Stat(remote); err == nil && info.IsDir() {
+			// Local paths are used from the device's clone
+			abs, err := filepath.Abs(remote)
+			if err != nil {
+				return err
+			}
+			remote = abs
+		}
+		if _, err := runGit("", "ls-remote", remote); err != nil {
+			return fmt.Errorf("sync repository not available: %w", err)
+		}
+	}
+	folder := c.config.Folder
+	if remote != "" {
+		folder = ""
+	}
+	return c.switchBackend(dbConn, folder, remote)
+}
+
+// Git returns the git repository synced through, empty when syncing with the
+// server
+func (c *Client) Git() string {
+	return c.config.Git
+}
+
+// UsesServer returns true if the client syncs with the server, not through a
+// folder or repository
+func (c *Client) UsesServer() bool {
+	return c.config.Folder == "" && c.config.Git == ""
+}
+
+// switchBackend sets the folder and repository synced through. Versions of one
+// backend mean nothing to another, so every local item is pushed again and
+// everything is pulled.
+func (c *Client) switchBackend(dbConn *db.DB, folder, git string) error {
+	if c.config.Folder == folder && c.config.Git == git {
+		return nil
+	}
+
+	if err := markAllDirty(dbConn); err != nil {
+		return err
+	}
+	c.config.Folder = folder
+	c.config.Git = git
+	c.config.LastSync = 0
+	c.config.HasSyncedOnce = false
+	c.config.ReplaceRemote = false
+	return c.saveConfig()
+}
+
+// IsOpaque returns true if items are pushed without any plaintext metadata
+func (c *Client) IsOpaque() bool {
+	return c.config.Opaque
+}
+
+// SetOpaque switches zero-metadata mode. Items are pushed under other ids in
+// each mode, so local changes are synced first, then everything is marked for
+// re-upload and the next sync replaces the server copy.
+func (c *Client) SetOpaque(dbConn *db.DB, opaque bool) error {
+	if c.config.Opaque == opaque {
+		return nil
+	}
+	if opaque && c.IsLoggedIn() && c.UsesServer() {
+		caps, err := c.ServerCapabilities()
+		if err != nil {
+			return err
+		}
+		if !caps.Has(protocol.FeatureOpaque) {
+			return fmt.Errorf("the server does not support opaque mode, please upgrade it")
+		}
+	}
+
+	if c.IsLoggedIn() {
+		if _, err := c.SyncWithTrigger(dbConn, SyncModeMerge, TriggerOpaque); err != nil {
+			return fmt.Errorf("sync before switching mode failed: %w", err)
+		}
+		if err := markAllDirty(dbConn); err != nil {
+			return err
+		}
+		c.config.ReplaceRemote = true
+	}
+
+	c.config.Opaque = opaque
+	return c.saveConfig()
+}
+
+// IsLoggedIn returns true if user is logged in, or syncs through a shared
+// folder or git repository, which need no account
 func (c *Client) IsLoggedIn() bool {
-	return c.config.Token != ""
+	return c.config.Token != "" || !c.UsesServer()
 }
 
 // CanAutoSync returns true if auto-sync is allowed (logged in AND has synced once)
@@ -124,6 +289,12 @@ func (c *Client) ShouldAutoSync() bool {
 
 // UpdateSyncTime updates the last sync timestamp
 func (c *Client) UpdateSyncTime() error {
+	unlock, err := c.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	c.config.LastSyncTime = time.Now().Unix()
 	return c.saveConfig()
 }
@@ -280,8 +451,11 @@ func (c *Client) Logout() error {
 
 	c.config.Token = ""
 	c.config.UserID = ""
+	c.config.Folder = ""
+	c.config.Git = ""
 	c.config.LastSync = 0
 	c.config.HasSyncedOnce = false
+	c.clearEncryptionKey()
 	return c.saveConfig()
 }
 
@@ -294,7 +468,10 @@ func (c *Client) ClearLocal(dbConn *db.DB) error {
 	if err := dbConn.ClearProjects(ctx); err != nil {
 		return err
 	}
-	return nil
+	if err := dbConn.ClearSyncConflicts(ctx); err != nil {
+		return err
+	}
+	return dbConn.ClearSyncBase(ctx)
 }
 
 // ClearRemote wipes all remote data
@@ -302,69 +479,10 @@ func (c *Client) ClearRemote() error {
 	if !c.IsLoggedIn() {
 		return fmt.Errorf("not logged in")
 	}
-
-	req, err := http.NewRequest("POST", c.config.ServerURL+"/api/v1/clear", nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Authorization", "Bearer "+c.config.Token)
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("remote clear failed: %s", string(body))
-	}
-
-	return nil
+	return c.backend().Clear()
 }
 
 // GetStatus returns current sync status
 func (c *Client) GetStatus() (string, string, int64) {
 	return c.config.ServerURL, c.config.UserID, c.config.LastSync
 }
-
-// GetEncryptionKey returns the display key (first 16 chars)
-func (c *Client) GetEncryptionKey() string {
-	return c.config.EncryptionKey
-}
-
-// GenerateEncryptionKey generates encryption key from password
-func (c *Client) GenerateEncryptionKey(password string) (string, error) {
-	salt, err := GenerateSalt()
-	if err != nil {
-		return "", err
-	}
-
-	// Store salt as base64
-	c.config.Salt = base64.StdEncoding.EncodeToString(salt)
-
-	// Generate display key
-	c.config.EncryptionKey = DeriveKeyDisplay(password, salt)
-
-	if err := c.saveConfig(); err != nil {
-		return "", err
-	}
-
-	return c.config.EncryptionKey, nil
-}
-
-// GetCrypto returns a Crypto instance for encryption/decryption
-func (c *Client) GetCrypto(password string) (*Crypto, error) {
-	if c.config.Salt == "" {
-		return nil, fmt.Errorf("no encryption key configured, run 'irontask sync key' first")
-	}
-
-	salt, err := base64.StdEncoding.DecodeString(c.config.Salt)
-	if err != nil {
-		return nil, err
-	}
-
-	return NewCrypto(password, salt), nil
-}
@@ -0,0 +1,207 @@
+package sync
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/existflow/irontask/internal/database"
+	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/logger"
+)
+
+// Local changes are stamped by a hybrid logical clock: the physical time in
+// milliseconds, a counter ordering changes within the same millisecond or
+// behind a clock that ran ahead, and the device id as tie breaker. Every
+// timestamp is after all changes the device has seen, and formatted they sort
+// as strings, so updated_at keeps ordering rows.
+
+const (
+	clockLayout   = "2006-01-02T15:04:05.000Z"
+	clockStateKey = "clock"        // sync_state key of the last timestamp
+	clockNodeKey  = "clock_node"   // sync_state key of this device's id
+	maxClockDrift = 24 * time.Hour // Remote clocks further ahead are not adopted
+	maxCounter    = 0xffff         // Largest counter, the wall time moves on beyond
+)
+
+// clockMu serializes clock updates within the process
+var clockMu sync.Mutex
+
+// Timestamp is a hybrid logical clock timestamp
+type Timestamp struct {
+	Wall    int64 // Unix milliseconds
+	Counter int
+	Node    string
+}
+
+// String formats t so that timestamps sort as strings
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%s-%04x-%s", time.UnixMilli(t.Wall).UTC().Format(clockLayout), t.Counter, t.Node)
+}
+
+// Time returns the physical time of t
+func (t Timestamp) Time() time.Time {
+	return time.UnixMilli(t.Wall)
+}
+
+// After reports whether t orders after u
+func (t Timestamp) After(u Timestamp) bool {
+	if t.Wall != u.Wall {
+		return t.Wall > u.Wall
+	}
+	if t.Counter != u.Counter {
+		return t.Counter > u.Counter
+	}
+	return t.Node > u.Node
+}
+
+// ParseTimestamp parses a clock timestamp. Plain RFC 3339 times written by
+// older versions parse with a zero counter and no node.
+func ParseTimestamp(s string) (Timestamp, error) {
+	if len(s) > len(clockLayout) && s[len(clockLayout)] == '-' {
+		wall, err := time.Parse(clockLayout, s[:len(clockLayout)])
+		if err != nil {
+			return Timestamp{}, err
+		}
+		counter, node, ok := strings.Cut(s[len(clockLayout)+1:], "-")
+		if !ok {
+			return Timestamp{}, fmt.Errorf("invalid clock timestamp %q", s)
+		}
+		n, err := strconv.ParseUint(counter, 16, 16)
+		if err != nil {
+			return Timestamp{}, fmt.Errorf("invalid clock timestamp %q", s)
+		}
+		return Timestamp{Wall: wall.UnixMilli(), Counter: int(n), Node: node}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return Timestamp{}, err
+	}
+	return Timestamp{Wall: t.UnixMilli()}, nil
+}
+
+// clockTime returns the physical time of a clock timestamp as RFC 3339, for
+// display and for servers that only store times
+func clockTime(s string) string {
+	t, err := ParseTimestamp(s)
+	if err != nil {
+		return s
+	}
+	return t.Time().UTC().Format(time.RFC3339)
+}
+
+// Now stamps a local change with the device's clock
+func Now(dbConn *db.DB) string {
+	return stamp(context.Background(), dbConn.Queries)
+}
+
+// deviceID returns the id of this device, the clock's tie breaker. The server
+// tracks how far each device pulled by it.
+func deviceID(ctx context.Context, q *database.Queries) string {
+	clockMu.Lock()
+	defer clockMu.Unlock()
+
+	_, node := loadClock(ctx, q)
+	return node
+}
+
+// stamp advances the clock for a local change and returns its timestamp
+func stamp(ctx context.Context, q *database.Queries) string {
+	clockMu.Lock()
+	defer clockMu.Unlock()
+
+	last, node := loadClock(ctx, q)
+	next := Timestamp{Wall: time.Now().UnixMilli(), Node: node}
+	if last.Wall >= next.Wall {
+		next.Wall = last.Wall
+		next.Counter = last.Counter + 1
+	}
+
+	next = saveClock(ctx, q, next)
+	return next.String()
+}
+
+// observe merges the timestamp of a remote change into the clock, so local
+// changes made afterwards order after it
+func observe(ctx context.Context, q *database.Queries, remote string) {
+	if remote == "" {
+		return
+	}
+	r, err := ParseTimestamp(remote)
+	if err != nil {
+		return
+	}
+	now := time.Now().UnixMilli()
+	if r.Wall-now > maxClockDrift.Milliseconds() {
+		logger.Warn("Ignoring remote clock far ahead", logger.F("clock", remote))
+		return
+	}
+
+	clockMu.Lock()
+	defer clockMu.Unlock()
+
+	last, node := loadClock(ctx, q)
+	next := Timestamp{Wall: max(last.Wall, r.Wall, now), Node: node}
+	switch {
+	case next.Wall == last.Wall && next.Wall == r.Wall:
+		next.Counter = max(last.Counter, r.Counter) + 1
+	case next.Wall == last.Wall:
+		next.Counter = last.Counter + 1
+	case next.Wall == r.Wall:
+		next.Counter = r.Counter + 1
+	}
+
+	saveClock(ctx, q, next)
+}
+
+// remoteStamp merges the clock of a pulled change and returns the timestamp to
+// store it under, a fresh one if the change has none
+func remoteStamp(ctx context.Context, q *database.Queries, remote string) string {
+	if _, err := ParseTimestamp(remote); err != nil {
+		return stamp(ctx, q)
+	}
+	observe(ctx, q, remote)
+	return remote
+}
+
+// loadClock returns the last timestamp of the clock and the device id, which is
+// created on first use
+func loadClock(ctx context.Context, q *database.Queries) (Timestamp, string) {
+	node, err := q.GetSyncState(ctx, clockNodeKey)
+	if err != nil || node.String == "" {
+		b := make([]byte, 4)
+		_, _ = rand.Read(b)
+		node = sql.NullString{String: hex.EncodeToString(b), Valid: true}
+		if err := q.SetSyncState(ctx, database.SetSyncStateParams{Key: clockNodeKey, Value: node}); err != nil {
+			logger.Error("Failed to save clock node", logger.F("error", err))
+		}
+	}
+
+	var last Timestamp
+	if state, err := q.GetSyncState(ctx, clockStateKey); err == nil {
+		last, _ = ParseTimestamp(state.String)
+	}
+	return last, node.String
+}
+
+// saveClock stores the last timestamp of the clock
+func saveClock(ctx context.Context, q *database.Queries, t Timestamp) Timestamp {
+	if t.Counter > maxCounter {
+		t.Wall++
+		t.Counter = 0
+	}
+	if err := q.SetSyncState(ctx, database.SetSyncStateParams{
+		Key:   clockStateKey,
+		Value: sql.NullString{String: t.String(), Valid: true},
+	}); err != nil {
+		logger.Error("Failed to save clock", logger.F("error", err))
+	}
+	return t
+}
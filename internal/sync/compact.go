@@ -0,0 +1,79 @@
+package sync
+
+import (
+	"context"
+	"errors"
+
+	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/logger"
+)
+
+// Deleted items stay as tombstones until their deletion is synced. The server
+// purges a tombstone once every device pulled past it, a device that was away
+// longer is told to pull everything again.
+
+// errResyncRequired is returned by a pull from a version the server purged
+// tombstones beyond, the device may have missed deletions
+var errResyncRequired = errors.New("server purged deletions since the last sync")
+
+// compactLocal hard-deletes local tombstones the server acknowledged. Their
+// deletion is pushed, so nothing is lost, and later changes from other devices
+// arrive as new items.
+func compactLocal(dbConn *db.DB) error {
+	ctx := context.Background()
+	tx, err := dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	q := dbConn.Queries.WithTx(tx)
+
+	// Tasks first, projects are kept while a task refers to them
+	tasks, err := q.PurgeDeletedTasks(ctx)
+	if err != nil {
+		return err
+	}
+	projects, err := q.PurgeDeletedProjects(ctx)
+	if err != nil {
+		return err
+	}
+	if err := q.DeleteOrphanSyncBase(ctx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if tasks+projects > 0 {
+		logger.Info("Purged synced deletions", logger.F("tasks", tasks), logger.F("projects", projects))
+	}
+	return nil
+}
+
+// dropSynced removes every local item without unpushed changes, so a pull from
+// version 0 restores exactly the server state. Unpushed changes are kept and
+// pushed by the next sync.
+func dropSynced(dbConn *db.DB) error {
+	ctx := context.Background()
+	tx, err := dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	q := dbConn.Queries.WithTx(tx)
+
+	if err := q.DeleteSyncedTasks(ctx); err != nil {
+		return err
+	}
+	if err := q.DeleteSyncedProjects(ctx); err != nil {
+		return err
+	}
+	if err := q.DeleteOrphanSyncBase(ctx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,82 @@
+package sync
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// gzipMinLength is the smallest request body worth compressing
+const gzipMinLength = 1024
+
+// gzipTransport gzips request bodies once the server has advertised support
+// with an Accept-Encoding response header. Responses need nothing here, the
+// standard transport asks for gzip and inflates them transparently.
+type gzipTransport struct {
+	base     http.RoundTripper
+	accepted atomic.Bool // The server takes gzip request bodies
+}
+
+func newGzipTransport(base http.RoundTripper) *gzipTransport {
+	return &gzipTransport{base: base}
+}
+
+func (t *gzipTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.accepted.Load() && req.Body != nil && req.ContentLength >= gzipMinLength &&
+		req.Header.Get("Content-Encoding") == "" {
+		compressed, err := gzipRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		req = compressed
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && acceptsGzip(resp.Header.Get("Accept-Encoding")) {
+		t.accepted.Store(true)
+	}
+	return resp, err
+}
+
+// gzipRequest returns a copy of req with a gzipped body
+func gzipRequest(req *http.Request) (*http.Request, error) {
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+
+	out := req.Clone(req.Context())
+	out.Body = io.NopCloser(bytes.NewReader(data))
+	out.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	out.ContentLength = int64(len(data))
+	out.Header.Set("Content-Encoding", "gzip")
+	return out, nil
+}
+
+// acceptsGzip reports whether an Accept-Encoding value lists gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(coding) != "gzip" {
+			continue
+		}
+		return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+	}
+	return false
+}
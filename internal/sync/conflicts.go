@@ -0,0 +1,240 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/existflow/irontask/internal/database"
+	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/logger"
+)
+
+// Every sync pushes all local changes, so the conflicts it reports are all
+// conflicts there are. They are stored on the device until the next sync or
+// until they are resolved, for the TUI and the CLI alike.
+
+// StoredConflict is an unresolved conflict of the last sync
+type StoredConflict struct {
+	ConflictItem
+	DetectedAt time.Time `json:"detected_at"` // First sync that reported it
+}
+
+// Resolution is how a conflict is resolved as a whole
+type Resolution string
+
+const (
+	ResolveLocal  Resolution = "local"  // Keep the local version
+	ResolveServer Resolution = "server" // Keep the server version
+	ResolveMerged Resolution = "merged" // Keep changes of either side, the newer edit of fields changed on both
+)
+
+// FieldDiff is a synced field of a conflicting item on every side
+type FieldDiff struct {
+	Field    string `json:"field"`
+	Base     string `json:"base"` // Empty if the last synced version is unknown
+	Local    string `json:"local"`
+	Server   string `json:"server"`
+	Merged   string `json:"merged"`   // Value after resolving with ResolveMerged
+	Conflict bool   `json:"conflict"` // Changed on both sides to different values
+}
+
+// Conflicts returns the stored conflicts, oldest first
+func Conflicts(dbConn *db.DB) ([]StoredConflict, error) {
+	rows, err := dbConn.ListSyncConflicts(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []StoredConflict
+	for _, row := range rows {
+		var conflict StoredConflict
+		if err := json.Unmarshal([]byte(row.Data), &conflict.ConflictItem); err != nil {
+			logger.Warn("Skipping unreadable stored conflict", logger.F("id", row.ItemID), logger.F("error", err))
+			continue
+		}
+		conflict.DetectedAt, _ = time.Parse(runTimeLayout, row.DetectedAt)
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts, nil
+}
+
+// FindConflict returns the stored conflict of an item id or unique id prefix
+func FindConflict(dbConn *db.DB, ref string) (*StoredConflict, error) {
+	conflicts, err := Conflicts(dbConn)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []StoredConflict
+	for _, conflict := range conflicts {
+		if conflict.ClientID == ref {
+			return &conflict, nil
+		}
+		if strings.HasPrefix(conflict.ClientID, ref) {
+			found = append(found, conflict)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no conflict for %q", ref)
+	case 1:
+		return &found[0], nil
+	}
+	return nil, fmt.Errorf("%q matches %d conflicts, use a longer id", ref, len(found))
+}
+
+// storeConflicts replaces the stored conflicts with those of a finished sync
+func storeConflicts(dbConn *db.DB, conflicts []ConflictItem) error {
+	ctx := context.Background()
+	tx, err := dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	q := dbConn.Queries.WithTx(tx)
+
+	rows, err := q.ListSyncConflicts(ctx)
+	if err != nil {
+		return err
+	}
+	detected := make(map[string]string)
+	for _, row := range rows {
+		detected[itemKey(row.ItemType, row.ItemID)] = row.DetectedAt
+	}
+	if err := q.ClearSyncConflicts(ctx); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(runTimeLayout)
+	for _, conflict := range conflicts {
+		data, err := json.Marshal(conflict)
+		if err != nil {
+			return err
+		}
+		at, ok := detected[itemKey(conflict.Type, conflict.ClientID)]
+		if !ok {
+			at = now
+		}
+		if err := q.UpsertSyncConflict(ctx, database.UpsertSyncConflictParams{
+			ItemType:      conflict.Type,
+			ItemID:        conflict.ClientID,
+			ServerVersion: conflict.ServerVersion,
+			DetectedAt:    at,
+			Data:          string(data),
+		}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// dropConflict removes the stored conflict of a resolved item
+func dropConflict(ctx context.Context, q *database.Queries, conflict ConflictItem) error {
+	return q.DeleteSyncConflict(ctx, database.DeleteSyncConflictParams{
+		ItemType: conflict.Type,
+		ItemID:   conflict.ClientID,
+	})
+}
+
+// Resolve resolves a conflict as a whole. Results that differ from the server
+// version are pushed by the next sync.
+func (c *Client) Resolve(dbConn *db.DB, conflict ConflictItem, how Resolution) error {
+	switch how {
+	case ResolveLocal:
+		return c.KeepLocal(dbConn, conflict)
+	case ResolveServer:
+		if err := c.ApplyServerItem(dbConn, conflict.ServerData); err != nil {
+			return err
+		}
+		return dropConflict(context.Background(), dbConn.Queries, conflict)
+	case ResolveMerged:
+		return c.ResolveConflict(dbConn, conflict, c.newerSide(conflict))
+	}
+	return fmt.Errorf("unknown resolution %q, use local, server or merged", how)
+}
+
+// DiffConflict returns every synced field of a conflicting item on each side,
+// the deleted flag first
+func (c *Client) DiffConflict(dbConn *db.DB, conflict ConflictItem) ([]FieldDiff, error) {
+	local, server, err := c.conflictStates(conflict)
+	if err != nil {
+		return nil, err
+	}
+	base := c.loadBase(context.Background(), dbConn.Queries, conflict.Type, conflict.ClientID, conflict.ClientData.BaseVersion)
+	merged, fields := mergeItems(conflict.Type, base, local, server)
+	merged = applyChoices(conflict.Type, merged, local, fields, c.newerSide(conflict))
+
+	conflicting := make(map[string]bool)
+	for _, f := range fields {
+		conflicting[f.Field] = true
+	}
+
+	deleted := FieldDiff{
+		Field:    fieldDeleted,
+		Local:    strconv.FormatBool(local.Deleted),
+		Server:   strconv.FormatBool(server.Deleted),
+		Merged:   strconv.FormatBool(merged.Deleted),
+		Conflict: conflicting[fieldDeleted],
+	}
+	if base != nil {
+		deleted.Base = strconv.FormatBool(base.Deleted)
+	}
+	diff := []FieldDiff{deleted}
+
+	for _, f := range fieldsOf(conflict.Type) {
+		d := FieldDiff{
+			Field:    f.name,
+			Local:    f.get(&local.Fields),
+			Server:   f.get(&server.Fields),
+			Merged:   f.get(&merged.Fields),
+			Conflict: conflicting[f.name],
+		}
+		if base != nil {
+			d.Base = f.get(&base.Fields)
+		}
+		diff = append(diff, d)
+	}
+	return diff, nil
+}
+
+// newerSide chooses the side edited last for every field changed on both
+// sides, the server's if either edit time is unknown
+func (c *Client) newerSide(conflict ConflictItem) map[string]bool {
+	keepLocal := make(map[string]bool)
+	if !c.localNewer(conflict) {
+		return keepLocal
+	}
+	keepLocal[fieldDeleted] = true
+	for _, f := range fieldsOf(conflict.Type) {
+		keepLocal[f.name] = true
+	}
+	return keepLocal
+}
+
+// localNewer reports whether the local side of a conflict was edited after the
+// server side
+func (c *Client) localNewer(conflict ConflictItem) bool {
+	local, err := c.openItem(conflict.ClientData)
+	if err != nil {
+		return false
+	}
+	server, err := c.openItem(conflict.ServerData)
+	if err != nil {
+		return false
+	}
+	l, err := ParseTimestamp(local.UpdatedAt)
+	if err != nil {
+		return false
+	}
+	s, err := ParseTimestamp(server.UpdatedAt)
+	if err != nil {
+		return false
+	}
+	return l.After(s)
+}
@@ -3,12 +3,17 @@ package sync
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
 	"golang.org/x/crypto/pbkdf2"
 )
 
@@ -17,17 +22,113 @@ const (
 	nonceSize        = 12 // GCM standard nonce size
 	saltSize         = 16
 	pbkdf2Iterations = 100000
+
+	// Argon2id defaults (RFC 9106, second recommended option)
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Threads = 4
+)
+
+// ErrDecryptionFailed is returned when data was encrypted with another key or is corrupted
+var ErrDecryptionFailed = errors.New("decryption failed: invalid key or corrupted data")
+
+// KDF identifies the key derivation function of a key
+type KDF uint8
+
+const (
+	KDFNone         KDF = 0 // Random key, not derived from a password
+	KDFPBKDF2SHA256 KDF = 1 // Original derivation, still accepted for old keys
+	KDFArgon2id     KDF = 2 // Default for new keys
 )
 
+// String returns the name of the KDF
+func (k KDF) String() string {
+	switch k {
+	case KDFNone:
+		return "none"
+	case KDFPBKDF2SHA256:
+		return "pbkdf2-sha256"
+	case KDFArgon2id:
+		return "argon2id"
+	default:
+		return fmt.Sprintf("kdf(%d)", uint8(k))
+	}
+}
+
+// KDFParams describes how a key is derived from a password
+type KDFParams struct {
+	KDF        KDF    `json:"kdf"`
+	Iterations uint32 `json:"iterations,omitempty"` // PBKDF2
+	Time       uint32 `json:"time,omitempty"`       // Argon2id passes
+	Memory     uint32 `json:"memory,omitempty"`     // Argon2id memory in KiB
+	Threads    uint8  `json:"threads,omitempty"`    // Argon2id parallelism
+	Salt       []byte `json:"salt"`
+}
+
+// DefaultKDFParams returns the parameters used for new keys
+func DefaultKDFParams(salt []byte) KDFParams {
+	return KDFParams{
+		KDF:     KDFArgon2id,
+		Time:    argon2Time,
+		Memory:  argon2Memory,
+		Threads: argon2Threads,
+		Salt:    salt,
+	}
+}
+
+// LegacyKDFParams returns the fixed PBKDF2 parameters of keys created before
+// the envelope format existed
+func LegacyKDFParams(salt []byte) KDFParams {
+	return KDFParams{
+		KDF:        KDFPBKDF2SHA256,
+		Iterations: pbkdf2Iterations,
+		Salt:       salt,
+	}
+}
+
+// DeriveKey derives the AES-256 key from a password
+func (p KDFParams) DeriveKey(password string) ([]byte, error) {
+	switch p.KDF {
+	case KDFPBKDF2SHA256:
+		if p.Iterations == 0 {
+			return nil, errors.New("invalid pbkdf2 parameters")
+		}
+		return pbkdf2.Key([]byte(password), p.Salt, int(p.Iterations), keySize, sha256.New), nil
+	case KDFArgon2id:
+		if p.Time == 0 || p.Memory == 0 || p.Threads == 0 {
+			return nil, errors.New("invalid argon2id parameters")
+		}
+		return argon2.IDKey([]byte(password), p.Salt, p.Time, p.Memory, p.Threads, keySize), nil
+	default:
+		return nil, fmt.Errorf("unsupported key derivation: %s", p.KDF)
+	}
+}
+
 // Crypto handles encryption/decryption
 type Crypto struct {
-	key []byte
+	key    []byte
+	params KDFParams // How key was derived, recorded in every envelope
+	keyID  [keyIDSize]byte
 }
 
 // NewCrypto creates a crypto instance with derived key from password
-func NewCrypto(password string, salt []byte) *Crypto {
-	key := pbkdf2.Key([]byte(password), salt, pbkdf2Iterations, keySize, sha256.New)
-	return &Crypto{key: key}
+func NewCrypto(password string, params KDFParams) (*Crypto, error) {
+	key, err := params.DeriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	return NewCryptoFromKey(key, params)
+}
+
+// NewCryptoFromKey creates a crypto instance from an already derived key
+func NewCryptoFromKey(key []byte, params KDFParams) (*Crypto, error) {
+	if len(key) != keySize {
+		return nil, errors.New("invalid key size")
+	}
+	c := &Crypto{key: key, params: params}
+	sum := sha256.Sum256(key)
+	copy(c.keyID[:], sum[:keyIDSize])
+	return c, nil
 }
 
 // GenerateSalt generates a random salt
@@ -39,14 +140,41 @@ func GenerateSalt() ([]byte, error) {
 	return salt, nil
 }
 
-// Encrypt encrypts data using AES-256-GCM
-func (c *Crypto) Encrypt(plaintext []byte) (string, error) {
-	block, err := aes.NewCipher(c.key)
-	if err != nil {
-		return "", err
-	}
+// Key returns the raw key, e.g. for caching it in the sync config
+func (c *Crypto) Key() []byte {
+	return c.key
+}
+
+// Params returns the parameters the key was derived with
+func (c *Crypto) Params() KDFParams {
+	return c.params
+}
+
+// KeyID returns the non-secret identifier written into every envelope
+func (c *Crypto) KeyID() string {
+	return hex.EncodeToString(c.keyID[:])
+}
 
-	gcm, err := cipher.NewGCM(block)
+// Fingerprint returns a short, non-secret identifier of the key
+func (c *Crypto) Fingerprint() string {
+	return c.KeyID()
+}
+
+// Pseudonym returns a stable keyed hash of value. It stands in for ids the
+// server must not learn, only holders of the key can link it to value.
+func (c *Crypto) Pseudonym(value string) string {
+	// Separate subkey, the encryption key is never used for anything else
+	macKey := make([]byte, keySize)
+	_, _ = io.ReadFull(hkdf.New(sha256.New, c.key, nil, []byte("irontask pseudonym v1")), macKey)
+
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Encrypt encrypts data using AES-256-GCM and wraps it in a versioned envelope
+func (c *Crypto) Encrypt(plaintext []byte) (string, error) {
+	gcm, err := c.gcm()
 	if err != nil {
 		return "", err
 	}
@@ -56,45 +184,71 @@ func (c *Crypto) Encrypt(plaintext []byte) (string, error) {
 		return "", err
 	}
 
-	// Seal appends nonce + ciphertext
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+	// The header is authenticated, so its parameters cannot be swapped
+	header := encodeEnvelopeHeader(envelopeHeader{
+		Version: envelopeVersion,
+		Params:  c.params,
+		KeyID:   c.keyID,
+	})
+
+	out := append(header, nonce...)
+	out = gcm.Seal(out, nonce, plaintext, header)
+	return base64.StdEncoding.EncodeToString(out), nil
 }
 
-// Decrypt decrypts data using AES-256-GCM
+// Decrypt decrypts an envelope, or a raw nonce||ciphertext blob from before
+// the envelope format existed
 func (c *Crypto) Decrypt(encrypted string) ([]byte, error) {
 	data, err := base64.StdEncoding.DecodeString(encrypted)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(data) < nonceSize {
-		return nil, errors.New("ciphertext too short")
-	}
-
-	block, err := aes.NewCipher(c.key)
+	gcm, err := c.gcm()
 	if err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	header, n, ok, err := parseEnvelopeHeader(data)
 	if err != nil {
+		// Raw legacy ciphertext can start with the magic by chance
+		if len(data) >= nonceSize {
+			if plaintext, legacyErr := gcm.Open(nil, data[:nonceSize], data[nonceSize:], nil); legacyErr == nil {
+				return plaintext, nil
+			}
+		}
 		return nil, err
 	}
+	if ok {
+		if header.KeyID != c.keyID {
+			return nil, fmt.Errorf("%w: encrypted with key %s", ErrUnknownKey, hex.EncodeToString(header.KeyID[:]))
+		}
+		body := data[n:]
+		if len(body) < nonceSize {
+			return nil, errors.New("ciphertext too short")
+		}
+		plaintext, err := gcm.Open(nil, body[:nonceSize], body[nonceSize:], data[:n])
+		if err != nil {
+			return nil, ErrDecryptionFailed
+		}
+		return plaintext, nil
+	}
 
-	nonce := data[:nonceSize]
-	ciphertext := data[nonceSize:]
-
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	// Legacy format: nonce || ciphertext, no header
+	if len(data) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:nonceSize], data[nonceSize:], nil)
 	if err != nil {
-		return nil, errors.New("decryption failed: invalid key or corrupted data")
+		return nil, ErrDecryptionFailed
 	}
-
 	return plaintext, nil
 }
 
-// DeriveKey derives a key from password for display (first 8 chars of base64)
-func DeriveKeyDisplay(password string, salt []byte) string {
-	key := pbkdf2.Key([]byte(password), salt, pbkdf2Iterations, keySize, sha256.New)
-	return base64.StdEncoding.EncodeToString(key)[:16]
+func (c *Crypto) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
@@ -0,0 +1,136 @@
+package sync
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// TestEnvelope checks that envelopes round-trip, that data from before the
+// envelope format stays readable and that envelopes are bound to their header
+// and key
+func TestEnvelope(t *testing.T) {
+	plaintext := []byte(`{"content":"Buy milk"}`)
+	salt := bytes.Repeat([]byte{7}, saltSize)
+
+	newKey := func(t *testing.T, fill byte) *Crypto {
+		t.Helper()
+		crypto, err := NewCryptoFromKey(bytes.Repeat([]byte{fill}, keySize), DefaultKDFParams(salt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return crypto
+	}
+	encrypt := func(t *testing.T, crypto *Crypto) string {
+		t.Helper()
+		blob, err := crypto.Encrypt(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return blob
+	}
+
+	tests := []struct {
+		name string
+		// seal returns a blob and the crypto that opens it
+		seal    func(t *testing.T) (string, *Crypto)
+		wantErr error
+	}{
+		{
+			name: "argon2id round-trip",
+			seal: func(t *testing.T) (string, *Crypto) {
+				sealer, err := NewCrypto("correct horse", DefaultKDFParams(salt))
+				if err != nil {
+					t.Fatal(err)
+				}
+				blob := encrypt(t, sealer)
+
+				// The header records how the key was derived
+				data, _ := base64.StdEncoding.DecodeString(blob)
+				header, _, ok, err := parseEnvelopeHeader(data)
+				if err != nil || !ok {
+					t.Fatalf("no envelope header: ok %v, %v", ok, err)
+				}
+				if header.Params.KDF != KDFArgon2id || header.Params.Memory != argon2Memory || !bytes.Equal(header.Params.Salt, salt) {
+					t.Errorf("header params = %+v", header.Params)
+				}
+
+				opener, err := NewCrypto("correct horse", header.Params)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return blob, opener
+			},
+		},
+		{
+			name: "legacy pbkdf2 raw ciphertext",
+			seal: func(t *testing.T) (string, *Crypto) {
+				// Sealed the way versions before the envelope did
+				key := pbkdf2.Key([]byte("correct horse"), salt, pbkdf2Iterations, keySize, sha256.New)
+				block, err := aes.NewCipher(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				gcm, err := cipher.NewGCM(block)
+				if err != nil {
+					t.Fatal(err)
+				}
+				nonce := make([]byte, nonceSize)
+				if _, err := rand.Read(nonce); err != nil {
+					t.Fatal(err)
+				}
+				blob := base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, nil))
+
+				opener, err := NewCrypto("correct horse", LegacyKDFParams(salt))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return blob, opener
+			},
+		},
+		{
+			name: "tampered header",
+			seal: func(t *testing.T) (string, *Crypto) {
+				crypto := newKey(t, 1)
+				data, _ := base64.StdEncoding.DecodeString(encrypt(t, crypto))
+				_, n, _, _ := parseEnvelopeHeader(data)
+				data[n-keyIDSize-1]++ // Last salt byte, the key id still matches
+				return base64.StdEncoding.EncodeToString(data), crypto
+			},
+			wantErr: ErrDecryptionFailed,
+		},
+		{
+			name: "other key",
+			seal: func(t *testing.T) (string, *Crypto) {
+				return encrypt(t, newKey(t, 1)), newKey(t, 2)
+			},
+			wantErr: ErrUnknownKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blob, crypto := tt.seal(t)
+			got, err := crypto.Decrypt(blob)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Decrypt() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("Decrypt() = %q, want %q", got, plaintext)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package sync
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Envelope layout (all integers big endian):
+//
+//	magic    "ITE"       3 bytes
+//	version  uint8       envelope format version
+//	kdf      uint8       KDF id of the key
+//	plen     uint8       length of the KDF params that follow
+//	params   plen bytes  KDF specific, see encodeKDFParams
+//	key id   8 bytes     first bytes of SHA-256(key)
+//	nonce    12 bytes
+//	ciphertext + GCM tag, the header above is authenticated as additional data
+const (
+	envelopeVersion = 1
+	keyIDSize       = 8
+)
+
+var envelopeMagic = []byte("ITE")
+
+// ErrUnknownKey is returned when an envelope was sealed with a different key
+var ErrUnknownKey = errors.New("data is encrypted with a different key")
+
+// envelopeHeader is the plaintext, authenticated header of an encrypted blob
+type envelopeHeader struct {
+	Version uint8
+	Params  KDFParams
+	KeyID   [keyIDSize]byte
+}
+
+func encodeEnvelopeHeader(h envelopeHeader) []byte {
+	params := encodeKDFParams(h.Params)
+
+	buf := make([]byte, 0, len(envelopeMagic)+3+len(params)+keyIDSize+nonceSize)
+	buf = append(buf, envelopeMagic...)
+	buf = append(buf, h.Version, byte(h.Params.KDF), byte(len(params)))
+	buf = append(buf, params...)
+	buf = append(buf, h.KeyID[:]...)
+	return buf
+}
+
+// parseEnvelopeHeader parses the header at the start of data and returns its length.
+// ok is false for data without the envelope magic, i.e. legacy raw ciphertext.
+func parseEnvelopeHeader(data []byte) (h envelopeHeader, n int, ok bool, err error) {
+	fixed := len(envelopeMagic) + 3
+	if len(data) < fixed || string(data[:len(envelopeMagic)]) != string(envelopeMagic) {
+		return h, 0, false, nil
+	}
+
+	h.Version = data[3]
+	if h.Version != envelopeVersion {
+		return h, 0, true, fmt.Errorf("unsupported envelope version %d, please upgrade irontask", h.Version)
+	}
+	kdf := KDF(data[4])
+	plen := int(data[5])
+	if len(data) < fixed+plen+keyIDSize {
+		return h, 0, true, errors.New("envelope header truncated")
+	}
+
+	params, valid := decodeKDFParams(kdf, data[fixed:fixed+plen])
+	if !valid {
+		return h, 0, true, fmt.Errorf("unsupported key derivation %s in envelope", kdf)
+	}
+	h.Params = params
+	copy(h.KeyID[:], data[fixed+plen:])
+	return h, fixed + plen + keyIDSize, true, nil
+}
+
+// encodeKDFParams serializes the KDF params (without the KDF id):
+//
+//	pbkdf2-sha256: iterations uint32, salt
+//	argon2id:      time uint32, memory uint32, threads uint8, salt
+//	none:          empty
+func encodeKDFParams(p KDFParams) []byte {
+	var buf []byte
+	switch p.KDF {
+	case KDFPBKDF2SHA256:
+		buf = binary.BigEndian.AppendUint32(buf, p.Iterations)
+	case KDFArgon2id:
+		buf = binary.BigEndian.AppendUint32(buf, p.Time)
+		buf = binary.BigEndian.AppendUint32(buf, p.Memory)
+		buf = append(buf, p.Threads)
+	default:
+		return nil
+	}
+	return append(buf, p.Salt...)
+}
+
+func decodeKDFParams(kdf KDF, data []byte) (KDFParams, bool) {
+	p := KDFParams{KDF: kdf}
+	switch kdf {
+	case KDFNone:
+		return p, len(data) == 0
+	case KDFPBKDF2SHA256:
+		if len(data) < 4 {
+			return p, false
+		}
+		p.Iterations = binary.BigEndian.Uint32(data)
+		p.Salt = append([]byte(nil), data[4:]...)
+	case KDFArgon2id:
+		if len(data) < 9 {
+			return p, false
+		}
+		p.Time = binary.BigEndian.Uint32(data)
+		p.Memory = binary.BigEndian.Uint32(data[4:])
+		p.Threads = data[8]
+		p.Salt = append([]byte(nil), data[9:]...)
+	default:
+		return p, false
+	}
+	return p, true
+}
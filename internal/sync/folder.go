@@ -0,0 +1,645 @@
+package sync
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/internal/protocol"
+)
+
+// A sync folder is a plain directory shared between devices by other means,
+// e.g. Syncthing, Dropbox or a network share:
+//
+//	keys.json                        Key material, as stored by the server
+//	changes/<writer>/<seq>.change    Changes, one directory per device
+//
+// Change files are written once and never modified, and each device writes
+// to its own directory, so devices never edit the same file. A change file
+// holds a batch of pushed items encrypted with the account key, or a reset
+// that drops every older change. Changes are ordered by a Lamport clock and
+// then by file name, the latest change of an item wins wherever the files are
+// read. Every item records the change it is based on. A device that reads a
+// change replacing one of its own without being based on it reports a
+// conflict, its change is pushed again and merged with the winner. Every
+// device indexes the files it has read in a state file next to its sync
+// settings.
+
+const (
+	folderKeysFile    = "keys.json"
+	folderChangesDir  = "changes"
+	changeFileExt     = ".change"
+	folderStateFile   = "folder-state.json"
+	changeFileSeqSize = 8 // Digits of the sequence number in file names
+)
+
+// changeFile is the content of a change file
+type changeFile struct {
+	Lamport int64    `json:"lamport"`
+	Reset   bool     `json:"reset,omitempty"` // Drops every older change
+	Data    string   `json:"data,omitempty"`  // Encrypted JSON of the pushed items
+	Bases   []string `json:"bases,omitempty"` // File of the change each item is based on, empty for new items
+}
+
+// changeOrder is the position of a change file in the order all devices agree on
+type changeOrder struct {
+	Lamport int64  `json:"lamport"`
+	File    string `json:"file"` // <writer>/<seq>.change, unique, breaks ties
+}
+
+// after reports whether o is ordered after other
+func (o changeOrder) after(other changeOrder) bool {
+	if o.Lamport != other.Lamport {
+		return o.Lamport > other.Lamport
+	}
+	return o.File > other.File
+}
+
+// folderEntry is the latest change of an item
+type folderEntry struct {
+	changeOrder
+	indexEntry
+	Index int    `json:"index"`          // Position of the item in the change file
+	Base  string `json:"base,omitempty"` // File of the change it is based on
+}
+
+// folderState is a device's index of a sync folder
+type folderState struct {
+	Folder  string                 `json:"folder"`  // Folder the index belongs to
+	Writer  string                 `json:"writer"`  // Directory of this device's change files
+	Version int64                  `json:"version"` // Last local version handed out
+	Lamport int64                  `json:"lamport"` // Highest clock seen
+	Reset   changeOrder            `json:"reset"`   // Latest reset, older changes are dropped
+	Seen    map[string]bool        `json:"seen"`    // Change files read
+	Items   map[string]folderEntry `json:"items"`   // By type and client id
+	// Own changes a concurrent change replaced, by type and client id. Pulls
+	// report them until they started after the change that replaced them.
+	Conflicts map[string]folderEntry `json:"conflicts,omitempty"`
+}
+
+// folderBackend syncs through a shared folder of change files, without any
+// server
+type folderBackend struct {
+	c         *Client
+	dir       string
+	statePath string
+	state     *folderState
+	files     map[string][]SyncItem // Decrypted change files
+}
+
+func newFolderBackend(c *Client, dir string) *folderBackend {
+	return &folderBackend{
+		c:         c,
+		dir:       dir,
+		statePath: filepath.Join(filepath.Dir(c.configPath), folderStateFile),
+		files:     make(map[string][]SyncItem),
+	}
+}
+
+// Push writes the accepted items into one new change file. An item is
+// accepted while the latest change the device knows of is its base version,
+// that change is recorded as its base. Other devices find the file on their
+// next sync, the device id is unused.
+func (b *folderBackend) Push(items []SyncItem, device string) (*SyncPushResponse, error) {
+	if err := b.refresh(); err != nil {
+		return nil, err
+	}
+
+	result := &SyncPushResponse{}
+	var accepted []SyncItem
+	var bases []string
+	for _, item := range items {
+		key := itemKey(item.Type, item.ClientID)
+		delete(b.state.Conflicts, key) // Pushed again, reported by this push
+		current, ok := b.state.Items[key]
+		if !ok || current.Version == item.BaseVersion {
+			accepted = append(accepted, item)
+			bases = append(bases, current.File)
+			continue
+		}
+		stored, err := b.item(current)
+		if err != nil {
+			result.Failed = append(result.Failed, FailedItem{ClientID: item.ClientID, Type: item.Type, Error: err.Error()})
+			continue
+		}
+		result.Conflicts = append(result.Conflicts, protocol.ConflictItem{
+			ClientID:      item.ClientID,
+			Type:          item.Type,
+			ServerVersion: current.Version,
+			ServerData:    stored,
+			ClientData:    item,
+		})
+	}
+	if len(accepted) == 0 {
+		return result, b.save()
+	}
+
+	crypto, err := b.c.getCrypto()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(accepted)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := crypto.Encrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	order, err := b.write(changeFile{Lamport: b.state.Lamport + 1, Data: sealed, Bases: bases})
+	if err != nil {
+		return nil, err
+	}
+	b.files[order.File] = accepted
+
+	for i, item := range accepted {
+		b.state.Version++
+		item.SyncVersion = b.state.Version
+		b.state.Items[itemKey(item.Type, item.ClientID)] = folderEntry{
+			changeOrder: order,
+			indexEntry:  indexEntry{Version: item.SyncVersion, Deleted: item.Deleted},
+			Index:       i,
+			Base:        bases[i],
+		}
+		result.Updated = append(result.Updated, item)
+	}
+	if err := b.save(); err != nil {
+		return nil, err
+	}
+
+	logger.Info("Push to sync folder completed",
+		logger.F("file", order.File),
+		logger.F("updated", len(result.Updated)),
+		logger.F("conflicts", len(result.Conflicts)),
+		logger.F("failed", len(result.Failed)))
+	return result, nil
+}
+
+// Pull returns the latest change of every item changed after since, in
+// version order. Starting from scratch skips deleted items. The first page
+// reports the own changes a concurrent change replaced after since.
+func (b *folderBackend) Pull(since int64, cursor, device string) (*SyncPullResponse, error) {
+	if err := b.refresh(); err != nil {
+		return nil, err
+	}
+	page, err := pullIndex(b.entries(), b.state.Version, since, cursor, b.c.BatchSize(), b.stored)
+	if err != nil {
+		return nil, err
+	}
+	if cursor == "" {
+		if page.Conflicts, err = b.conflicts(since); err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Info("Received items from sync folder",
+		logger.F("itemCount", len(page.Items)),
+		logger.F("conflicts", len(page.Conflicts)),
+		logger.F("syncVersion", page.SyncVersion),
+		logger.F("more", page.NextCursor != ""))
+	return page, nil
+}
+
+// Clear writes a reset, every device drops the changes before it
+func (b *folderBackend) Clear() error {
+	if err := b.refresh(); err != nil {
+		return err
+	}
+	order, err := b.write(changeFile{Lamport: b.state.Lamport + 1, Reset: true})
+	if err != nil {
+		return err
+	}
+	b.state.Reset = order
+	b.state.Items = make(map[string]folderEntry)
+	b.state.Conflicts = make(map[string]folderEntry)
+	return b.save()
+}
+
+// Digest summarizes the live items, with the items of the given buckets
+func (b *folderBackend) Digest(buckets []int) (*SyncDigestResponse, error) {
+	if err := b.refresh(); err != nil {
+		return nil, err
+	}
+	return digestIndex(b.entries(), buckets, b.stored)
+}
+
+// KeyData reads the key material of the folder
+func (b *folderBackend) KeyData() (string, error) {
+	data, err := os.ReadFile(filepath.Join(b.dir, folderKeysFile))
+	if os.IsNotExist(err) {
+		if _, err := os.Stat(b.dir); err != nil {
+			return "", fmt.Errorf("sync folder not available: %w", err)
+		}
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// PutKeyData stores the key material in the folder
+func (b *folderBackend) PutKeyData(data string) error {
+	if _, err := os.Stat(b.dir); err != nil {
+		return fmt.Errorf("sync folder not available: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(b.dir, folderKeysFile), []byte(data))
+}
+
+// refresh loads the index and adds the change files not read yet. Files that
+// cannot be read yet, e.g. while they are still being copied, are read by a
+// later refresh.
+func (b *folderBackend) refresh() error {
+	if err := b.load(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(b.dir); err != nil {
+		return fmt.Errorf("sync folder not available: %w", err)
+	}
+
+	changesDir := filepath.Join(b.dir, folderChangesDir)
+	devices, err := os.ReadDir(changesDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	type newFile struct {
+		order changeOrder
+		file  changeFile
+	}
+	var found []newFile
+	for _, d := range devices {
+		if !d.IsDir() {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(changesDir, d.Name()))
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			name := d.Name() + "/" + f.Name()
+			if !strings.HasSuffix(f.Name(), changeFileExt) || b.state.Seen[name] {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(changesDir, d.Name(), f.Name()))
+			if err != nil {
+				return err
+			}
+			var cf changeFile
+			if err := json.Unmarshal(data, &cf); err != nil {
+				logger.Warn("Skipping unreadable change file", logger.F("file", name), logger.F("error", err))
+				continue
+			}
+			found = append(found, newFile{order: changeOrder{Lamport: cf.Lamport, File: name}, file: cf})
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	// Resets first, they decide which changes are still relevant
+	sort.Slice(found, func(i, j int) bool {
+		return found[j].order.after(found[i].order)
+	})
+	for _, f := range found {
+		b.state.Lamport = max(b.state.Lamport, f.order.Lamport)
+		if f.file.Reset && f.order.after(b.state.Reset) {
+			b.state.Reset = f.order
+		}
+	}
+	for key, e := range b.state.Items {
+		if !e.after(b.state.Reset) {
+			delete(b.state.Items, key)
+			delete(b.state.Conflicts, key)
+		}
+	}
+
+	for _, f := range found {
+		if f.file.Reset || !f.order.after(b.state.Reset) {
+			b.state.Seen[f.order.File] = true
+			continue
+		}
+		items, err := b.open(f.file)
+		if errors.Is(err, ErrUnknownKey) {
+			// Written with a key rotated away, it can never be read
+			logger.Warn("Skipping change file of another key", logger.F("file", f.order.File))
+			b.state.Seen[f.order.File] = true
+			continue
+		}
+		if err != nil {
+			logger.Warn("Skipping unreadable change file", logger.F("file", f.order.File), logger.F("error", err))
+			continue
+		}
+		b.files[f.order.File] = items
+		b.state.Seen[f.order.File] = true
+
+		for i, item := range items {
+			key := itemKey(item.Type, item.ClientID)
+			current, ok := b.state.Items[key]
+			if ok && !f.order.after(current.changeOrder) {
+				continue
+			}
+			// Files of older versions record no bases, their changes win as before
+			base := ""
+			if i < len(f.file.Bases) {
+				base = f.file.Bases[i]
+			}
+			if ok && base != "" && base != current.File && b.own(current) {
+				logger.Info("Concurrent change replaced own change",
+					logger.F("item", key),
+					logger.F("own", current.File),
+					logger.F("file", f.order.File))
+				b.state.Conflicts[key] = current
+			}
+			b.state.Version++
+			b.state.Items[key] = folderEntry{
+				changeOrder: f.order,
+				indexEntry:  indexEntry{Version: b.state.Version, Deleted: item.Deleted},
+				Index:       i,
+				Base:        base,
+			}
+		}
+	}
+
+	logger.Debug("Read sync folder changes", logger.F("files", len(found)), logger.F("version", b.state.Version))
+	return b.save()
+}
+
+// conflicts returns the own changes a concurrent change replaced after since,
+// with the change that replaced them. Those replaced up to since were pulled
+// by then and are dropped.
+func (b *folderBackend) conflicts(since int64) ([]protocol.ConflictItem, error) {
+	var conflicts []protocol.ConflictItem
+	for key, own := range b.state.Conflicts {
+		current, ok := b.state.Items[key]
+		if !ok || current.Version <= since {
+			delete(b.state.Conflicts, key)
+			continue
+		}
+		local, err := b.item(own)
+		if err != nil {
+			return nil, err
+		}
+		stored, err := b.item(current)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, protocol.ConflictItem{
+			ClientID:      local.ClientID,
+			Type:          local.Type,
+			ServerVersion: current.Version,
+			ServerData:    stored,
+			ClientData:    local,
+			BaseData:      b.base(key, own),
+		})
+	}
+	return conflicts, b.save()
+}
+
+// base returns the change an item's change is based on, nil if it has none
+// or it cannot be read
+func (b *folderBackend) base(key string, e folderEntry) *SyncItem {
+	if e.Base == "" {
+		return nil
+	}
+	items, err := b.fileItems(e.Base)
+	if err != nil {
+		logger.Warn("Cannot read base of a conflict", logger.F("file", e.Base), logger.F("error", err))
+		return nil
+	}
+	for _, item := range items {
+		if itemKey(item.Type, item.ClientID) == key {
+			item.ID = item.ClientID
+			item.SyncVersion = 0
+			item.BaseVersion = 0
+			return &item
+		}
+	}
+	return nil
+}
+
+// own reports whether a change was written by this device
+func (b *folderBackend) own(e folderEntry) bool {
+	return strings.HasPrefix(e.File, b.state.Writer+"/")
+}
+
+// item returns the stored change of an item at its local version
+func (b *folderBackend) item(e folderEntry) (SyncItem, error) {
+	items, err := b.fileItems(e.File)
+	if err != nil {
+		return SyncItem{}, err
+	}
+	if e.Index >= len(items) {
+		return SyncItem{}, fmt.Errorf("change file %s has no item %d", e.File, e.Index)
+	}
+
+	item := items[e.Index]
+	item.ID = item.ClientID
+	item.SyncVersion = e.Version
+	item.BaseVersion = 0
+	return item, nil
+}
+
+// fileItems returns the decrypted items of a change file
+func (b *folderBackend) fileItems(file string) ([]SyncItem, error) {
+	if items, ok := b.files[file]; ok {
+		return items, nil
+	}
+	data, err := os.ReadFile(filepath.Join(b.dir, folderChangesDir, filepath.FromSlash(file)))
+	if err != nil {
+		return nil, err
+	}
+	var cf changeFile
+	if err := json.Unmarshal(data, &cf); err != nil {
+		return nil, fmt.Errorf("invalid change file %s: %w", file, err)
+	}
+	items, err := b.open(cf)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt change file %s: %w", file, err)
+	}
+	b.files[file] = items
+	return items, nil
+}
+
+// entries returns the index for pulls and digests
+func (b *folderBackend) entries() []indexedItem {
+	entries := make([]indexedItem, 0, len(b.state.Items))
+	for key, e := range b.state.Items {
+		entries = append(entries, indexedItem{Key: key, indexEntry: e.indexEntry})
+	}
+	return entries
+}
+
+// stored returns the latest change of an indexed item
+func (b *folderBackend) stored(key string) (SyncItem, error) {
+	return b.item(b.state.Items[key])
+}
+
+// open decrypts the items of a change file
+func (b *folderBackend) open(cf changeFile) ([]SyncItem, error) {
+	crypto, err := b.c.getCrypto()
+	if err != nil {
+		return nil, err
+	}
+	data, err := crypto.Decrypt(cf.Data)
+	if err != nil {
+		return nil, err
+	}
+	var items []SyncItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// write stores a new change file of the device under the next free sequence
+// number and returns its order
+func (b *folderBackend) write(cf changeFile) (changeOrder, error) {
+	dir := filepath.Join(b.dir, folderChangesDir, b.state.Writer)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return changeOrder{}, err
+	}
+	data, err := json.Marshal(cf)
+	if err != nil {
+		return changeOrder{}, err
+	}
+
+	// Written under a temporary name first, sync tools never copy half a file
+	tmp, err := tempName(dir)
+	if err != nil {
+		return changeOrder{}, err
+	}
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return changeOrder{}, err
+	}
+	defer func() {
+		_ = os.Remove(tmp)
+	}()
+
+	seq, err := nextSeq(dir)
+	if err != nil {
+		return changeOrder{}, err
+	}
+	for {
+		name := fmt.Sprintf("%0*d%s", changeFileSeqSize, seq, changeFileExt)
+		// Linking fails instead of replacing a file another process wrote
+		err := os.Link(tmp, filepath.Join(dir, name))
+		if os.IsExist(err) {
+			seq++
+			continue
+		}
+		if err != nil {
+			return changeOrder{}, err
+		}
+		order := changeOrder{Lamport: cf.Lamport, File: b.state.Writer + "/" + name}
+		b.state.Lamport = max(b.state.Lamport, cf.Lamport)
+		b.state.Seen[order.File] = true
+		return order, nil
+	}
+}
+
+// load reads the index of the folder, a new one if there is none yet
+func (b *folderBackend) load() error {
+	if b.state != nil {
+		return nil
+	}
+	state := &folderState{}
+	data, err := os.ReadFile(b.statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, state); err != nil {
+			logger.Warn("Invalid sync folder index, reading the folder again", logger.F("error", err))
+			state = &folderState{}
+		}
+	}
+	if state.Folder != b.dir || state.Writer == "" {
+		writer, err := randomHex(8)
+		if err != nil {
+			return err
+		}
+		state = &folderState{Folder: b.dir, Writer: writer}
+	}
+	if state.Seen == nil {
+		state.Seen = make(map[string]bool)
+	}
+	if state.Items == nil {
+		state.Items = make(map[string]folderEntry)
+	}
+	if state.Conflicts == nil {
+		state.Conflicts = make(map[string]folderEntry)
+	}
+	b.state = state
+	return nil
+}
+
+// save stores the index of the folder
+func (b *folderBackend) save() error {
+	data, err := json.Marshal(b.state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(b.statePath, data)
+}
+
+// nextSeq returns the sequence number after the device's last change file
+func nextSeq(dir string) (int, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	next := 1
+	for _, f := range files {
+		seq, err := strconv.Atoi(strings.TrimSuffix(f.Name(), changeFileExt))
+		if err == nil && strings.HasSuffix(f.Name(), changeFileExt) && seq >= next {
+			next = seq + 1
+		}
+	}
+	return next, nil
+}
+
+// tempName returns an unused hidden file name in dir
+func tempName(dir string) (string, error) {
+	name, err := randomHex(8)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, ".tmp-"+name), nil
+}
+
+// randomHex returns n random bytes in hex
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// writeFileAtomic replaces a file, readers see the old or the new content
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := tempName(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
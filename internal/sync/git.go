@@ -0,0 +1,883 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/internal/protocol"
+)
+
+// A sync repository is a git repository, usually a bare one on a private
+// remote or the local filesystem, holding one commit per sync:
+//
+//	keys.json                 Key material, as stored by the server
+//	generation                Id of the latest reset, see Clear
+//	items/<type>/<hash>.json  Latest change of an item, encrypted as a whole
+//
+// Each device works in its own clone next to its sync settings. A sync fetches
+// the remote and replays the device's commits on top of it like
+// `git pull --rebase`. Every item has its own file, so commits of different
+// devices only meet on items both changed: a push based on an outdated item is
+// returned as a conflict and merged like with a server. Items that commits made
+// offline and the repository both changed keep the repository's version, the
+// next pull reports them as conflicts.
+
+const (
+	gitBranch         = "main"
+	gitKeysFile       = "keys.json"
+	gitGenerationFile = "generation"
+	gitItemsDir       = "items"
+	gitWorkDir        = "git-sync"       // Clone of the device, next to the sync settings
+	gitStateFile      = "git-state.json" // Index of the clone
+	gitRemoteBranch   = "refs/remotes/origin/" + gitBranch
+	gitPushAttempts   = 5               // Pushes rejected because another device pushed first
+	gitTimeout        = 2 * time.Minute // Per git command, fetches and pushes may be slow
+)
+
+// errPushRejected is returned when the remote moved on since the last fetch
+var errPushRejected = errors.New("push rejected, the repository changed")
+
+// gitItemFile is the content of an item file
+type gitItemFile struct {
+	Generation string `json:"generation,omitempty"` // Files of older generations were cleared
+	Data       string `json:"data"`                 // Encrypted JSON of the item
+}
+
+// gitEntry is the indexed file of an item
+type gitEntry struct {
+	indexEntry
+	Key  string `json:"key"`
+	Blob string `json:"blob"` // Git object id, a new one is a new change
+}
+
+// gitConflict is an item changed by a commit made offline and by the
+// repository since
+type gitConflict struct {
+	Version int64  `json:"version"`        // Local version of the offline change
+	Data    string `json:"data"`           // Item file of the offline change
+	Base    string `json:"base,omitempty"` // Item file both changes are based on
+}
+
+// gitState is a device's index of its clone
+type gitState struct {
+	Remote     string              `json:"remote"`     // Repository the index belongs to
+	Version    int64               `json:"version"`    // Last local version handed out
+	Generation string              `json:"generation"` // Generation of the indexed items
+	Items      map[string]gitEntry `json:"items"`      // By file path
+	// Offline changes the replay dropped, by file path. Pulls report them
+	// until they started after the repository's change.
+	Conflicts map[string]gitConflict `json:"conflicts,omitempty"`
+}
+
+// gitBackend syncs through a git repository, without any server
+type gitBackend struct {
+	c         *Client
+	remote    string
+	dir       string
+	statePath string
+	state     *gitState
+}
+
+func newGitBackend(c *Client, remote string) *gitBackend {
+	base := filepath.Dir(c.configPath)
+	return &gitBackend{
+		c:         c,
+		remote:    remote,
+		dir:       filepath.Join(base, gitWorkDir),
+		statePath: filepath.Join(base, gitStateFile),
+	}
+}
+
+// Push commits the accepted items and pushes the commit. An item is accepted
+// while its latest change in the repository is its base version. When another
+// device pushed first, the commit is dropped and the items are checked again
+// against its changes. Offline the commit stays in the clone and is pushed by
+// a later sync, the device id is unused.
+func (b *gitBackend) Push(items []SyncItem, device string) (*SyncPushResponse, error) {
+	var result *SyncPushResponse
+	var accepted []SyncItem
+	published, err := b.commit(func() (string, error) {
+		result = &SyncPushResponse{}
+		accepted = nil
+		for _, item := range items {
+			path := gitItemPath(item.Type, item.ClientID)
+			delete(b.state.Conflicts, path) // Pushed again, reported by this push
+			current, ok := b.state.Items[path]
+			if !ok || current.Version == item.BaseVersion {
+				accepted = append(accepted, item)
+				continue
+			}
+			stored, err := b.stored(itemKey(item.Type, item.ClientID))
+			if err != nil {
+				result.Failed = append(result.Failed, FailedItem{ClientID: item.ClientID, Type: item.Type, Error: err.Error()})
+				continue
+			}
+			stored.ID = stored.ClientID
+			stored.SyncVersion = current.Version
+			result.Conflicts = append(result.Conflicts, protocol.ConflictItem{
+				ClientID:      item.ClientID,
+				Type:          item.Type,
+				ServerVersion: current.Version,
+				ServerData:    stored,
+				ClientData:    item,
+			})
+		}
+		if len(accepted) == 1 {
+			return "Push 1 change", b.writeItems(accepted)
+		}
+		return fmt.Sprintf("Push %d changes", len(accepted)), b.writeItems(accepted)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(accepted) == 0 {
+		return result, nil
+	}
+
+	// Index the committed files, so the next sync does not find them again
+	tree, err := b.tree()
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range accepted {
+		path := gitItemPath(item.Type, item.ClientID)
+		b.state.Version++
+		item.SyncVersion = b.state.Version
+		b.state.Items[path] = gitEntry{
+			indexEntry: indexEntry{Version: item.SyncVersion, Deleted: item.Deleted},
+			Key:        itemKey(item.Type, item.ClientID),
+			Blob:       tree[path],
+		}
+		result.Updated = append(result.Updated, item)
+	}
+	if err := b.save(); err != nil {
+		return nil, err
+	}
+
+	logger.Info("Push to sync repository completed",
+		logger.F("published", published),
+		logger.F("updated", len(result.Updated)),
+		logger.F("conflicts", len(result.Conflicts)),
+		logger.F("failed", len(result.Failed)))
+	return result, nil
+}
+
+// Pull fetches the repository and returns the latest change of every item
+// changed after since, in version order. The first page reports the offline
+// changes the repository changed too.
+func (b *gitBackend) Pull(since int64, cursor, device string) (*SyncPullResponse, error) {
+	if cursor == "" {
+		// Later pages are read from the index of the first one
+		if _, err := b.sync(); err != nil {
+			return nil, err
+		}
+	} else if err := b.load(); err != nil {
+		return nil, err
+	}
+
+	page, err := pullIndex(b.entries(), b.state.Version, since, cursor, b.c.BatchSize(), b.stored)
+	if err != nil {
+		return nil, err
+	}
+	if cursor == "" {
+		if page.Conflicts, err = b.conflicts(since); err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Info("Received items from sync repository",
+		logger.F("itemCount", len(page.Items)),
+		logger.F("conflicts", len(page.Conflicts)),
+		logger.F("syncVersion", page.SyncVersion),
+		logger.F("more", page.NextCursor != ""))
+	return page, nil
+}
+
+// Clear removes every item file and starts a new generation, files of older
+// generations that commits made offline bring back are ignored
+func (b *gitBackend) Clear() error {
+	if _, err := b.commit(func() (string, error) {
+		if _, err := b.git("rm", "-r", "-q", "--ignore-unmatch", gitItemsDir); err != nil {
+			return "", err
+		}
+		generation, err := randomHex(8)
+		if err != nil {
+			return "", err
+		}
+		return "Clear all items", writeFileAtomic(filepath.Join(b.dir, gitGenerationFile), []byte(generation+"\n"))
+	}); err != nil {
+		return err
+	}
+	return b.refresh()
+}
+
+// Digest summarizes the live items, with the items of the given buckets
+func (b *gitBackend) Digest(buckets []int) (*SyncDigestResponse, error) {
+	if _, err := b.sync(); err != nil {
+		return nil, err
+	}
+	return digestIndex(b.entries(), buckets, b.stored)
+}
+
+// KeyData reads the key material of the repository
+func (b *gitBackend) KeyData() (string, error) {
+	if _, err := b.sync(); err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(b.dir, gitKeysFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// PutKeyData commits the key material
+func (b *gitBackend) PutKeyData(data string) error {
+	_, err := b.commit(func() (string, error) {
+		return "Store key material", writeFileAtomic(filepath.Join(b.dir, gitKeysFile), []byte(data))
+	})
+	return err
+}
+
+// commit syncs, lets change edit the clone and name the commit, then commits
+// and pushes the changes. If another device pushed first, the commit is dropped and it starts
+// over with that device's changes. It reports whether the commit was pushed,
+// it stays in the clone while the repository cannot be reached.
+func (b *gitBackend) commit(change func() (string, error)) (bool, error) {
+	for attempt := 1; ; attempt++ {
+		online, err := b.sync()
+		if err != nil {
+			return false, err
+		}
+		message, err := change()
+		if err != nil {
+			return false, err
+		}
+		if _, err := b.git("add", "-A"); err != nil {
+			return false, err
+		}
+		status, err := b.git("status", "--porcelain")
+		if err != nil {
+			return false, err
+		}
+		if status == "" {
+			return false, nil
+		}
+		if _, err := b.git("commit", "-q", "-m", message); err != nil {
+			return false, err
+		}
+		if !online {
+			logger.Warn("Sync repository not reachable, the commit is pushed later", logger.F("message", message))
+			return false, nil
+		}
+
+		err = b.publish()
+		if err == nil {
+			return true, nil
+		}
+		// The caller retries whatever was not pushed, it is not replayed
+		if undoErr := b.undo(); undoErr != nil {
+			return false, undoErr
+		}
+		if !errors.Is(err, errPushRejected) || attempt == gitPushAttempts {
+			return false, err
+		}
+		logger.Debug("Push rejected, retrying on top of the new commits", logger.F("attempt", attempt))
+	}
+}
+
+// sync fetches the repository, replays the commits of the clone on top of it,
+// pushes them and indexes the new item files. It reports whether the
+// repository was reachable, offline the clone is indexed as it is.
+func (b *gitBackend) sync() (bool, error) {
+	if err := b.open(); err != nil {
+		return false, err
+	}
+	if err := b.discard(); err != nil {
+		return false, err
+	}
+
+	online := true
+	for attempt := 1; ; attempt++ {
+		if _, err := b.git("fetch", "-q", "origin"); err != nil {
+			logger.Warn("Sync repository not reachable, working offline", logger.F("error", err))
+			online = false
+			break
+		}
+		ahead, err := b.catchUp()
+		if err != nil {
+			return false, err
+		}
+		if ahead == 0 {
+			break
+		}
+
+		err = b.publish()
+		if err == nil {
+			logger.Info("Pushed commits made offline", logger.F("commits", ahead))
+			break
+		}
+		if !errors.Is(err, errPushRejected) || attempt == gitPushAttempts {
+			return false, err
+		}
+	}
+	return online, b.refresh()
+}
+
+// catchUp brings the clone up to date with the fetched repository and returns
+// the number of its commits still to push. Commits the repository does not
+// have yet are replayed on top of it.
+func (b *gitBackend) catchUp() (int, error) {
+	hasRemote := b.hasRef(gitRemoteBranch)
+	hasLocal := b.hasRef("HEAD")
+	switch {
+	case !hasRemote && !hasLocal:
+		return 0, nil
+	case !hasRemote:
+		return b.count("HEAD")
+	case !hasLocal:
+		_, err := b.git("checkout", "-q", "-f", "-B", gitBranch, gitRemoteBranch)
+		return 0, err
+	}
+
+	ahead, err := b.count(gitRemoteBranch + "..HEAD")
+	if err != nil {
+		return 0, err
+	}
+	behind, err := b.count("HEAD.." + gitRemoteBranch)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case behind == 0:
+		return ahead, nil
+	case ahead == 0:
+		_, err := b.git("reset", "-q", "--hard", gitRemoteBranch)
+		return 0, err
+	}
+	return b.replay()
+}
+
+// replay moves the commits of the clone on top of the repository as one
+// commit. The files they changed are written over the repository's, so no
+// merge conflicts are left to resolve, except for item files the repository
+// changed since the merge base too. Those keep the repository's version and
+// the offline change is kept as a conflict.
+func (b *gitBackend) replay() (int, error) {
+	base, err := b.git("merge-base", "HEAD", gitRemoteBranch)
+	if err != nil {
+		base = "" // Unrelated histories, e.g. two devices started the same empty repository
+	}
+
+	commits := "HEAD"
+	if base != "" {
+		commits = base + "..HEAD"
+	}
+	changed, err := b.changedSince(base, "HEAD")
+	if err != nil {
+		return 0, err
+	}
+	theirs, err := b.changedSince(base, gitRemoteBranch)
+	if err != nil {
+		return 0, err
+	}
+	remoteChanged := make(map[string]bool, len(theirs))
+	for _, path := range theirs {
+		remoteChanged[path] = true
+	}
+	messages, err := b.git("log", "--reverse", "--format=%s", commits)
+	if err != nil {
+		return 0, err
+	}
+
+	// Every change is committed, so the work tree holds the replayed files
+	files := make(map[string][]byte)
+	for _, path := range changed {
+		data, err := os.ReadFile(filepath.Join(b.dir, filepath.FromSlash(path)))
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		if err == nil {
+			files[path] = data
+		}
+	}
+
+	// Items changed on both sides, deleted files only come from clears
+	conflicted := make(map[string]bool)
+	for _, path := range changed {
+		data, ok := files[path]
+		if !ok || !remoteChanged[path] || !strings.HasPrefix(path, gitItemsDir+"/") {
+			continue
+		}
+		conflict := gitConflict{Data: string(data)}
+		if e, ok := b.state.Items[path]; ok {
+			conflict.Version = e.Version
+		}
+		if base != "" {
+			if old, err := b.git("show", base+":"+path); err == nil {
+				conflict.Base = old
+			}
+		}
+		b.state.Conflicts[path] = conflict
+		conflicted[path] = true
+	}
+
+	if _, err := b.git("reset", "-q", "--hard", gitRemoteBranch); err != nil {
+		return 0, err
+	}
+	for _, path := range changed {
+		if conflicted[path] {
+			continue
+		}
+		full := filepath.Join(b.dir, filepath.FromSlash(path))
+		data, ok := files[path]
+		if !ok {
+			if err := os.Remove(full); err != nil && !os.IsNotExist(err) {
+				return 0, err
+			}
+			continue
+		}
+		if err := writeFileAtomic(full, data); err != nil {
+			return 0, err
+		}
+	}
+	if len(conflicted) > 0 {
+		logger.Warn("Commits made offline conflict with the repository", logger.F("items", len(conflicted)))
+	}
+	if _, err := b.git("add", "-A"); err != nil {
+		return 0, err
+	}
+	status, err := b.git("status", "--porcelain")
+	if err != nil || status == "" {
+		return 0, err
+	}
+
+	logger.Info("Replaying commits made offline", logger.F("files", len(changed)-len(conflicted)))
+	message := "Replay commits made offline\n\n" + messages
+	if _, err := b.git("commit", "-q", "-m", message); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// changedSince returns the files a revision changed since base, all of its
+// files without a base
+func (b *gitBackend) changedSince(base, rev string) ([]string, error) {
+	if base == "" {
+		out, err := b.git("ls-tree", "-r", "--name-only", rev)
+		return lines(out), err
+	}
+	out, err := b.git("diff", "--name-only", "--no-renames", base, rev)
+	return lines(out), err
+}
+
+// publish pushes the clone to the repository
+func (b *gitBackend) publish() error {
+	_, err := b.git("push", "-q", "origin", "HEAD:refs/heads/"+gitBranch)
+	// Rejected as not fast-forward, or a push of another device won the race
+	// for the branch
+	if err != nil && (strings.Contains(err.Error(), "[rejected]") || strings.Contains(err.Error(), "cannot lock ref")) {
+		return fmt.Errorf("%w: %v", errPushRejected, err)
+	}
+	return err
+}
+
+// undo drops the commits of the clone that were not pushed
+func (b *gitBackend) undo() error {
+	if b.hasRef(gitRemoteBranch) {
+		_, err := b.git("reset", "-q", "--hard", gitRemoteBranch)
+		return err
+	}
+	// The repository was empty, back to an empty clone
+	if _, err := b.git("update-ref", "-d", "HEAD"); err != nil {
+		return err
+	}
+	return b.discard()
+}
+
+// discard drops changes of the work tree that were not committed, e.g. left
+// by a failed push
+func (b *gitBackend) discard() error {
+	if b.hasRef("HEAD") {
+		if _, err := b.git("reset", "-q", "--hard"); err != nil {
+			return err
+		}
+	} else if _, err := b.git("read-tree", "--empty"); err != nil {
+		return err
+	}
+	_, err := b.git("clean", "-q", "-f", "-d")
+	return err
+}
+
+// refresh indexes the item files that are new or changed since the last
+// refresh. A new generation drops every indexed item first.
+func (b *gitBackend) refresh() error {
+	tree, err := b.tree()
+	if err != nil {
+		return err
+	}
+	generation := ""
+	if data, err := os.ReadFile(filepath.Join(b.dir, gitGenerationFile)); err == nil {
+		generation = strings.TrimSpace(string(data))
+	}
+	if generation != b.state.Generation {
+		logger.Info("Sync repository was cleared, reading it again")
+		b.state.Generation = generation
+		b.state.Items = make(map[string]gitEntry)
+		b.state.Conflicts = make(map[string]gitConflict)
+	}
+
+	for path := range b.state.Items {
+		if _, ok := tree[path]; !ok {
+			delete(b.state.Items, path)
+			delete(b.state.Conflicts, path)
+		}
+	}
+	if !b.c.HasEncryptionKey() {
+		logger.Debug("No encryption key yet, item files are indexed later")
+		return b.save()
+	}
+
+	// Sorted, so devices that read the same commits number items alike
+	paths := make([]string, 0, len(tree))
+	for path := range tree {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	changed := 0
+	for _, path := range paths {
+		blob := tree[path]
+		if e, ok := b.state.Items[path]; ok && e.Blob == blob {
+			continue
+		}
+		file, err := b.read(path)
+		if err == nil && file.Generation != generation {
+			delete(b.state.Items, path) // Brought back by a commit made before a clear
+			continue
+		}
+		var item SyncItem
+		if err == nil {
+			item, err = b.decrypt(file)
+		}
+		if err != nil {
+			// Read again by the next refresh, e.g. once the new key is entered
+			logger.Warn("Skipping unreadable item file", logger.F("file", path), logger.F("error", err))
+			continue
+		}
+		b.state.Version++
+		b.state.Items[path] = gitEntry{
+			indexEntry: indexEntry{Version: b.state.Version, Deleted: item.Deleted},
+			Key:        itemKey(item.Type, item.ClientID),
+			Blob:       blob,
+		}
+		changed++
+	}
+
+	logger.Debug("Indexed sync repository", logger.F("changed", changed), logger.F("version", b.state.Version))
+	return b.save()
+}
+
+// conflicts returns the offline changes the repository changed after since too,
+// with the repository's change. Those changed up to since were pulled by then
+// and are dropped.
+func (b *gitBackend) conflicts(since int64) ([]protocol.ConflictItem, error) {
+	var conflicts []protocol.ConflictItem
+	for path, conflict := range b.state.Conflicts {
+		current, ok := b.state.Items[path]
+		if !ok || current.Version <= since {
+			delete(b.state.Conflicts, path)
+			continue
+		}
+		local, err := b.parse(conflict.Data)
+		if err != nil {
+			return nil, err
+		}
+		local.ID = local.ClientID
+		local.SyncVersion = conflict.Version
+		stored, err := b.stored(current.Key)
+		if err != nil {
+			return nil, err
+		}
+		stored.ID = stored.ClientID
+		stored.SyncVersion = current.Version
+
+		item := protocol.ConflictItem{
+			ClientID:      local.ClientID,
+			Type:          local.Type,
+			ServerVersion: current.Version,
+			ServerData:    stored,
+			ClientData:    local,
+		}
+		if conflict.Base != "" {
+			if base, err := b.parse(conflict.Base); err == nil {
+				base.ID = base.ClientID
+				item.BaseData = &base
+			}
+		}
+		conflicts = append(conflicts, item)
+	}
+	return conflicts, b.save()
+}
+
+// tree returns the object id of every item file of the clone's last commit,
+// by path
+func (b *gitBackend) tree() (map[string]string, error) {
+	tree := make(map[string]string)
+	if !b.hasRef("HEAD") {
+		return tree, nil
+	}
+	out, err := b.git("ls-tree", "-r", "HEAD", gitItemsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines(out) {
+		// <mode> blob <object id>\t<path>
+		info, path, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		tree[path] = fields[2]
+	}
+	return tree, nil
+}
+
+// writeItems writes the files of pushed items in the current generation
+func (b *gitBackend) writeItems(items []SyncItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	crypto, err := b.c.getCrypto()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		item.SyncVersion = 0
+		item.BaseVersion = 0
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		sealed, err := crypto.Encrypt(data)
+		if err != nil {
+			return err
+		}
+		// One line, so concurrent changes never merge into a broken file
+		file, err := json.Marshal(gitItemFile{Generation: b.state.Generation, Data: sealed})
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(b.dir, filepath.FromSlash(gitItemPath(item.Type, item.ClientID)))
+		if err := writeFileAtomic(path, append(file, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read reads the item file at path
+func (b *gitBackend) read(path string) (gitItemFile, error) {
+	var file gitItemFile
+	data, err := os.ReadFile(filepath.Join(b.dir, filepath.FromSlash(path)))
+	if err != nil {
+		return file, err
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return file, fmt.Errorf("invalid item file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// parse returns the item of the content of an item file
+func (b *gitBackend) parse(data string) (SyncItem, error) {
+	var file gitItemFile
+	if err := json.Unmarshal([]byte(data), &file); err != nil {
+		return SyncItem{}, fmt.Errorf("invalid item file: %w", err)
+	}
+	return b.decrypt(file)
+}
+
+// decrypt returns the item of an item file
+func (b *gitBackend) decrypt(file gitItemFile) (SyncItem, error) {
+	crypto, err := b.c.getCrypto()
+	if err != nil {
+		return SyncItem{}, err
+	}
+	data, err := crypto.Decrypt(file.Data)
+	if err != nil {
+		return SyncItem{}, err
+	}
+	var item SyncItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return SyncItem{}, fmt.Errorf("invalid item: %w", err)
+	}
+	return item, nil
+}
+
+// entries returns the index for pulls and digests
+func (b *gitBackend) entries() []indexedItem {
+	entries := make([]indexedItem, 0, len(b.state.Items))
+	for _, e := range b.state.Items {
+		entries = append(entries, indexedItem{Key: e.Key, indexEntry: e.indexEntry})
+	}
+	return entries
+}
+
+// stored returns the latest change of an indexed item
+func (b *gitBackend) stored(key string) (SyncItem, error) {
+	itemType, clientID, _ := strings.Cut(key, ":")
+	file, err := b.read(gitItemPath(itemType, clientID))
+	if err != nil {
+		return SyncItem{}, err
+	}
+	return b.decrypt(file)
+}
+
+// open loads the index and creates the clone of the repository, a new one if
+// the repository changed
+func (b *gitBackend) open() error {
+	if err := b.load(); err != nil {
+		return err
+	}
+	if b.state.Remote != b.remote {
+		if err := os.RemoveAll(b.dir); err != nil {
+			return err
+		}
+		b.state = &gitState{Remote: b.remote, Items: make(map[string]gitEntry), Conflicts: make(map[string]gitConflict)}
+	}
+	if _, err := os.Stat(filepath.Join(b.dir, ".git")); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(b.dir, 0700); err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	for _, args := range [][]string{
+		{"init", "-q", "-b", gitBranch},
+		{"config", "user.name", strings.TrimSpace("IronTask " + host)},
+		{"config", "user.email", "irontask@" + host},
+		{"config", "commit.gpgsign", "false"},
+		{"remote", "add", "origin", b.remote},
+	} {
+		if _, err := b.git(args...); err != nil {
+			return err
+		}
+	}
+	return b.save()
+}
+
+// load reads the index of the clone, a new one if there is none yet
+func (b *gitBackend) load() error {
+	if b.state != nil {
+		return nil
+	}
+	state := &gitState{}
+	data, err := os.ReadFile(b.statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, state); err != nil {
+			logger.Warn("Invalid sync repository index, reading the repository again", logger.F("error", err))
+			state = &gitState{Remote: state.Remote}
+		}
+	}
+	if state.Items == nil {
+		state.Items = make(map[string]gitEntry)
+	}
+	if state.Conflicts == nil {
+		state.Conflicts = make(map[string]gitConflict)
+	}
+	b.state = state
+	return nil
+}
+
+// save stores the index of the clone
+func (b *gitBackend) save() error {
+	data, err := json.Marshal(b.state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(b.statePath, data)
+}
+
+// hasRef reports whether a ref of the clone points to a commit
+func (b *gitBackend) hasRef(ref string) bool {
+	_, err := b.git("rev-parse", "-q", "--verify", ref+"^{commit}")
+	return err == nil
+}
+
+// count returns the number of commits in a revision range
+func (b *gitBackend) count(revisions string) (int, error) {
+	out, err := b.git("rev-list", "--count", revisions)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(out)
+}
+
+// git runs a git command in the clone and returns its trimmed output
+func (b *gitBackend) git(args ...string) (string, error) {
+	return runGit(b.dir, args...)
+}
+
+// runGit runs a git command in dir and returns its trimmed output, errors
+// carry what git printed
+func runGit(dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	// Never wait for a password prompt, and keep messages parseable
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "LC_ALL=C")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	logger.Debug("Running git", logger.F("args", strings.Join(args, " ")))
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", fmt.Errorf("git is not installed: %w", err)
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// gitItemPath returns the path of an item's file, ids are hashed so any id
+// makes a valid file name
+func gitItemPath(itemType, clientID string) string {
+	sum := sha256.Sum256([]byte(itemKey(itemType, clientID)))
+	return gitItemsDir + "/" + itemType + "/" + hex.EncodeToString(sum[:]) + ".json"
+}
+
+// lines splits command output into lines
+func lines(out string) []string {
+	if out == "" {
+		return nil
+	}
+	return strings.Split(out, "\n")
+}
@@ -0,0 +1,178 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/existflow/irontask/internal/database"
+	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/logger"
+)
+
+// SyncTrigger names what started a sync, recorded in the sync history
+type SyncTrigger string
+
+const (
+	TriggerManual    SyncTrigger = "manual"     // irontask sync
+	TriggerAuto      SyncTrigger = "auto"       // AutoSync in the TUI or the daemon
+	TriggerCLI       SyncTrigger = "cli"        // --sync or the periodic sync of CLI commands
+	TriggerResolve   SyncTrigger = "resolve"    // Pushing resolved conflicts
+	TriggerRepair    SyncTrigger = "repair"     // sync verify --repair
+	TriggerKeyRotate SyncTrigger = "key-rotate" // Sync before rotating the key
+	TriggerOpaque    SyncTrigger = "opaque"     // Sync before switching opaque mode
+)
+
+// maxSyncRuns is the number of runs kept in the history
+const maxSyncRuns = 5000
+
+// runTimeLayout formats run times in UTC with a fixed width, so they sort as
+// strings
+const runTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// String returns the name of a mode in the sync history
+func (m SyncMode) String() string {
+	switch m {
+	case SyncModeRemoteToLocal:
+		return "pull"
+	case SyncModeLocalToRemote:
+		return "push"
+	case SyncModeRebuildLocal:
+		return "rebuild"
+	case SyncModeReseed:
+		return "reseed"
+	}
+	return "merge"
+}
+
+// SyncRun is one recorded sync
+type SyncRun struct {
+	ID          int64      `json:"id"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"` // Unset while running, or if the run was interrupted
+	Trigger     string     `json:"trigger"`
+	Mode        string     `json:"mode"`
+	Pushed      int64      `json:"pushed"`
+	Pulled      int64      `json:"pulled"`
+	Conflicts   int64      `json:"conflicts"`
+	Failed      int64      `json:"failed"`
+	Error       string     `json:"error,omitempty"`
+	FromVersion int64      `json:"from_version"` // Server version synced up to before the run
+	ToVersion   int64      `json:"to_version"`   // and after it
+}
+
+// Changed reports whether the run pushed or pulled anything
+func (r SyncRun) Changed() bool {
+	return r.Pushed > 0 || r.Pulled > 0
+}
+
+// SyncRunFilter selects runs from the sync history
+type SyncRunFilter struct {
+	Since   time.Time   // Runs started at or after, all if zero
+	Trigger SyncTrigger // Any if empty
+	Mode    string      // Any if empty
+	Errors  bool        // Only failed or interrupted runs
+	Changes bool        // Only runs that pushed or pulled something
+	Limit   int         // Most recent runs, all if 0
+}
+
+// SyncRuns returns the recorded syncs matching a filter, newest first
+func SyncRuns(dbConn *db.DB, filter SyncRunFilter) ([]SyncRun, error) {
+	since := ""
+	if !filter.Since.IsZero() {
+		since = filter.Since.UTC().Format(runTimeLayout)
+	}
+	rows, err := dbConn.ListSyncRuns(context.Background(), since)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []SyncRun
+	for _, row := range rows {
+		run := syncRun(row)
+		switch {
+		case filter.Trigger != "" && run.Trigger != string(filter.Trigger):
+			continue
+		case filter.Mode != "" && run.Mode != filter.Mode:
+			continue
+		case filter.Errors && run.Error == "" && run.FinishedAt != nil:
+			continue
+		case filter.Changes && !run.Changed():
+			continue
+		}
+		runs = append(runs, run)
+		if filter.Limit > 0 && len(runs) == filter.Limit {
+			break
+		}
+	}
+	return runs, nil
+}
+
+// syncRun converts a row of the history
+func syncRun(row database.SyncRun) SyncRun {
+	run := SyncRun{
+		ID:          row.ID,
+		Trigger:     row.Source,
+		Mode:        row.Mode,
+		Pushed:      row.Pushed,
+		Pulled:      row.Pulled,
+		Conflicts:   row.Conflicts,
+		Failed:      row.Failed,
+		Error:       row.Error.String,
+		FromVersion: row.FromVersion,
+		ToVersion:   row.ToVersion,
+	}
+	run.StartedAt, _ = time.Parse(runTimeLayout, row.StartedAt)
+	if row.FinishedAt.Valid {
+		if t, err := time.Parse(runTimeLayout, row.FinishedAt.String); err == nil {
+			run.FinishedAt = &t
+		}
+	}
+	return run
+}
+
+// startRun records the start of a sync and returns its id, 0 if it could not
+// be recorded. Recording never fails the sync.
+func (c *Client) startRun(dbConn *db.DB, mode SyncMode, trigger SyncTrigger) int64 {
+	id, err := dbConn.StartSyncRun(context.Background(), database.StartSyncRunParams{
+		StartedAt:   time.Now().UTC().Format(runTimeLayout),
+		Source:      string(trigger),
+		Mode:        mode.String(),
+		FromVersion: c.config.LastSync,
+	})
+	if err != nil {
+		logger.Warn("Failed to record sync run", logger.F("error", err))
+		return 0
+	}
+	return id
+}
+
+// finishRun records the outcome of a sync and drops the oldest runs
+func (c *Client) finishRun(dbConn *db.DB, id int64, result *SyncResult, syncErr error) {
+	if id == 0 {
+		return
+	}
+	ctx := context.Background()
+	params := database.FinishSyncRunParams{
+		FinishedAt: sql.NullString{String: time.Now().UTC().Format(runTimeLayout), Valid: true},
+		ToVersion:  c.config.LastSync,
+		ID:         id,
+	}
+	if result != nil {
+		params.Pushed = int64(result.Pushed)
+		params.Pulled = int64(result.Pulled)
+		params.Conflicts = int64(len(result.Conflicts))
+		params.Failed = int64(len(result.Failed))
+	}
+	if syncErr != nil {
+		params.Error = sql.NullString{String: strings.TrimSpace(syncErr.Error()), Valid: true}
+	}
+	if err := dbConn.FinishSyncRun(ctx, params); err != nil {
+		logger.Warn("Failed to record sync run", logger.F("error", err))
+		return
+	}
+	if err := dbConn.PruneSyncRuns(ctx, maxSyncRuns); err != nil {
+		logger.Warn("Failed to prune sync history", logger.F("error", err))
+	}
+}
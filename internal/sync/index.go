@@ -0,0 +1,119 @@
+package sync
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/existflow/irontask/internal/digest"
+)
+
+// Backends without a server keep an index of the items they have read on the
+// device. Items are numbered in the order the device finds their changes,
+// these numbers are the sync versions the device pulls by and bases its
+// changes on.
+
+// liveCursor marks cursors of pulls that skip deleted items
+const liveCursor = "live."
+
+// indexEntry is the latest known change of an item
+type indexEntry struct {
+	Version int64 `json:"version"` // Local sync version
+	Deleted bool  `json:"deleted"`
+}
+
+// indexedItem is an entry of the index with its item key, type:client id
+type indexedItem struct {
+	Key string
+	indexEntry
+}
+
+// pullIndex returns the page of indexed items changed after since, in version
+// order. Starting from scratch skips deleted items. load reads the stored
+// change of an item.
+func pullIndex(entries []indexedItem, version, since int64, cursor string, limit int, load func(key string) (SyncItem, error)) (*SyncPullResponse, error) {
+	if cursor == "" && since > version {
+		// The index was lost or belongs to another backend, versions start over
+		return nil, errResyncRequired
+	}
+
+	after := since
+	live := since == 0
+	if cursor != "" {
+		v, isLive := strings.CutPrefix(cursor, liveCursor)
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor %q", cursor)
+		}
+		after, live = n, isLive
+	}
+
+	var changed []indexedItem
+	for _, e := range entries {
+		if e.Version > after && !(live && e.Deleted) {
+			changed = append(changed, e)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		return changed[i].Version < changed[j].Version
+	})
+
+	page := &SyncPullResponse{SyncVersion: version}
+	if len(changed) > limit {
+		changed = changed[:limit]
+		page.SyncVersion = changed[limit-1].Version
+		page.NextCursor = strconv.FormatInt(page.SyncVersion, 10)
+		if live {
+			page.NextCursor = liveCursor + page.NextCursor
+		}
+	}
+	for _, e := range changed {
+		item, err := load(e.Key)
+		if err != nil {
+			return nil, err
+		}
+		item.ID = item.ClientID
+		item.SyncVersion = e.Version
+		item.BaseVersion = 0
+		page.Items = append(page.Items, item)
+	}
+	return page, nil
+}
+
+// digestIndex summarizes the live indexed items, with the items of the given
+// buckets
+func digestIndex(entries []indexedItem, buckets []int, load func(key string) (SyncItem, error)) (*SyncDigestResponse, error) {
+	wanted := make(map[int]bool)
+	for _, bucket := range buckets {
+		wanted[bucket] = true
+	}
+
+	resp := &SyncDigestResponse{}
+	var live []digest.Entry
+	for _, e := range entries {
+		if e.Deleted {
+			continue
+		}
+		itemType, clientID, _ := strings.Cut(e.Key, ":")
+		live = append(live, digest.Entry{Type: itemType, ClientID: clientID, SyncVersion: e.Version})
+		if wanted[digest.Bucket(itemType, clientID)] {
+			item, err := load(e.Key)
+			if err != nil {
+				return nil, err
+			}
+			item.ID = item.ClientID
+			item.SyncVersion = e.Version
+			item.BaseVersion = 0
+			resp.Items = append(resp.Items, item)
+		}
+	}
+	resp.Count = len(live)
+	resp.Buckets = digest.Sum(live)
+	return resp, nil
+}
+
+// itemKey returns the index key of an item
+func itemKey(itemType, clientID string) string {
+	return itemType + ":" + clientID
+}
@@ -0,0 +1,464 @@
+package sync
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/logger"
+)
+
+// ErrNoEncryptionKey is returned when sync needs to encrypt or decrypt data
+// but this device has no encryption key configured
+var ErrNoEncryptionKey = errors.New("no encryption key configured on this device, run 'irontask sync key' first")
+
+// ErrWrongPassword is returned when the encryption password does not match
+// the key set up on another device
+var ErrWrongPassword = errors.New("wrong encryption password")
+
+// ErrKeyRotated is returned when another device rotated the account's key and
+// the key cached on this device is no longer current
+var ErrKeyRotated = errors.New("encryption key was rotated on another device, run 'irontask sync key' and enter the new password")
+
+// ErrNoRecoveryPhrase is returned when recovering an account whose key
+// predates recovery phrases
+var ErrNoRecoveryPhrase = errors.New("this account has no recovery phrase, run 'irontask sync key rotate' on a device with the key to create one")
+
+// keyCheckPlaintext is encrypted with the key and stored with the key material
+// of accounts from before master keys, so devices can verify a password
+const keyCheckPlaintext = "irontask-key-check"
+
+// masterKeyParams marks the random master key in envelopes, it is not derived
+// from anything
+var masterKeyParams = KDFParams{KDF: KDFNone}
+
+// keyMaterial is the non-secret key setup shared by all devices of a user.
+// The server stores it as an opaque string.
+//
+// Data is encrypted with a random master key. The master key is stored twice,
+// wrapped by a key derived from the password and by a key derived from the
+// recovery phrase. Material without WrappedKey predates master keys, there the
+// password derived key encrypts data directly.
+type keyMaterial struct {
+	Params      *KDFParams `json:"params,omitempty"`       // Derivation of the password key, nil for the oldest keys
+	Salt        string     `json:"salt,omitempty"`         // Base64 encoded PBKDF2 salt of keys without Params
+	KeyID       string     `json:"key_id,omitempty"`       // Id of the current data key, changes on rotation
+	WrappedKey  string     `json:"wrapped_key,omitempty"`  // Master key encrypted with the password key
+	RecoveryKey string     `json:"recovery_key,omitempty"` // Master key encrypted with the recovery phrase key
+	Check       string     `json:"check,omitempty"`        // keyCheckPlaintext encrypted with the derived key
+}
+
+// kdfParams returns how the password key is derived. Material without params
+// predates the envelope format and always used PBKDF2.
+func (km *keyMaterial) kdfParams() (KDFParams, error) {
+	if km.Params != nil {
+		return *km.Params, nil
+	}
+	salt, err := base64.StdEncoding.DecodeString(km.Salt)
+	if err != nil {
+		return KDFParams{}, fmt.Errorf("invalid key material: %w", err)
+	}
+	return LegacyKDFParams(salt), nil
+}
+
+// unlock returns the data key for a password
+func (km *keyMaterial) unlock(password string) (*Crypto, error) {
+	params, err := km.kdfParams()
+	if err != nil {
+		return nil, err
+	}
+	kek, err := NewCrypto(password, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if km.WrappedKey == "" {
+		check, err := kek.Decrypt(km.Check)
+		if err != nil || string(check) != keyCheckPlaintext {
+			return nil, ErrWrongPassword
+		}
+		return kek, nil
+	}
+
+	master, err := kek.Decrypt(km.WrappedKey)
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	return km.masterCrypto(master)
+}
+
+// recover returns the master key for a recovery phrase secret
+func (km *keyMaterial) recover(entropy []byte) (*Crypto, error) {
+	if km.RecoveryKey == "" {
+		return nil, ErrNoRecoveryPhrase
+	}
+	kek, err := recoveryCrypto(entropy)
+	if err != nil {
+		return nil, err
+	}
+	master, err := kek.Decrypt(km.RecoveryKey)
+	if err != nil {
+		return nil, ErrInvalidRecoveryPhrase
+	}
+	return km.masterCrypto(master)
+}
+
+func (km *keyMaterial) masterCrypto(master []byte) (*Crypto, error) {
+	crypto, err := NewCryptoFromKey(master, masterKeyParams)
+	if err != nil {
+		return nil, err
+	}
+	if km.KeyID != "" && crypto.KeyID() != km.KeyID {
+		return nil, fmt.Errorf("invalid key material: key id mismatch")
+	}
+	return crypto, nil
+}
+
+// wrap stores the master key encrypted with a key derived from a new password
+func (km *keyMaterial) wrap(password string, master *Crypto) error {
+	salt, err := GenerateSalt()
+	if err != nil {
+		return err
+	}
+	params := DefaultKDFParams(salt)
+	kek, err := NewCrypto(password, params)
+	if err != nil {
+		return err
+	}
+	wrapped, err := kek.Encrypt(master.Key())
+	if err != nil {
+		return err
+	}
+
+	km.Params = &params
+	km.Salt = ""
+	km.WrappedKey = wrapped
+	km.Check = ""
+	return nil
+}
+
+// newKeyMaterial generates a random master key wrapped by password and by a
+// new recovery phrase, which is returned for the user to write down
+func newKeyMaterial(password string) (*Crypto, *keyMaterial, string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, "", err
+	}
+	master, err := NewCryptoFromKey(key, masterKeyParams)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	km := &keyMaterial{KeyID: master.KeyID()}
+	if err := km.wrap(password, master); err != nil {
+		return nil, nil, "", err
+	}
+
+	entropy, err := newRecoveryEntropy()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	rkek, err := recoveryCrypto(entropy)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if km.RecoveryKey, err = rkek.Encrypt(master.Key()); err != nil {
+		return nil, nil, "", err
+	}
+
+	return master, km, encodeRecoveryPhrase(entropy), nil
+}
+
+// HasEncryptionKey returns true if this device has a cached encryption key
+func (c *Client) HasEncryptionKey() bool {
+	_, err := c.getCrypto()
+	return err == nil
+}
+
+// KeyFingerprint returns a short identifier of the configured key, or "" if none
+func (c *Client) KeyFingerprint() string {
+	crypto, err := c.getCrypto()
+	if err != nil {
+		return ""
+	}
+	return crypto.Fingerprint()
+}
+
+// RemoteKeyExists returns true if another device already set up encryption for this account
+func (c *Client) RemoteKeyExists() (bool, error) {
+	km, err := c.fetchKeyMaterial()
+	if err != nil {
+		return false, err
+	}
+	return km != nil, nil
+}
+
+// CreateEncryptionKey sets up encryption for the account with a new random
+// master key protected by password. It returns the recovery phrase, which is
+// not stored anywhere and must be shown to the user.
+func (c *Client) CreateEncryptionKey(password string) (string, error) {
+	crypto, km, phrase, err := newKeyMaterial(password)
+	if err != nil {
+		return "", err
+	}
+	if err := c.uploadKeyMaterial(km); err != nil {
+		return "", err
+	}
+
+	logger.Info("Encryption key created", logger.F("fingerprint", crypto.Fingerprint()))
+	return phrase, c.cacheEncryptionKey(crypto)
+}
+
+// UnlockEncryptionKey unwraps the account's key with the password on this device
+func (c *Client) UnlockEncryptionKey(password string) error {
+	km, err := c.fetchKeyMaterial()
+	if err != nil {
+		return err
+	}
+	if km == nil {
+		return fmt.Errorf("no encryption key set up for this account yet")
+	}
+
+	crypto, err := km.unlock(password)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Encryption key unlocked", logger.F("fingerprint", crypto.Fingerprint()))
+	return c.cacheEncryptionKey(crypto)
+}
+
+// RecoverEncryptionKey unwraps the account's key with the recovery phrase and
+// protects it with a new password. Other devices keep working unchanged.
+func (c *Client) RecoverEncryptionKey(phrase, newPassword string) error {
+	entropy, err := decodeRecoveryPhrase(phrase)
+	if err != nil {
+		return err
+	}
+
+	km, err := c.fetchKeyMaterial()
+	if err != nil {
+		return err
+	}
+	if km == nil {
+		return fmt.Errorf("no encryption key set up for this account yet")
+	}
+
+	crypto, err := km.recover(entropy)
+	if err != nil {
+		return err
+	}
+	if err := km.wrap(newPassword, crypto); err != nil {
+		return err
+	}
+	if err := c.uploadKeyMaterial(km); err != nil {
+		return err
+	}
+
+	logger.Info("Encryption key recovered", logger.F("fingerprint", crypto.Fingerprint()))
+	return c.cacheEncryptionKey(crypto)
+}
+
+// ChangeEncryptionPassword re-wraps the master key with a new password.
+// Data stays encrypted with the same key, so nothing is re-uploaded.
+func (c *Client) ChangeEncryptionPassword(oldPassword, newPassword string) error {
+	km, err := c.fetchKeyMaterial()
+	if err != nil {
+		return err
+	}
+	if km == nil {
+		return fmt.Errorf("no encryption key set up for this account yet")
+	}
+	if km.WrappedKey == "" {
+		return fmt.Errorf("this account's key is derived from the password, run 'irontask sync key rotate' instead")
+	}
+
+	crypto, err := km.unlock(oldPassword)
+	if err != nil {
+		return err
+	}
+	if err := km.wrap(newPassword, crypto); err != nil {
+		return err
+	}
+	if err := c.uploadKeyMaterial(km); err != nil {
+		return err
+	}
+
+	logger.Info("Encryption password changed", logger.F("fingerprint", crypto.Fingerprint()))
+	return c.cacheEncryptionKey(crypto)
+}
+
+// RotateEncryptionKey replaces the account's master key with a new one protected
+// by a new password and recovery phrase. Local data is first synced with the old
+// key, then every project and task is re-encrypted with the new key and pushed.
+func (c *Client) RotateEncryptionKey(dbConn *db.DB, password string) (*SyncResult, string, error) {
+	oldCrypto, err := c.getCrypto()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Pull what other devices pushed with the old key while we can still read it
+	if _, err := c.SyncWithTrigger(dbConn, SyncModeMerge, TriggerKeyRotate); err != nil {
+		return nil, "", fmt.Errorf("sync before rotation failed: %w", err)
+	}
+
+	crypto, km, phrase, err := newKeyMaterial(password)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Mark everything dirty before switching keys, so an interrupted rotation
+	// is completed by the next sync on this device
+	if err := markAllDirty(dbConn); err != nil {
+		return nil, "", err
+	}
+	if c.config.Opaque || !c.UsesServer() {
+		// Pseudonymous ids are derived from the key and change with it, and
+		// the files of folders and repositories are encrypted as a whole with
+		// the old key
+		c.config.ReplaceRemote = true
+	}
+
+	if err := c.uploadKeyMaterial(km); err != nil {
+		return nil, "", err
+	}
+	if err := c.cacheEncryptionKey(crypto); err != nil {
+		return nil, "", err
+	}
+
+	logger.Info("Encryption key rotated",
+		logger.F("old", oldCrypto.KeyID()),
+		logger.F("new", crypto.KeyID()))
+
+	if err := c.replaceRemote(); err != nil {
+		return nil, phrase, fmt.Errorf("re-encrypted push failed, run 'irontask sync' to retry: %w", err)
+	}
+	result, err := c.pushChanges(dbConn)
+	if err != nil {
+		return nil, phrase, fmt.Errorf("re-encrypted push failed, run 'irontask sync' to retry: %w", err)
+	}
+	return result, phrase, nil
+}
+
+// checkRemoteKey verifies the cached key is still the account's current key.
+// A key rotated on another device drops the cached key, so 'sync key' asks
+// for the new password instead of failing to decrypt.
+func (c *Client) checkRemoteKey() error {
+	crypto, err := c.getCrypto()
+	if err != nil {
+		return err
+	}
+
+	km, err := c.fetchKeyMaterial()
+	if err != nil {
+		return err
+	}
+	if km == nil || km.KeyID == "" {
+		// Key material from before rotation existed carries no id
+		return nil
+	}
+
+	if km.KeyID != crypto.KeyID() {
+		logger.Info("Encryption key was rotated on another device",
+			logger.F("cached", crypto.KeyID()),
+			logger.F("current", km.KeyID))
+		c.crypto = nil
+		c.config.EncryptionKey = ""
+		c.config.KeyParams = nil
+		c.config.KeyID = ""
+		_ = c.saveConfig()
+		return ErrKeyRotated
+	}
+
+	if c.config.KeyID != km.KeyID {
+		c.config.KeyID = km.KeyID
+		return c.saveConfig()
+	}
+	return nil
+}
+
+// getCrypto returns the crypto for the cached key, building it once
+func (c *Client) getCrypto() (*Crypto, error) {
+	if c.crypto != nil {
+		return c.crypto, nil
+	}
+	if c.config.EncryptionKey == "" {
+		return nil, ErrNoEncryptionKey
+	}
+
+	key, err := base64.StdEncoding.DecodeString(c.config.EncryptionKey)
+	if err != nil {
+		return nil, ErrNoEncryptionKey
+	}
+
+	var params KDFParams
+	if c.config.KeyParams != nil {
+		params = *c.config.KeyParams
+	} else {
+		// Cached before envelopes existed, the key was derived with PBKDF2
+		salt, _ := base64.StdEncoding.DecodeString(c.config.Salt)
+		params = LegacyKDFParams(salt)
+	}
+
+	crypto, err := NewCryptoFromKey(key, params)
+	if err != nil {
+		// Older versions stored a 16 character display key here
+		return nil, ErrNoEncryptionKey
+	}
+
+	c.crypto = crypto
+	return crypto, nil
+}
+
+func (c *Client) cacheEncryptionKey(crypto *Crypto) error {
+	params := crypto.Params()
+	c.crypto = crypto
+	c.config.EncryptionKey = base64.StdEncoding.EncodeToString(crypto.Key())
+	c.config.KeyParams = &params
+	c.config.KeyID = crypto.KeyID()
+	c.config.Salt = ""
+	return c.saveConfig()
+}
+
+func (c *Client) clearEncryptionKey() {
+	c.crypto = nil
+	c.config.EncryptionKey = ""
+	c.config.KeyParams = nil
+	c.config.KeyID = ""
+	c.config.Salt = ""
+	c.config.BlobsMigrated = false
+}
+
+// fetchKeyMaterial gets the key material from the backend, nil if none exists yet
+func (c *Client) fetchKeyMaterial() (*keyMaterial, error) {
+	if !c.IsLoggedIn() {
+		return nil, fmt.Errorf("not logged in")
+	}
+
+	keyData, err := c.backend().KeyData()
+	if err != nil || keyData == "" {
+		return nil, err
+	}
+
+	var km keyMaterial
+	if err := json.Unmarshal([]byte(keyData), &km); err != nil {
+		return nil, fmt.Errorf("invalid key material: %w", err)
+	}
+	return &km, nil
+}
+
+// uploadKeyMaterial stores the key material on the backend
+func (c *Client) uploadKeyMaterial(km *keyMaterial) error {
+	if !c.IsLoggedIn() {
+		return fmt.Errorf("not logged in")
+	}
+
+	keyData, err := json.Marshal(km)
+	if err != nil {
+		return err
+	}
+	return c.backend().PutKeyData(string(keyData))
+}
@@ -0,0 +1,96 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/existflow/irontask/internal/database"
+	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/protocol"
+)
+
+// TestRotateWithDeletions rotates the key on one device right after it synced
+// a deletion and checks that another device still pulls, the deletion is
+// sealed with the old key
+func TestRotateWithDeletions(t *testing.T) {
+	fake := &fakeServer{items: make(map[string]protocol.SyncItem)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	open := func(dir string) *db.DB {
+		dbConn, err := db.Open(filepath.Join(dir, "tasks.sqlite"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = dbConn.Close()
+		})
+		return dbConn
+	}
+	dirA, dirB := t.TempDir(), t.TempDir()
+	dbA, dbB := open(dirA), open(dirB)
+	a, b := newTestClient(t, dirA, srv.URL), newTestClient(t, dirB, srv.URL)
+
+	ctx := context.Background()
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, id := range []string{"kept-task", "deleted-task"} {
+		if err := dbA.CreateTask(ctx, database.CreateTaskParams{
+			ID:        id,
+			ProjectID: "inbox",
+			Content:   id,
+			Priority:  4,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := a.Sync(dbA, SyncModeMerge); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Sync(dbB, SyncModeMerge); err != nil {
+		t.Fatal(err)
+	}
+
+	// Device A deletes a task and rotates, B has not pulled the deletion yet
+	if err := dbA.DeleteTask(ctx, database.DeleteTaskParams{
+		DeletedAt: sql.NullString{String: now, Valid: true},
+		UpdatedAt: now,
+		ID:        "deleted-task",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.RotateEncryptionKey(dbA, "new password"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Sync(dbB, SyncModeMerge); !errors.Is(err, ErrKeyRotated) {
+		t.Fatalf("sync with the old key: %v, want %v", err, ErrKeyRotated)
+	}
+	if err := b.UnlockEncryptionKey("new password"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := b.Sync(dbB, SyncModeMerge)
+	if err != nil {
+		t.Fatalf("sync after the rotation: %v", err)
+	}
+	if len(result.Failed) > 0 {
+		t.Fatalf("failed items: %+v", result.Failed)
+	}
+
+	if _, err := dbB.GetTask(ctx, "deleted-task"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted task still live on B: %v", err)
+	}
+	task, err := dbB.GetTask(ctx, "kept-task")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Content != "kept-task" {
+		t.Errorf("content = %q", task.Content)
+	}
+}
@@ -0,0 +1,76 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// lockFileName is the lock file of a profile, next to its sync settings
+const lockFileName = "sync.lock"
+
+// profileLock is a client's hold of the sync lock of its profile
+type profileLock struct {
+	mu    sync.Mutex
+	depth int      // Holds of the client, they nest
+	file  *os.File // Open while held
+}
+
+// Lock waits until no other process of the profile syncs or changes its sync
+// settings and keeps them out until unlock is called, e.g. the daemon while a
+// CLI command rotates the key. The settings are read again first, the last
+// holder may have changed them. Syncs take the lock themselves, commands
+// that change the settings or the synced data hold it for the whole change.
+// Locks of one client nest.
+func (c *Client) Lock() (unlock func(), err error) {
+	c.lock.mu.Lock()
+	defer c.lock.mu.Unlock()
+
+	if c.lock.depth == 0 {
+		path := filepath.Join(filepath.Dir(c.configPath), lockFileName)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open sync lock: %w", err)
+		}
+		if err := lockFile(f); err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		c.lock.file = f
+		c.reload()
+	}
+	c.lock.depth++
+
+	var once sync.Once
+	return func() {
+		once.Do(c.unlock)
+	}, nil
+}
+
+// unlock releases one hold of the lock, the last one lets other processes in
+func (c *Client) unlock() {
+	c.lock.mu.Lock()
+	defer c.lock.mu.Unlock()
+
+	c.lock.depth--
+	if c.lock.depth > 0 {
+		return
+	}
+	_ = unlockFile(c.lock.file)
+	_ = c.lock.file.Close()
+	c.lock.file = nil
+}
+
+// reload reads the sync settings again, dropping the cached key if another
+// process replaced it
+func (c *Client) reload() {
+	key := c.config.EncryptionKey
+	c.loadConfig()
+	if c.config.EncryptionKey != key {
+		c.crypto = nil
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package sync
+
+import "os"
+
+// lockFile does nothing where flock is missing, processes of a profile are
+// not kept apart there
+func lockFile(f *os.File) error { return nil }
+
+// unlockFile does nothing, see lockFile
+func unlockFile(f *os.File) error { return nil }
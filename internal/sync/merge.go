@@ -0,0 +1,344 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+
+	"github.com/existflow/irontask/internal/database"
+	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/logger"
+)
+
+// FieldConflict is a field both sides changed to different values
+type FieldConflict struct {
+	Field  string `json:"field"`
+	Base   string `json:"base"`
+	Local  string `json:"local"`
+	Server string `json:"server"`
+}
+
+// fieldDeleted is the conflict of one side deleting an item the other changed.
+// It is always the only conflict of an item and resolved for the whole item.
+const fieldDeleted = "deleted"
+
+// itemState is the synced state of an item, compared field by field
+type itemState struct {
+	Fields  itemPayload
+	Deleted bool
+}
+
+// mergeField reads and writes one synced field as text
+type mergeField struct {
+	name string
+	get  func(p *itemPayload) string
+	set  func(p *itemPayload, v string)
+}
+
+var projectFields = []mergeField{
+	{"name", func(p *itemPayload) string { return p.Name }, func(p *itemPayload, v string) { p.Name = v }},
+	{"slug", func(p *itemPayload) string { return p.Slug }, func(p *itemPayload, v string) { p.Slug = v }},
+	{"color", func(p *itemPayload) string { return p.Color }, func(p *itemPayload, v string) { p.Color = v }},
+	{"archived", func(p *itemPayload) string { return strconv.FormatBool(p.Archived) }, func(p *itemPayload, v string) { p.Archived, _ = strconv.ParseBool(v) }},
+}
+
+var taskFields = []mergeField{
+	{"content", func(p *itemPayload) string { return p.Content }, func(p *itemPayload, v string) { p.Content = v }},
+	{"project", func(p *itemPayload) string { return p.ProjectID }, func(p *itemPayload, v string) { p.ProjectID = v }},
+	{"status", func(p *itemPayload) string { return p.Status }, func(p *itemPayload, v string) { p.Status = v }},
+	{"priority", func(p *itemPayload) string { return strconv.Itoa(p.Priority) }, func(p *itemPayload, v string) { p.Priority, _ = strconv.Atoi(v) }},
+	{"due_date", func(p *itemPayload) string { return p.DueDate }, func(p *itemPayload, v string) { p.DueDate = v }},
+	{"tags", func(p *itemPayload) string { return p.Tags }, func(p *itemPayload, v string) { p.Tags = v }},
+}
+
+// fieldsOf returns the synced fields of an item type
+func fieldsOf(itemType string) []mergeField {
+	if itemType == "project" {
+		return projectFields
+	}
+	return taskFields
+}
+
+// mergeItems merges local and server changes to an item field by field. A field
+// changed on one side only takes that change, fields changed on both sides to
+// different values are returned as conflicts. Without a base every difference
+// is a conflict.
+func mergeItems(itemType string, base *itemState, local, server itemState) (itemState, []FieldConflict) {
+	merged := server
+	if local.Deleted && server.Deleted {
+		return merged, nil
+	}
+
+	if local.Deleted != server.Deleted {
+		// A delete wins over an untouched item only
+		kept := server
+		if server.Deleted {
+			kept = local
+		}
+		if base != nil && !base.Deleted && !changed(itemType, base, &kept) {
+			if local.Deleted {
+				return local, nil
+			}
+			return server, nil
+		}
+		conflict := FieldConflict{
+			Field:  fieldDeleted,
+			Local:  strconv.FormatBool(local.Deleted),
+			Server: strconv.FormatBool(server.Deleted),
+		}
+		if base != nil {
+			conflict.Base = strconv.FormatBool(base.Deleted)
+		}
+		return merged, []FieldConflict{conflict}
+	}
+
+	var conflicts []FieldConflict
+	for _, f := range fieldsOf(itemType) {
+		l, s := f.get(&local.Fields), f.get(&server.Fields)
+		if l == s {
+			continue
+		}
+		b := ""
+		if base != nil {
+			b = f.get(&base.Fields)
+			if l == b {
+				continue // Server change only
+			}
+			if s == b {
+				f.set(&merged.Fields, l) // Local change only
+				continue
+			}
+		}
+		conflicts = append(conflicts, FieldConflict{Field: f.name, Base: b, Local: l, Server: s})
+	}
+	return merged, conflicts
+}
+
+// changed returns true if any field of s differs from base
+func changed(itemType string, base, s *itemState) bool {
+	for _, f := range fieldsOf(itemType) {
+		if f.get(&base.Fields) != f.get(&s.Fields) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyChoices resolves conflicting fields of a merged item, keepLocal names
+// the fields whose local value wins. The others keep the server value.
+func applyChoices(itemType string, merged, local itemState, conflicts []FieldConflict, keepLocal map[string]bool) itemState {
+	for _, conflict := range conflicts {
+		if !keepLocal[conflict.Field] {
+			continue
+		}
+		if conflict.Field == fieldDeleted {
+			return local
+		}
+		for _, f := range fieldsOf(itemType) {
+			if f.name == conflict.Field {
+				f.set(&merged.Fields, f.get(&local.Fields))
+			}
+		}
+	}
+	return merged
+}
+
+// mergeConflicts merges each conflict of a push field by field. It returns the
+// conflicts left for the user, with their conflicting fields, and how many
+// merged items need to be pushed again.
+func (c *Client) mergeConflicts(dbConn *db.DB, conflicts []ConflictItem) ([]ConflictItem, int) {
+	ctx := context.Background()
+	var remaining []ConflictItem
+	repush := 0
+
+	for _, conflict := range conflicts {
+		local, server, err := c.conflictStates(conflict)
+		if err != nil {
+			logger.Error("Cannot merge conflict", logger.F("id", conflict.ClientID), logger.F("error", err))
+			remaining = append(remaining, conflict)
+			continue
+		}
+
+		base := c.loadBase(ctx, dbConn.Queries, conflict.Type, conflict.ClientID, conflict.ClientData.BaseVersion)
+		merged, fields := mergeItems(conflict.Type, base, local, server)
+		if len(fields) > 0 {
+			conflict.Fields = fields
+			remaining = append(remaining, conflict)
+			continue
+		}
+
+		push, err := c.writeMerged(ctx, dbConn.Queries, conflict, merged, local, server)
+		if err != nil {
+			logger.Error("Failed to write merged item", logger.F("id", conflict.ClientID), logger.F("error", err))
+			remaining = append(remaining, conflict)
+			continue
+		}
+		logger.Debug("Merged conflict", logger.F("id", conflict.ClientID), logger.F("push", push))
+		if push {
+			repush++
+		}
+	}
+
+	return remaining, repush
+}
+
+// ResolveConflict resolves the conflicting fields of a conflict, keepLocal
+// names the fields whose local value wins. Fields merged automatically keep
+// their merged value. A result that differs from the server version is pushed
+// by the next sync.
+func (c *Client) ResolveConflict(dbConn *db.DB, conflict ConflictItem, keepLocal map[string]bool) error {
+	ctx := context.Background()
+	local, server, err := c.conflictStates(conflict)
+	if err != nil {
+		return err
+	}
+
+	base := c.loadBase(ctx, dbConn.Queries, conflict.Type, conflict.ClientID, conflict.ClientData.BaseVersion)
+	merged, fields := mergeItems(conflict.Type, base, local, server)
+	merged = applyChoices(conflict.Type, merged, local, fields, keepLocal)
+
+	if _, err := c.writeMerged(ctx, dbConn.Queries, conflict, merged, local, server); err != nil {
+		return err
+	}
+	return dropConflict(ctx, dbConn.Queries, conflict)
+}
+
+// KeepLocal resolves a conflict in favor of the whole local version. It is
+// based on the server version and pushed again by the next sync.
+func (c *Client) KeepLocal(dbConn *db.DB, conflict ConflictItem) error {
+	ctx := context.Background()
+	local, server, err := c.conflictStates(conflict)
+	if err == nil {
+		if _, err := c.writeMerged(ctx, dbConn.Queries, conflict, local, local, server); err != nil {
+			return err
+		}
+		return dropConflict(ctx, dbConn.Queries, conflict)
+	}
+
+	// Unreadable server version, there is no base to record
+	base := sql.NullInt64{Int64: conflict.ServerVersion, Valid: true}
+	if conflict.Type == "project" {
+		err = dbConn.RebaseProject(ctx, database.RebaseProjectParams{BaseVersion: base, ID: conflict.ClientID})
+	} else {
+		err = dbConn.RebaseTask(ctx, database.RebaseTaskParams{BaseVersion: base, ID: conflict.ClientID})
+	}
+	if err != nil {
+		return err
+	}
+	return dropConflict(ctx, dbConn.Queries, conflict)
+}
+
+// conflictStates returns the decrypted local and server side of a conflict
+func (c *Client) conflictStates(conflict ConflictItem) (itemState, itemState, error) {
+	local, err := c.itemState(conflict.ClientData)
+	if err != nil {
+		return itemState{}, itemState{}, err
+	}
+	server, err := c.itemState(conflict.ServerData)
+	if err != nil {
+		return itemState{}, itemState{}, err
+	}
+	return local, server, nil
+}
+
+// writeMerged stores the merged version of a conflicting item, based on the
+// server version. It returns true if the item differs from the server version
+// and needs to be pushed.
+func (c *Client) writeMerged(ctx context.Context, q *database.Queries, conflict ConflictItem, merged, local, server itemState) (bool, error) {
+	id := conflict.ClientID
+	serverVersion := sql.NullInt64{Int64: conflict.ServerVersion, Valid: true}
+
+	switch {
+	case merged == server && !server.Deleted:
+		return false, c.applyItem(ctx, q, conflict.ServerData, true)
+
+	case merged == local:
+		var err error
+		if conflict.Type == "project" {
+			err = q.RebaseProject(ctx, database.RebaseProjectParams{BaseVersion: serverVersion, ID: id})
+		} else {
+			err = q.RebaseTask(ctx, database.RebaseTaskParams{BaseVersion: serverVersion, ID: id})
+		}
+		if err != nil {
+			return false, err
+		}
+
+	case merged == server:
+		return false, c.applyItem(ctx, q, conflict.ServerData, true)
+
+	default:
+		p := merged.Fields
+		now := stamp(ctx, q)
+		var err error
+		if conflict.Type == "project" {
+			err = q.OverwriteProject(ctx, database.OverwriteProjectParams{
+				ID:          id,
+				Slug:        p.Slug,
+				Name:        p.Name,
+				Color:       sql.NullString{String: p.Color, Valid: p.Color != ""},
+				Archived:    p.Archived,
+				UpdatedAt:   now,
+				BaseVersion: serverVersion,
+			})
+		} else {
+			err = q.OverwriteTask(ctx, database.OverwriteTaskParams{
+				ID:          id,
+				ProjectID:   p.ProjectID,
+				Content:     p.Content,
+				Status:      sql.NullString{String: p.Status, Valid: p.Status != ""},
+				Priority:    p.Priority,
+				DueDate:     sql.NullString{String: p.DueDate, Valid: p.DueDate != ""},
+				Tags:        sql.NullString{String: p.Tags, Valid: p.Tags != ""},
+				UpdatedAt:   now,
+				BaseVersion: serverVersion,
+			})
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// The server version is the new base of the local change
+	return true, saveBase(ctx, q, conflict.Type, id, conflict.ServerVersion, server)
+}
+
+// itemState returns the decrypted state of a sync item
+func (c *Client) itemState(item SyncItem) (itemState, error) {
+	p, err := c.openItem(item)
+	if err != nil {
+		return itemState{}, err
+	}
+	p.UpdatedAt = ""
+	return itemState{Fields: *p, Deleted: item.Deleted}, nil
+}
+
+// loadBase returns the base snapshot of an item, nil if there is none for the
+// version the local change is based on
+func (c *Client) loadBase(ctx context.Context, q *database.Queries, itemType, id string, version int64) *itemState {
+	row, err := q.GetSyncBase(ctx, database.GetSyncBaseParams{ItemType: itemType, ItemID: id})
+	if err != nil || row.Version != version {
+		return nil
+	}
+	var p itemPayload
+	if err := json.Unmarshal([]byte(row.Data), &p); err != nil {
+		return nil
+	}
+	return &itemState{Fields: p, Deleted: row.Deleted}
+}
+
+// saveBase records the synced state of an item as the base of later merges
+func saveBase(ctx context.Context, q *database.Queries, itemType, id string, version int64, s itemState) error {
+	s.Fields.UpdatedAt = ""
+	data, err := json.Marshal(s.Fields)
+	if err != nil {
+		return err
+	}
+	return q.UpsertSyncBase(ctx, database.UpsertSyncBaseParams{
+		ItemType: itemType,
+		ItemID:   id,
+		Version:  version,
+		Deleted:  s.Deleted,
+		Data:     string(data),
+	})
+}
@@ -0,0 +1,202 @@
+package sync
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// projectPayload is the encrypted part of a project sync item
+type projectPayload struct {
+	Slug      string `json:"slug,omitempty"` // Sent in plain text by older versions
+	Name      string `json:"name"`
+	Color     string `json:"color"`
+	Archived  bool   `json:"archived,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+}
+
+// taskPayload is the encrypted part of a task sync item
+type taskPayload struct {
+	Content   string `json:"content"`
+	Tags      string `json:"tags,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+}
+
+// itemPayload is the blob of an opaque item, it holds every field so the
+// server sees nothing but the blob
+type itemPayload struct {
+	ID        string `json:"id"`
+	Slug      string `json:"slug,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Color     string `json:"color,omitempty"`
+	Archived  bool   `json:"archived,omitempty"`
+	ProjectID string `json:"project_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+	Status    string `json:"status,omitempty"`
+	Priority  int    `json:"priority,omitempty"`
+	DueDate   string `json:"due_date,omitempty"`
+	Tags      string `json:"tags,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+// syncedColumns maps every column of the synced tables to the item field that
+// carries it: a JSON field of itemPayload, or the deleted flag and versions of
+// SyncItem. A column missing here would silently not sync.
+var syncedColumns = map[string]map[string]string{
+	"projects": {
+		"id":           "id",
+		"slug":         "slug",
+		"name":         "name",
+		"color":        "color",
+		"archived":     "archived",
+		"created_at":   "created_at",
+		"updated_at":   "updated_at",
+		"deleted_at":   "deleted",
+		"sync_version": "sync_version",
+		"base_version": "base_version",
+	},
+	"tasks": {
+		"id":           "id",
+		"project_id":   "project_id",
+		"content":      "content",
+		"status":       "status",
+		"priority":     "priority",
+		"due_date":     "due_date",
+		"tags":         "tags",
+		"created_at":   "created_at",
+		"updated_at":   "updated_at",
+		"deleted_at":   "deleted",
+		"sync_version": "sync_version",
+		"base_version": "base_version",
+	},
+}
+
+// sealOpaque turns item into an opaque item. Its client id is replaced with a
+// keyed pseudonym, since project ids are often derived from the name.
+func (c *Client) sealOpaque(item SyncItem, p itemPayload) (SyncItem, error) {
+	crypto, err := c.getCrypto()
+	if err != nil {
+		return SyncItem{}, err
+	}
+	p.ID = item.ClientID
+	blob, err := c.seal(p)
+	if err != nil {
+		return SyncItem{}, err
+	}
+	return SyncItem{
+		ClientID:    crypto.Pseudonym(item.Type + ":" + item.ClientID),
+		Type:        item.Type,
+		Blob:        blob,
+		SyncVersion: item.SyncVersion,
+		BaseVersion: item.BaseVersion,
+		Deleted:     item.Deleted,
+	}, nil
+}
+
+// openItem returns the decrypted fields of an item, opaque or not
+func (c *Client) openItem(item SyncItem) (*itemPayload, error) {
+	if item.Blob != "" {
+		var p itemPayload
+		if err := c.open(item.Blob, &p, false); err != nil {
+			return nil, err
+		}
+		if p.ID == "" {
+			p.ID = item.ClientID
+		}
+		return &p, nil
+	}
+
+	p := &itemPayload{
+		ID:        item.ClientID,
+		Slug:      item.Slug,
+		Name:      item.Name,
+		ProjectID: item.ProjectID,
+		Status:    item.Status,
+		Priority:  item.Priority,
+		DueDate:   item.DueDate,
+		UpdatedAt: item.Clock,
+	}
+	switch item.Type {
+	case "project":
+		var pp projectPayload
+		if err := c.open(item.EncryptedData, &pp, true); err != nil {
+			return nil, err
+		}
+		if pp.Slug != "" {
+			p.Slug = pp.Slug
+		}
+		if pp.Name != "" {
+			p.Name = pp.Name
+		}
+		p.Color = pp.Color
+		p.Archived = pp.Archived
+		p.CreatedAt = pp.CreatedAt
+	case "task":
+		var tp taskPayload
+		if err := c.open(item.EncryptedContent, &tp, true); err != nil {
+			return nil, err
+		}
+		p.Content = tp.Content
+		p.Tags = tp.Tags
+		p.CreatedAt = tp.CreatedAt
+	}
+	return p, nil
+}
+
+// seal encrypts v as JSON with the configured key
+func (c *Client) seal(v interface{}) (string, error) {
+	crypto, err := c.getCrypto()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return crypto.Encrypt(data)
+}
+
+// open decrypts a blob produced by seal into v. Blobs pushed by versions
+// without encryption are plain base64 JSON. With legacy set they are accepted
+// until this device re-uploaded its data encrypted, afterwards a blob that
+// does not decrypt is rejected, the server could forge it otherwise.
+func (c *Client) open(blob string, v interface{}, legacy bool) error {
+	if blob == "" {
+		return nil
+	}
+	crypto, err := c.getCrypto()
+	if err != nil {
+		return err
+	}
+
+	data, err := crypto.Decrypt(blob)
+	if err != nil {
+		plain, ok := decodeLegacyBlob(blob)
+		if !ok || !legacy || c.config.BlobsMigrated {
+			return err
+		}
+		data = plain
+	}
+	return json.Unmarshal(data, v)
+}
+
+// decodeLegacyBlob decodes an unencrypted base64 JSON object
+func decodeLegacyBlob(blob string) ([]byte, bool) {
+	data, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil || len(data) == 0 || data[0] != '{' || !json.Valid(data) {
+		return nil, false
+	}
+	return data, true
+}
+
+// DescribeItem returns the decrypted task content or project name of a sync item
+func (c *Client) DescribeItem(item SyncItem) (string, error) {
+	p, err := c.openItem(item)
+	if err != nil {
+		return item.Name, err
+	}
+	if item.Type == "project" {
+		return p.Name, nil
+	}
+	return p.Content, nil
+}
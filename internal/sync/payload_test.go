@@ -0,0 +1,143 @@
+package sync
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/protocol"
+)
+
+// TestSyncedColumns fails when a column of a synced table is not carried by
+// sync items, add it to the payloads and to syncedColumns
+func TestSyncedColumns(t *testing.T) {
+	database, err := db.Open(filepath.Join(t.TempDir(), "tasks.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = database.Close()
+	}()
+
+	fields := map[string]bool{}
+	for _, v := range []interface{}{itemPayload{}, SyncItem{}} {
+		typ := reflect.TypeOf(v)
+		for i := 0; i < typ.NumField(); i++ {
+			name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			fields[name] = true
+		}
+	}
+
+	for table, columns := range syncedColumns {
+		rows, err := database.Query("SELECT name FROM pragma_table_info(?)", table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found int
+		for rows.Next() {
+			var column string
+			if err := rows.Scan(&column); err != nil {
+				t.Fatal(err)
+			}
+			found++
+			field, ok := columns[column]
+			if !ok {
+				t.Errorf("column %s.%s is not synced", table, column)
+				continue
+			}
+			if !fields[field] {
+				t.Errorf("column %s.%s maps to unknown sync field %q", table, column, field)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		_ = rows.Close()
+		if found == 0 {
+			t.Errorf("table %s not found", table)
+		}
+	}
+}
+
+// TestPayloadRoundTrip pushes a project and a task with every synced column
+// set from one device and pulls them into another, with and without opaque
+// items, and fails when a column arrives changed
+func TestPayloadRoundTrip(t *testing.T) {
+	rows := []struct {
+		table, id, insert string
+	}{
+		{"projects", "p1", `INSERT INTO projects (id, slug, name, color, archived, created_at, updated_at)
+			VALUES ('p1', 'work', 'Work', '#FF0000', 1,
+			'2026-01-02T03:04:05.000Z-0000-0a0b0c0d', '2026-01-03T03:04:05.000Z-0000-0a0b0c0d')`},
+		{"tasks", "t1", `INSERT INTO tasks (id, project_id, content, status, priority, due_date, tags, created_at, updated_at)
+			VALUES ('t1', 'p1', 'Write report', 'done', 1, '2026-02-01', 'urgent,q1',
+			'2026-01-04T03:04:05.000Z-0000-0a0b0c0d', '2026-01-05T03:04:05.000Z-0000-0a0b0c0d')`},
+	}
+
+	for _, opaque := range []bool{false, true} {
+		t.Run(fmt.Sprintf("opaque=%v", opaque), func(t *testing.T) {
+			srv := httptest.NewServer(&fakeServer{items: make(map[string]protocol.SyncItem)})
+			defer srv.Close()
+
+			// Columns as written, versions are handed out by the server
+			want := map[string]string{}
+			var received *db.DB
+			for i := 0; i < 2; i++ {
+				dir := t.TempDir()
+				database, err := db.Open(filepath.Join(dir, "tasks.sqlite"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer func() {
+					_ = database.Close()
+				}()
+
+				if i == 0 {
+					for _, row := range rows {
+						if _, err := database.Exec(row.insert); err != nil {
+							t.Fatal(err)
+						}
+						for column := range syncedColumns[row.table] {
+							var value sql.NullString
+							query := fmt.Sprintf("SELECT %s FROM %s WHERE id = ?", column, row.table)
+							if err := database.QueryRow(query, row.id).Scan(&value); err != nil {
+								t.Fatal(err)
+							}
+							want[row.table+"."+column] = value.String
+						}
+					}
+				}
+				client := newTestClient(t, dir, srv.URL)
+				client.config.Opaque = opaque
+				if err := client.saveConfig(); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := client.Sync(database, SyncModeMerge); err != nil {
+					t.Fatal(err)
+				}
+				received = database
+			}
+
+			for _, row := range rows {
+				for column := range syncedColumns[row.table] {
+					if column == "sync_version" || column == "base_version" {
+						continue
+					}
+					var value sql.NullString
+					query := fmt.Sprintf("SELECT %s FROM %s WHERE id = ?", column, row.table)
+					if err := received.QueryRow(query, row.id).Scan(&value); err != nil {
+						t.Fatalf("%s %s not synced: %v", row.table, row.id, err)
+					}
+					if value.String != want[row.table+"."+column] {
+						t.Errorf("%s.%s = %q after sync, want %q", row.table, column, value.String, want[row.table+"."+column])
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/existflow/irontask/internal/db"
+)
+
+// PlanItem is a project or task a sync would change
+type PlanItem struct {
+	Type string
+	ID   string
+	Name string // Project name or task content, if it could be read
+}
+
+// PlanChanges are the changes a sync would make on one side
+type PlanChanges struct {
+	Created []PlanItem
+	Updated []PlanItem
+	Deleted []PlanItem
+}
+
+// Count returns the number of changed items
+func (p PlanChanges) Count() int {
+	return len(p.Created) + len(p.Updated) + len(p.Deleted)
+}
+
+// SyncPlan lists what a sync would change on this device and on the server
+type SyncPlan struct {
+	Local     PlanChanges
+	Remote    PlanChanges
+	Conflicts []PlanItem // Changed on both sides, merged or left to resolve
+}
+
+// Preview returns what Sync would change in the given mode, without changing
+// anything on either side
+func (c *Client) Preview(dbConn *db.DB, mode SyncMode) (*SyncPlan, error) {
+	if !c.IsLoggedIn() {
+		return nil, fmt.Errorf("not logged in")
+	}
+	if _, err := c.getCrypto(); err != nil {
+		return nil, err
+	}
+	if err := c.checkRemoteKey(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case mode == SyncModeRemoteToLocal:
+		return c.previewReplace(dbConn, true, false)
+	case mode == SyncModeRebuildLocal:
+		return c.previewReplace(dbConn, true, true)
+	case mode == SyncModeLocalToRemote, c.config.ReplaceRemote:
+		return c.previewReplace(dbConn, false, false)
+	case mode == SyncModeReseed:
+		return c.previewReseed(dbConn)
+	}
+	return c.previewMerge(dbConn)
+}
+
+// previewMerge plans pushing the unpushed local changes and pulling the
+// server changes since the last sync
+func (c *Client) previewMerge(dbConn *db.DB) (*SyncPlan, error) {
+	ctx := context.Background()
+	plan := &SyncPlan{}
+
+	// Local changes, by type and id, removed again if they conflict
+	type pushed struct {
+		item PlanItem
+		list *[]PlanItem
+	}
+	dirty := make(map[string]pushed)
+	projects, err := dbConn.GetProjectsToSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range projects {
+		item := PlanItem{Type: "project", ID: p.ID, Name: p.Name}
+		if list := remoteList(&plan.Remote, p.BaseVersion.Int64, p.DeletedAt.Valid); list != nil {
+			dirty[item.Type+":"+item.ID] = pushed{item, list}
+		}
+	}
+	tasks, err := dbConn.GetTasksToSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tasks {
+		item := PlanItem{Type: "task", ID: t.ID, Name: t.Content}
+		if list := remoteList(&plan.Remote, t.BaseVersion.Int64, t.DeletedAt.Valid); list != nil {
+			dirty[item.Type+":"+item.ID] = pushed{item, list}
+		}
+	}
+
+	items, err := c.fetchAll(c.config.LastSync)
+	if errors.Is(err, errResyncRequired) {
+		return nil, fmt.Errorf("deletions since the last sync were purged on the server, the next sync pulls everything again")
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, serverItem := range items {
+		item := c.planItem(serverItem)
+		key := item.Type + ":" + item.ID
+		if _, ok := dirty[key]; ok {
+			plan.Conflicts = append(plan.Conflicts, item)
+			delete(dirty, key)
+			continue
+		}
+
+		version, live := localVersion(ctx, dbConn, item)
+		switch {
+		case serverItem.Deleted && live:
+			plan.Local.Deleted = append(plan.Local.Deleted, item)
+		case serverItem.Deleted:
+		case !live:
+			plan.Local.Created = append(plan.Local.Created, item)
+		case version != serverItem.SyncVersion:
+			plan.Local.Updated = append(plan.Local.Updated, item)
+		}
+	}
+
+	for _, p := range dirty {
+		*p.list = append(*p.list, p.item)
+	}
+	plan.sort()
+	return plan, nil
+}
+
+// remoteList returns the list an unpushed change belongs to, nil for items
+// deleted before they were ever pushed
+func remoteList(remote *PlanChanges, baseVersion int64, deleted bool) *[]PlanItem {
+	switch {
+	case baseVersion == 0 && deleted:
+		return nil
+	case baseVersion == 0:
+		return &remote.Created
+	case deleted:
+		return &remote.Deleted
+	}
+	return &remote.Updated
+}
+
+// previewReplace plans replacing one side with the other, the local data
+// with the server's if toLocal is set and the server's with the local otherwise.
+// With keepUnpushed, unpushed local changes survive the replacement and
+// conflict with the server copy if it exists.
+func (c *Client) previewReplace(dbConn *db.DB, toLocal, keepUnpushed bool) (*SyncPlan, error) {
+	ctx := context.Background()
+	plan := &SyncPlan{}
+	target := &plan.Remote
+	if toLocal {
+		target = &plan.Local
+	}
+
+	rows, err := dbConn.ListSyncDigestItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+	type localItem struct {
+		version int64 // 0 while unpushed
+		live    bool
+	}
+	local := make(map[string]localItem)
+	for _, row := range rows {
+		local[row.ItemType+":"+row.ID] = localItem{version: row.SyncVersion.Int64, live: !row.DeletedAt.Valid}
+	}
+
+	items, err := c.fetchAll(0)
+	if err != nil {
+		return nil, err
+	}
+	onServer := make(map[string]bool)
+	for _, serverItem := range items {
+		if serverItem.Deleted {
+			continue
+		}
+		item := c.planItem(serverItem)
+		key := item.Type + ":" + item.ID
+		onServer[key] = true
+		l, ok := local[key]
+		switch {
+		case ok && keepUnpushed && l.version == 0:
+			plan.Conflicts = append(plan.Conflicts, item)
+		case ok && l.live && l.version != serverItem.SyncVersion:
+			target.Updated = append(target.Updated, item)
+		case ok && l.live:
+		case toLocal:
+			plan.Local.Created = append(plan.Local.Created, item)
+		default:
+			plan.Remote.Deleted = append(plan.Remote.Deleted, item)
+		}
+	}
+
+	for _, row := range rows {
+		key := row.ItemType + ":" + row.ID
+		if onServer[key] || row.DeletedAt.Valid || (keepUnpushed && !row.SyncVersion.Valid) {
+			continue
+		}
+		item := PlanItem{Type: row.ItemType, ID: row.ID, Name: localName(ctx, dbConn.Queries, row.ItemType, row.ID)}
+		if toLocal {
+			plan.Local.Deleted = append(plan.Local.Deleted, item)
+		} else {
+			plan.Remote.Created = append(plan.Remote.Created, item)
+		}
+	}
+	plan.sort()
+	return plan, nil
+}
+
+// previewReseed plans pushing every local item again on top of the server
+// copy. Items the server changed since this device last had them conflict,
+// server items missing here stay.
+func (c *Client) previewReseed(dbConn *db.DB) (*SyncPlan, error) {
+	ctx := context.Background()
+	plan := &SyncPlan{}
+
+	items, err := c.fetchAll(0)
+	if err != nil {
+		return nil, err
+	}
+	onServer := make(map[string]SyncItem)
+	for _, serverItem := range items {
+		onServer[serverItem.Type+":"+serverItem.ClientID] = serverItem
+	}
+
+	rows, err := dbConn.ListSyncDigestItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		serverItem, ok := onServer[row.ItemType+":"+row.ID]
+		item := PlanItem{Type: row.ItemType, ID: row.ID, Name: localName(ctx, dbConn.Queries, row.ItemType, row.ID)}
+		switch {
+		case ok && row.SyncVersion.Valid && row.SyncVersion.Int64 != serverItem.SyncVersion:
+			plan.Conflicts = append(plan.Conflicts, item)
+		case row.DeletedAt.Valid:
+			if ok && !serverItem.Deleted {
+				plan.Remote.Deleted = append(plan.Remote.Deleted, item)
+			}
+		case !ok || serverItem.Deleted:
+			plan.Remote.Created = append(plan.Remote.Created, item)
+		default:
+			plan.Remote.Updated = append(plan.Remote.Updated, item)
+		}
+	}
+	plan.sort()
+	return plan, nil
+}
+
+// fetchAll pulls every server change since a version without recording the
+// pull for this device
+func (c *Client) fetchAll(since int64) ([]SyncItem, error) {
+	var items []SyncItem
+	cursor := ""
+	for {
+		page, err := c.backend().Pull(since, cursor, "")
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page.Items...)
+		if page.NextCursor == "" {
+			return items, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// planItem returns the plan entry of a server item, with its local id and
+// name if it can be decrypted
+func (c *Client) planItem(item SyncItem) PlanItem {
+	plan := PlanItem{Type: item.Type, ID: item.ClientID}
+	if p, err := c.openItem(item); err == nil {
+		plan.ID = p.ID
+		plan.Name = p.Name
+		if item.Type == "task" {
+			plan.Name = p.Content
+		}
+	}
+	return plan
+}
+
+// localVersion returns the synced version of the local copy of an item and
+// whether it exists and is not deleted
+func localVersion(ctx context.Context, dbConn *db.DB, item PlanItem) (int64, bool) {
+	if item.Type == "project" {
+		p, err := dbConn.GetProjectForSync(ctx, item.ID)
+		if err != nil {
+			return 0, false
+		}
+		return p.SyncVersion.Int64, !p.DeletedAt.Valid
+	}
+	t, err := dbConn.GetTaskForSync(ctx, item.ID)
+	if err != nil {
+		return 0, false
+	}
+	return t.SyncVersion.Int64, !t.DeletedAt.Valid
+}
+
+// sort orders every list by type and id
+func (p *SyncPlan) sort() {
+	for _, list := range [][]PlanItem{
+		p.Local.Created, p.Local.Updated, p.Local.Deleted,
+		p.Remote.Created, p.Remote.Updated, p.Remote.Deleted,
+		p.Conflicts,
+	} {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Type != list[j].Type {
+				return list[i].Type < list[j].Type
+			}
+			return list[i].ID < list[j].ID
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package sync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/internal/protocol"
+)
+
+// errStreamUnsupported is returned by Stream when the server sends no change
+// notifications, polling finds its changes instead
+var errStreamUnsupported = errors.New("the server does not send change notifications")
+
+// protocolTransport sends the protocol version of this build with every
+// request and notes the version the server answers with
+type protocolTransport struct {
+	base    http.RoundTripper
+	version atomic.Int64 // Protocol version of the last response, 0 before one arrived
+}
+
+func newProtocolTransport(base http.RoundTripper) *protocolTransport {
+	return &protocolTransport{base: base}
+}
+
+func (t *protocolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(protocol.VersionHeader, strconv.Itoa(protocol.Version))
+
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		if v, err := strconv.Atoi(resp.Header.Get(protocol.VersionHeader)); err == nil {
+			t.version.Store(int64(v))
+		}
+	}
+	return resp, err
+}
+
+// serverCaps caches the capabilities of the server for the process
+type serverCaps struct {
+	mu      sync.Mutex
+	url     string // Server the capabilities belong to
+	version int64  // Protocol version the server reported when they were fetched
+	caps    *protocol.Capabilities
+}
+
+// ServerCapabilities returns what the server supports. They are fetched once
+// per process and again when the server reports another protocol version, e.g.
+// after an upgrade. Servers from before capabilities were served get the
+// legacy set.
+func (c *Client) ServerCapabilities() (*protocol.Capabilities, error) {
+	c.caps.mu.Lock()
+	defer c.caps.mu.Unlock()
+
+	url := c.config.ServerURL
+	if c.caps.caps != nil && c.caps.url == url && c.caps.version == c.protocol.version.Load() {
+		return c.caps.caps, nil
+	}
+
+	resp, err := c.httpClient.Get(url + "/api/v1/capabilities")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	var caps *protocol.Capabilities
+	switch resp.StatusCode {
+	case http.StatusOK:
+		caps = &protocol.Capabilities{}
+		if err := json.NewDecoder(resp.Body).Decode(caps); err != nil {
+			return nil, fmt.Errorf("invalid server capabilities: %w", err)
+		}
+	case http.StatusNotFound:
+		caps = protocol.Legacy()
+	case http.StatusUpgradeRequired:
+		var body struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		if body.Error == "" {
+			body.Error = "the server no longer supports this IronTask, please upgrade it"
+		}
+		return nil, errors.New(body.Error)
+	default:
+		respBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server error: %s", string(respBody))
+	}
+
+	logger.Debug("Server capabilities",
+		logger.F("version", caps.Version),
+		logger.F("minVersion", caps.MinVersion),
+		logger.F("features", caps.Features))
+
+	c.caps.url = url
+	c.caps.version = c.protocol.version.Load()
+	c.caps.caps = caps
+	return caps, nil
+}
+
+// checkServer fails unless this build can sync with the server in the
+// configured mode
+func (c *Client) checkServer() error {
+	if !c.UsesServer() {
+		return nil
+	}
+
+	caps, err := c.ServerCapabilities()
+	if err != nil {
+		return err
+	}
+	if err := caps.Check(); err != nil {
+		return err
+	}
+	if c.config.Opaque && !caps.Has(protocol.FeatureOpaque) {
+		return fmt.Errorf("the server does not support opaque mode, upgrade it or run 'irontask sync config --opaque=false'")
+	}
+	return nil
+}
+
+// serverHas reports whether the server offers a feature. Without an answer
+// it does not, callers fall back to what every server supports.
+func (c *Client) serverHas(feature string) bool {
+	caps, err := c.ServerCapabilities()
+	if err != nil {
+		return false
+	}
+	return caps.Has(feature)
+}
+
+// batchSizes returns the size of pull pages and push batches, limited to what
+// the server takes
+func (c *Client) batchSizes() (pull, push int) {
+	pull, push = c.BatchSize(), c.BatchSize()
+	if !c.UsesServer() {
+		return pull, push
+	}
+	if caps, err := c.ServerCapabilities(); err == nil {
+		if caps.MaxPullLimit > 0 {
+			pull = min(pull, caps.MaxPullLimit)
+		}
+		if caps.MaxPushItems > 0 {
+			push = min(push, caps.MaxPushItems)
+		}
+	}
+	return pull, push
+}
@@ -0,0 +1,83 @@
+package sync
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	recoveryEntropySize  = 16 // 128 bit secret
+	recoveryChecksumSize = 2  // Catches typos and swapped words
+	recoveryKeyInfo      = "irontask recovery key v1"
+)
+
+// ErrInvalidRecoveryPhrase is returned when a recovery phrase is mistyped or
+// belongs to another key
+var ErrInvalidRecoveryPhrase = errors.New("invalid recovery phrase")
+
+// newRecoveryEntropy returns the random secret behind a new recovery phrase
+func newRecoveryEntropy() ([]byte, error) {
+	entropy := make([]byte, recoveryEntropySize)
+	if _, err := rand.Read(entropy); err != nil {
+		return nil, err
+	}
+	return entropy, nil
+}
+
+// encodeRecoveryPhrase turns the secret and its checksum into words, one per byte
+func encodeRecoveryPhrase(entropy []byte) string {
+	sum := sha256.Sum256(entropy)
+	data := append(append([]byte(nil), entropy...), sum[:recoveryChecksumSize]...)
+
+	words := make([]string, len(data))
+	for i, b := range data {
+		words[i] = recoveryWords[b]
+	}
+	return strings.Join(words, " ")
+}
+
+// decodeRecoveryPhrase parses a phrase and verifies its checksum
+func decodeRecoveryPhrase(phrase string) ([]byte, error) {
+	words := strings.Fields(strings.ToLower(phrase))
+	if len(words) != recoveryEntropySize+recoveryChecksumSize {
+		return nil, fmt.Errorf("%w: expected %d words, got %d", ErrInvalidRecoveryPhrase,
+			recoveryEntropySize+recoveryChecksumSize, len(words))
+	}
+
+	index := make(map[string]byte, len(recoveryWords))
+	for i, w := range recoveryWords {
+		index[w] = byte(i)
+	}
+
+	data := make([]byte, len(words))
+	for i, w := range words {
+		b, ok := index[w]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown word %q", ErrInvalidRecoveryPhrase, w)
+		}
+		data[i] = b
+	}
+
+	entropy := data[:recoveryEntropySize]
+	sum := sha256.Sum256(entropy)
+	if string(sum[:recoveryChecksumSize]) != string(data[recoveryEntropySize:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch, check for typos", ErrInvalidRecoveryPhrase)
+	}
+	return entropy, nil
+}
+
+// recoveryCrypto derives the key that wraps the master key from the phrase secret.
+// The secret is random, so HKDF is enough and no password hashing is needed.
+func recoveryCrypto(entropy []byte) (*Crypto, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, entropy, nil, []byte(recoveryKeyInfo)), key); err != nil {
+		return nil, err
+	}
+	return NewCryptoFromKey(key, KDFParams{KDF: KDFNone})
+}
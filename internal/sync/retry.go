@@ -0,0 +1,78 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"time"
+)
+
+// RetryPolicy spaces out retries of a failing sync exponentially, with jitter
+// so devices that went offline together do not retry in lockstep
+type RetryPolicy struct {
+	Base   time.Duration // Delay after the first failure
+	Max    time.Duration // Longest delay
+	Jitter float64       // Fraction of the delay that is random, 0 to 1
+}
+
+// DefaultRetryPolicy retries after about 2s, 4s, 8s, ... up to 5 minutes
+var DefaultRetryPolicy = RetryPolicy{
+	Base:   2 * time.Second,
+	Max:    5 * time.Minute,
+	Jitter: 0.5,
+}
+
+// Delay returns how long to wait before the retry after the given number of
+// consecutive failures
+func (p RetryPolicy) Delay(failures int) time.Duration {
+	d := p.Base
+	for i := 1; i < failures && d < p.Max; i++ {
+		d *= 2
+	}
+	d = min(d, p.Max)
+	if p.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// IsOffline returns true if err means the server could not be reached, as
+// opposed to the server rejecting the request
+func IsOffline(err error) bool {
+	if err == nil {
+		return false
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// Status is the state of background syncing
+type Status struct {
+	Syncing      bool
+	Offline      bool
+	OfflineSince time.Time // When the first sync failed to reach the server
+	Pending      int       // Local changes not pushed yet
+	NextRetry    time.Time // Zero unless a retry is scheduled
+	LastError    error
+}
+
+// String describes an offline state, e.g. "3 changes pending, offline since 14:05"
+func (s Status) String() string {
+	if !s.Offline {
+		return ""
+	}
+	since := s.OfflineSince.Format("15:04")
+	switch s.Pending {
+	case 0:
+		return fmt.Sprintf("Offline since %s", since)
+	case 1:
+		return fmt.Sprintf("1 change pending, offline since %s", since)
+	}
+	return fmt.Sprintf("%d changes pending, offline since %s", s.Pending, since)
+}
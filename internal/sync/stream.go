@@ -0,0 +1,101 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/internal/protocol"
+)
+
+// streamIdleTimeout drops a stream that sent nothing, not even the server's
+// heartbeat, for this long
+const streamIdleTimeout = 60 * time.Second
+
+// StreamEvent tells that another device pushed changes
+type StreamEvent = protocol.StreamEvent
+
+// Stream subscribes to change notifications of the account and calls onChange
+// for each one. onConnect is called once the stream is open. It blocks until
+// the stream drops or ctx is cancelled and returns why.
+func (c *Client) Stream(ctx context.Context, device string, onConnect func(), onChange func(StreamEvent)) error {
+	caps, err := c.ServerCapabilities()
+	if err != nil {
+		return err // Unreachable, retried like a dropped stream
+	}
+	if !caps.Has(protocol.FeatureStream) {
+		return errStreamUnsupported
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	url := c.config.ServerURL + "/api/v1/sync/stream"
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	req.Header.Set("X-Device-ID", device)
+
+	// The stream stays open, only the idle timer below ends it
+	httpClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server error: %s", resp.Status)
+	}
+
+	logger.Info("Sync stream connected")
+	onConnect()
+
+	idle := time.AfterFunc(streamIdleTimeout, cancel)
+	defer idle.Stop()
+
+	event := ""
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		idle.Reset(streamIdleTimeout)
+		line := scanner.Text()
+
+		switch {
+		case line == "":
+			event = ""
+		case strings.HasPrefix(line, ":"):
+			// Heartbeat
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:") && event == "version":
+			var e StreamEvent
+			if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &e); err != nil {
+				logger.Warn("Invalid sync stream event", logger.F("data", line))
+				continue
+			}
+			logger.Debug("Sync stream event", logger.F("syncVersion", e.SyncVersion))
+			onChange(e)
+		}
+	}
+
+	switch {
+	case parent.Err() != nil:
+		return parent.Err()
+	case ctx.Err() != nil:
+		return fmt.Errorf("sync stream idle for %s", streamIdleTimeout)
+	case scanner.Err() != nil:
+		return scanner.Err()
+	}
+	return fmt.Errorf("sync stream closed by server")
+}
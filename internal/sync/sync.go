@@ -1,58 +1,33 @@
 package sync
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"time"
 
 	"github.com/existflow/irontask/internal/database"
 	"github.com/existflow/irontask/internal/db"
 	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/internal/protocol"
 )
 
-// SyncItem represents an item to sync
-type SyncItem struct {
-	ID               string `json:"id"`
-	ClientID         string `json:"client_id"`
-	Type             string `json:"type"` // "project" or "task"
-	Slug             string `json:"slug,omitempty"`
-	Name             string `json:"name,omitempty"`
-	ProjectID        string `json:"project_id,omitempty"`
-	EncryptedData    string `json:"encrypted_data,omitempty"`    // For projects (legacy)
-	EncryptedContent string `json:"encrypted_content,omitempty"` // For tasks (content only)
-	Status           string `json:"status,omitempty"`
-	Priority         int    `json:"priority,omitempty"`
-	DueDate          string `json:"due_date,omitempty"`
-	SyncVersion      int64  `json:"sync_version"`
-	Deleted          bool   `json:"deleted"`
-	ClientUpdatedAt  string `json:"client_updated_at,omitempty"` // Client timestamp for conflict detection
-}
+// Wire types of the sync API, shared with the server
+type (
+	SyncItem         = protocol.SyncItem
+	SyncPullResponse = protocol.SyncPullResponse
+	SyncPushResponse = protocol.SyncPushResponse
+
+	// FailedItem is an item that could not be stored, by the server on push or
+	// locally on pull. It stays unsynced and is retried by the next sync.
+	FailedItem = protocol.FailedItem
+)
 
 // ConflictItem represents a conflicting item
 type ConflictItem struct {
-	ClientID      string   `json:"client_id"`
-	Type          string   `json:"type"`
-	ServerVersion int64    `json:"server_version"`
-	ServerData    SyncItem `json:"server_data"`
-	ClientData    SyncItem `json:"client_data"`
-}
-
-// SyncPullResponse is the response from pull
-type SyncPullResponse struct {
-	Items       []SyncItem `json:"items"`
-	SyncVersion int64      `json:"sync_version"`
-}
+	protocol.ConflictItem
 
-// SyncPushResponse is the response from push
-type SyncPushResponse struct {
-	Updated   []SyncItem     `json:"updated"`
-	Conflicts []ConflictItem `json:"conflicts,omitempty"`
+	Fields []FieldConflict `json:"fields,omitempty"` // Set by the client, fields changed on both sides
 }
 
 // SyncResult holds sync statistics
@@ -60,6 +35,7 @@ type SyncResult struct {
 	Pushed    int
 	Pulled    int
 	Conflicts []ConflictItem
+	Failed    []FailedItem
 }
 
 // SyncMode defines how the sync should be performed
@@ -69,14 +45,47 @@ const (
 	SyncModeMerge         SyncMode = iota // Default: Push local, then pull remote
 	SyncModeRemoteToLocal                 // Wipe local, then pull all from remote
 	SyncModeLocalToRemote                 // Wipe remote, then push all from local
+	SyncModeRebuildLocal                  // Pull all from remote, keeping unpushed local changes
+	SyncModeReseed                        // Push all from local again, keeping the remote
 )
 
 // Sync performs sync with server based on the specified mode
 func (c *Client) Sync(database *db.DB, mode SyncMode) (*SyncResult, error) {
+	return c.SyncWithTrigger(database, mode, TriggerManual)
+}
+
+// SyncWithTrigger performs sync like Sync and records it in the sync history
+// as started by trigger. It holds the sync lock of the profile, see Lock.
+func (c *Client) SyncWithTrigger(database *db.DB, mode SyncMode, trigger SyncTrigger) (*SyncResult, error) {
+	unlock, err := c.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	run := c.startRun(database, mode, trigger)
+	result, err := c.sync(database, mode)
+	c.finishRun(database, run, result, err)
+	return result, err
+}
+
+// sync performs sync with server based on the specified mode
+func (c *Client) sync(database *db.DB, mode SyncMode) (*SyncResult, error) {
 	if !c.IsLoggedIn() {
 		return nil, fmt.Errorf("not logged in")
 	}
 
+	// Every mode encrypts or decrypts, fail early without a key
+	if _, err := c.getCrypto(); err != nil {
+		return nil, err
+	}
+	if err := c.checkServer(); err != nil {
+		return nil, err
+	}
+	if err := c.checkRemoteKey(); err != nil {
+		return nil, err
+	}
+
 	result := &SyncResult{}
 
 	switch mode {
@@ -85,45 +94,111 @@ func (c *Client) Sync(database *db.DB, mode SyncMode) (*SyncResult, error) {
 		if err := c.ClearLocal(database); err != nil {
 			return nil, fmt.Errorf("failed to clear local data: %w", err)
 		}
-		// 2. Clear last sync version to pull everything
+		// 2. Clear last sync version to pull everything, the server copy wins
 		c.config.LastSync = 0
+		c.config.ReplaceRemote = false
 		_ = c.saveConfig()
 
 		// 3. Pull remote changes
-		pulled, err := c.pullChanges(database)
-		if err != nil {
+		if _, err := c.pullChanges(database, result); err != nil {
+			return nil, fmt.Errorf("pull failed: %w", err)
+		}
+
+	case SyncModeRebuildLocal:
+		// 1. Drop the synced local copies, unpushed changes stay and are
+		// pushed by the next sync
+		c.config.LastSync = 0
+		_ = c.saveConfig()
+		if err := dropSynced(database); err != nil {
+			return nil, fmt.Errorf("failed to reset local data: %w", err)
+		}
+
+		// 2. Pull everything again
+		if _, err := c.pullChanges(database, result); err != nil {
 			return nil, fmt.Errorf("pull failed: %w", err)
 		}
-		result.Pulled = pulled
 
 	case SyncModeLocalToRemote:
-		// 1. Wipe remote data
-		if err := c.ClearRemote(); err != nil {
+		// 1. Mark every local item for upload, pushes only send unpushed
+		// rows. An interrupted run is completed by the next sync.
+		if err := markAllDirty(database); err != nil {
+			return nil, fmt.Errorf("failed to mark local data: %w", err)
+		}
+		c.config.ReplaceRemote = true
+		_ = c.saveConfig()
+
+		// 2. Wipe remote data
+		if err := c.replaceRemote(); err != nil {
 			return nil, fmt.Errorf("failed to clear remote data: %w", err)
 		}
-		// 2. Push local changes
-		pushed, conflicts, err := c.pushChanges(database)
+
+		// 3. Push everything in batches
+		if err := c.migratePlaintextBlobs(database); err != nil {
+			return nil, fmt.Errorf("failed to migrate plaintext data: %w", err)
+		}
+		pushed, err := c.pushAndMerge(database)
 		if err != nil {
 			return nil, fmt.Errorf("push failed: %w", err)
 		}
-		result.Pushed = pushed
-		result.Conflicts = conflicts
+		result = pushed
+
+	case SyncModeReseed:
+		// 1. Mark every local item for upload, pushes only send unpushed
+		// rows. Server copies changed meanwhile conflict as in a merge.
+		if err := markAllDirty(database); err != nil {
+			return nil, fmt.Errorf("failed to mark local data: %w", err)
+		}
+
+		// 2. Push everything in batches
+		if err := c.migratePlaintextBlobs(database); err != nil {
+			return nil, fmt.Errorf("failed to migrate plaintext data: %w", err)
+		}
+		pushed, err := c.pushAndMerge(database)
+		if err != nil {
+			return nil, fmt.Errorf("push failed: %w", err)
+		}
+		result = pushed
 
 	default: // SyncModeMerge
 		// 1. Push local changes
-		pushed, conflicts, err := c.pushChanges(database)
+		if err := c.migratePlaintextBlobs(database); err != nil {
+			return nil, fmt.Errorf("failed to migrate plaintext data: %w", err)
+		}
+		if err := c.replaceRemote(); err != nil {
+			return nil, fmt.Errorf("failed to clear remote data: %w", err)
+		}
+		pushed, err := c.pushAndMerge(database)
 		if err != nil {
 			return nil, fmt.Errorf("push failed: %w", err)
 		}
-		result.Pushed = pushed
-		result.Conflicts = conflicts
+		result = pushed
 
 		// 2. Pull remote changes
-		pulled, err := c.pullChanges(database)
+		reopened, err := c.pullChanges(database, result)
 		if err != nil {
 			return nil, fmt.Errorf("pull failed: %w", err)
 		}
-		result.Pulled = pulled
+
+		// 3. Push the changes a concurrent change replaced, they conflict now
+		if reopened > 0 {
+			more, err := c.pushAndMerge(database)
+			if err != nil {
+				return nil, fmt.Errorf("push failed: %w", err)
+			}
+			result.Pushed += more.Pushed
+			result.Conflicts = append(result.Conflicts, more.Conflicts...)
+			result.Failed = append(result.Failed, more.Failed...)
+		}
+	}
+
+	// Every local change was pushed, the conflicts left are all there are
+	if err := storeConflicts(database, result.Conflicts); err != nil {
+		logger.Warn("Failed to store sync conflicts", logger.F("error", err))
+	}
+
+	// Synced deletions are not needed locally anymore
+	if err := compactLocal(database); err != nil {
+		logger.Warn("Failed to purge synced deletions", logger.F("error", err))
 	}
 
 	// Mark as synced once after first successful sync
@@ -134,43 +209,86 @@ func (c *Client) Sync(database *db.DB, mode SyncMode) (*SyncResult, error) {
 	return result, nil
 }
 
+// pushedItem is the local side of a pushed item
+type pushedItem struct {
+	id        string
+	updatedAt string
+	state     itemState // Synced fields, the base of later merges once accepted
+}
+
 // pushChanges sends local changes to server
-func (c *Client) pushChanges(dbConn *db.DB) (int, []ConflictItem, error) {
+func (c *Client) pushChanges(dbConn *db.DB) (*SyncResult, error) {
 	logger.Debug("Starting push changes")
 	var items []SyncItem
+	local := make(map[string]pushedItem) // Pushed client id -> local item, differs for opaque items
 
 	// Get projects that need syncing (sync_version is NULL means dirty)
-	projects, _ := dbConn.GetProjectsToSync(context.Background())
+	projects, err := dbConn.GetProjectsToSync(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local projects: %w", err)
+	}
 
 	logger.Debug("Found projects to sync", logger.F("count", len(projects)), logger.F("lastSync", c.config.LastSync),
 		logger.F("projects", projects),
 	)
 	for _, p := range projects {
 		logger.Debug("Processing project for sync", logger.F("id", p.ID), logger.F("name", p.Name))
-		// Prepare data (including legacy color info)
 		color := ""
 		if p.Color.Valid {
 			color = p.Color.String
 		}
-		data, _ := json.Marshal(map[string]interface{}{
-			"name":  p.Name,
-			"color": color,
-		})
-
-		items = append(items, SyncItem{
+		// Name and slug travel only in the encrypted data, the server keeps
+		// the id in their place
+		item := SyncItem{
 			ClientID:        p.ID,
 			Type:            "project",
-			Slug:            p.Slug,
-			Name:            p.Name,
-			EncryptedData:   base64.StdEncoding.EncodeToString(data),
 			SyncVersion:     p.SyncVersion.Int64,
+			BaseVersion:     p.BaseVersion.Int64,
 			Deleted:         p.DeletedAt.Valid,
-			ClientUpdatedAt: p.UpdatedAt, // Send client timestamp for conflict detection
-		})
+			ClientUpdatedAt: clockTime(p.UpdatedAt),
+			Clock:           p.UpdatedAt,
+		}
+
+		fields := itemPayload{
+			ID:        p.ID,
+			Slug:      p.Slug,
+			Name:      p.Name,
+			Color:     color,
+			Archived:  p.Archived,
+			CreatedAt: p.CreatedAt,
+		}
+
+		var err error
+		if c.config.Opaque {
+			opaque := fields
+			opaque.UpdatedAt = p.UpdatedAt
+			item, err = c.sealOpaque(item, opaque)
+		} else {
+			item.EncryptedData, err = c.seal(projectPayload{
+				Slug:      p.Slug,
+				Name:      p.Name,
+				Color:     color,
+				Archived:  p.Archived,
+				CreatedAt: p.CreatedAt,
+			})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt project %s: %w", p.ID, err)
+		}
+
+		items = append(items, item)
+		local[item.ClientID] = pushedItem{
+			id:        p.ID,
+			updatedAt: p.UpdatedAt,
+			state:     itemState{Fields: fields, Deleted: item.Deleted},
+		}
 	}
 
 	// Get tasks that need syncing (sync_version is NULL means dirty)
-	tasks, _ := dbConn.GetTasksToSync(context.Background())
+	tasks, err := dbConn.GetTasksToSync(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local tasks: %w", err)
+	}
 	for _, t := range tasks {
 		dueDate := ""
 		if t.DueDate.Valid {
@@ -181,265 +299,431 @@ func (c *Client) pushChanges(dbConn *db.DB) (int, []ConflictItem, error) {
 			status = t.Status.String
 		}
 
-		// Only encrypt content
-		contentData, _ := json.Marshal(map[string]interface{}{
-			"content": t.Content,
-		})
+		item := SyncItem{
+			ClientID:        t.ID,
+			Type:            "task",
+			ProjectID:       t.ProjectID,
+			Status:          status,
+			Priority:        t.Priority,
+			DueDate:         dueDate,
+			SyncVersion:     t.SyncVersion.Int64,
+			BaseVersion:     t.BaseVersion.Int64,
+			Deleted:         t.DeletedAt.Valid,
+			ClientUpdatedAt: clockTime(t.UpdatedAt),
+			Clock:           t.UpdatedAt,
+		}
+
+		fields := itemPayload{
+			ID:        t.ID,
+			ProjectID: t.ProjectID,
+			Content:   t.Content,
+			Status:    status,
+			Priority:  t.Priority,
+			DueDate:   dueDate,
+			Tags:      t.Tags.String,
+			CreatedAt: t.CreatedAt,
+		}
 
-		items = append(items, SyncItem{
-			ClientID:         t.ID,
-			Type:             "task",
-			ProjectID:        t.ProjectID,
-			EncryptedContent: base64.StdEncoding.EncodeToString(contentData),
-			Status:           status,
-			Priority:         t.Priority,
-			DueDate:          dueDate,
-			SyncVersion:      t.SyncVersion.Int64,
-			Deleted:          t.DeletedAt.Valid,
-			ClientUpdatedAt:  t.UpdatedAt, // Send client timestamp for conflict detection
-		})
+		var err error
+		if c.config.Opaque {
+			opaque := fields
+			opaque.UpdatedAt = t.UpdatedAt
+			item, err = c.sealOpaque(item, opaque)
+		} else {
+			item.EncryptedContent, err = c.seal(taskPayload{
+				Content:   t.Content,
+				Tags:      t.Tags.String,
+				CreatedAt: t.CreatedAt,
+			})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt task %s: %w", t.ID, err)
+		}
+
+		items = append(items, item)
+		local[item.ClientID] = pushedItem{
+			id:        t.ID,
+			updatedAt: t.UpdatedAt,
+			state:     itemState{Fields: fields, Deleted: item.Deleted},
+		}
 	}
 
 	if len(items) == 0 {
 		logger.Debug("No items to push")
-		return 0, nil, nil
+		return &SyncResult{}, nil
 	}
 
-	logger.Info("Pushing changes to server", logger.F("itemCount", len(items)))
+	// Send to server in batches, each one is recorded locally as soon as the
+	// server accepted it, so an interrupted push resumes with the rest
+	_, batchSize := c.batchSizes()
+	device := deviceID(context.Background(), dbConn.Queries)
+	pushed := &SyncResult{}
+	for start := 0; start < len(items); start += batchSize {
+		batch := items[start:min(start+batchSize, len(items))]
+		logger.Info("Pushing changes to server",
+			logger.F("itemCount", len(batch)),
+			logger.F("remaining", len(items)-start))
+
+		result, err := c.backend().Push(batch, device)
+		if err != nil {
+			return pushed, err
+		}
 
-	// Send to server
-	body, _ := json.Marshal(map[string]interface{}{
-		"items": items,
-	})
+		// Map opaque pseudonyms back to local ids
+		for i, conflict := range result.Conflicts {
+			if l, ok := local[conflict.ClientID]; ok {
+				result.Conflicts[i].ClientID = l.id
+				result.Conflicts[i].ClientData.ClientID = l.id
+				result.Conflicts[i].ClientData.ClientUpdatedAt = clockTime(l.updatedAt)
+				result.Conflicts[i].ClientData.Clock = l.updatedAt
+			}
+		}
+		for i, failed := range result.Failed {
+			if l, ok := local[failed.ClientID]; ok {
+				result.Failed[i].ClientID = l.id
+			}
+			logger.Error("Server rejected item",
+				logger.F("type", failed.Type),
+				logger.F("clientID", result.Failed[i].ClientID),
+				logger.F("error", failed.Error))
+		}
 
-	url := c.config.ServerURL + "/api/v1/sync"
-	logger.Debug("HTTP Request",
-		logger.F("method", "POST"),
-		logger.F("url", url),
-		logger.F("bodySize", len(body)))
+		if err := recordPushed(dbConn, result.Updated, local); err != nil {
+			return pushed, err
+		}
+		pushed.Pushed += len(result.Updated)
+		for _, conflict := range result.Conflicts {
+			pushed.Conflicts = append(pushed.Conflicts, ConflictItem{ConflictItem: conflict})
+		}
+		pushed.Failed = append(pushed.Failed, result.Failed...)
+	}
 
-	req, _ := http.NewRequest("POST", url, bytes.NewReader(body))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	return pushed, nil
+}
 
-	resp, err := c.httpClient.Do(req)
+// recordPushed stores the server-assigned versions of accepted items in one
+// transaction
+func recordPushed(dbConn *db.DB, updated []SyncItem, local map[string]pushedItem) error {
+	ctx := context.Background()
+	tx, err := dbConn.BeginTx(ctx, nil)
 	if err != nil {
-		logger.Error("HTTP request failed", logger.F("error", err), logger.F("url", url))
-		return 0, nil, err
+		return err
 	}
 	defer func() {
-		_ = resp.Body.Close()
+		_ = tx.Rollback()
 	}()
+	q := dbConn.Queries.WithTx(tx)
 
-	logger.Debug("HTTP Response",
-		logger.F("status", resp.StatusCode),
-		logger.F("statusText", resp.Status))
-
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		logger.Error("Push failed",
-			logger.F("status", resp.StatusCode),
-			logger.F("response", string(respBody)))
-		return 0, nil, fmt.Errorf("server error: %s", string(respBody))
-	}
-
-	var result SyncPushResponse
-	_ = json.NewDecoder(resp.Body).Decode(&result)
+	for _, item := range updated {
+		l, ok := local[item.ClientID]
+		if !ok {
+			continue
+		}
+		item.ClientID = l.id
 
-	logger.Info("Push completed",
-		logger.F("updated", len(result.Updated)),
-		logger.F("conflicts", len(result.Conflicts)))
+		// The pushed version is the base of later merges
+		if err := saveBase(ctx, q, item.Type, l.id, item.SyncVersion, l.state); err != nil {
+			return fmt.Errorf("failed to save sync base of %s: %w", l.id, err)
+		}
 
-	// Update local sync_version with server-assigned values
-	ctx := context.Background()
-	for _, item := range result.Updated {
+		version := sql.NullInt64{Int64: item.SyncVersion, Valid: true}
 		if item.Type == "project" {
-			_ = dbConn.UpdateProjectSyncVersion(ctx, database.UpdateProjectSyncVersionParams{
+			err = q.UpdateProjectSyncVersion(ctx, database.UpdateProjectSyncVersionParams{
 				ID:          item.ClientID,
-				SyncVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
+				SyncVersion: version,
+				BaseVersion: version,
 			})
-			logger.Debug("Updated project sync_version", logger.F("id", item.ClientID), logger.F("version", item.SyncVersion))
-		} else if item.Type == "task" {
-			_ = dbConn.UpdateTaskSyncVersion(ctx, database.UpdateTaskSyncVersionParams{
+		} else {
+			err = q.UpdateTaskSyncVersion(ctx, database.UpdateTaskSyncVersionParams{
 				ID:          item.ClientID,
-				SyncVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
+				SyncVersion: version,
+				BaseVersion: version,
 			})
-			logger.Debug("Updated task sync_version", logger.F("id", item.ClientID), logger.F("version", item.SyncVersion))
 		}
+		if err != nil {
+			return fmt.Errorf("failed to record pushed %s %s: %w", item.Type, item.ClientID, err)
+		}
+		logger.Debug("Updated sync_version", logger.F("type", item.Type), logger.F("id", item.ClientID), logger.F("version", item.SyncVersion))
 	}
 
-	return len(result.Updated), result.Conflicts, nil
+	return tx.Commit()
 }
 
-// pullChanges gets remote changes from server
-func (c *Client) pullChanges(dbConn *db.DB) (int, error) {
-	url := fmt.Sprintf("%s/api/v1/sync?since=%d", c.config.ServerURL, c.config.LastSync)
+// pushAndMerge pushes local changes and merges conflicts field by field. Merged
+// items are based on the server version then and pushed once more right away,
+// which also reports the conflicts still left.
+func (c *Client) pushAndMerge(dbConn *db.DB) (*SyncResult, error) {
+	result, err := c.pushChanges(dbConn)
+	if err != nil {
+		return nil, err
+	}
+	conflicts, merged := c.mergeConflicts(dbConn, result.Conflicts)
+	result.Conflicts = conflicts
+	if merged == 0 {
+		return result, nil
+	}
 
-	logger.Debug("Pulling changes from server", logger.F("since", c.config.LastSync))
-	logger.Debug("HTTP Request",
-		logger.F("method", "GET"),
-		logger.F("url", url))
+	logger.Info("Pushing merged changes", logger.F("count", merged))
+	more, err := c.pushChanges(dbConn)
+	if err != nil {
+		return nil, err
+	}
+	result.Pushed += more.Pushed
+	result.Conflicts, _ = c.mergeConflicts(dbConn, more.Conflicts)
+	result.Failed = append(result.Failed, more.Failed...)
+	return result, nil
+}
 
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Authorization", "Bearer "+c.config.Token)
+// pullChanges gets remote changes from server page by page and adds them to
+// result. Each page is applied in one transaction and LastSync is saved once
+// it committed, so an interrupted pull resumes where it stopped. Items that
+// fail to apply are added to the failed ones and pulled again by the next
+// sync. It returns how many own changes were reopened for another push.
+func (c *Client) pullChanges(dbConn *db.DB, result *SyncResult) (int, error) {
+	device := deviceID(context.Background(), dbConn.Queries)
+	failed, reopened := 0, 0
+	cursor := ""
+	var pending []SyncItem // Tasks whose project comes with a later page
+	var failedFrom int64   // Lowest version of an item that failed, 0 if none did
+
+	for {
+		page, err := c.backend().Pull(c.config.LastSync, cursor, device)
+		if errors.Is(err, errResyncRequired) && cursor == "" && c.config.LastSync > 0 {
+			// Deletions we never saw are purged, start over from the server state
+			logger.Warn("Server purged deletions since last sync, pulling everything again",
+				logger.F("lastSync", c.config.LastSync))
+			if err := dropSynced(dbConn); err != nil {
+				return reopened, fmt.Errorf("failed to reset local data: %w", err)
+			}
+			c.config.LastSync = 0
+			_ = c.saveConfig()
+			continue
+		}
+		if err != nil {
+			return reopened, err
+		}
 
-	resp, err := c.httpClient.Do(req)
+		applied, err := c.applyPage(dbConn, page, pending)
+		if err != nil {
+			return reopened, err
+		}
+		pending = applied.pending
+		failed += len(applied.failed)
+		result.Failed = append(result.Failed, applied.failed...)
+		result.Pulled += len(page.Items)
+		reopened += applied.reopened
+
+		// Later pages must not move past an item that failed on an earlier one
+		if applied.failedFrom > 0 && (failedFrom == 0 || applied.failedFrom < failedFrom) {
+			failedFrom = applied.failedFrom
+		}
+		synced := applied.synced
+		if failedFrom > 0 {
+			synced = min(synced, failedFrom-1)
+		}
+		if synced > c.config.LastSync {
+			logger.Debug("Updating last sync version",
+				logger.F("old", c.config.LastSync),
+				logger.F("new", synced))
+			c.config.LastSync = synced
+			_ = c.saveConfig()
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	logger.Info("Pull completed",
+		logger.F("itemsProcessed", result.Pulled),
+		logger.F("failed", failed),
+		logger.F("reopened", reopened))
+	return reopened, nil
+}
+
+// appliedPage is the outcome of applying one pull page
+type appliedPage struct {
+	pending    []SyncItem // Tasks whose project comes with a later page
+	failed     []FailedItem
+	failedFrom int64 // Lowest version of a failed item, 0 if none failed
+	synced     int64 // Changes up to this version are applied, the next pull starts after it
+	reopened   int   // Own changes of the page's conflicts marked for another push
+}
+
+// applyPage applies one pull page and the tasks pending from earlier pages in a
+// transaction, each item in a savepoint so a failing one is rolled back alone.
+// Unreadable items abort the whole page.
+func (c *Client) applyPage(dbConn *db.DB, page *SyncPullResponse, pending []SyncItem) (*appliedPage, error) {
+	ctx := context.Background()
+
+	// Projects first, then the tasks waiting for them
+	var items, tasks []SyncItem
+	for _, item := range page.Items {
+		if item.Type == "project" {
+			items = append(items, item)
+		} else {
+			tasks = append(tasks, item)
+		}
+	}
+	items = append(append(items, pending...), tasks...)
+
+	tx, err := dbConn.BeginTx(ctx, nil)
 	if err != nil {
-		logger.Error("HTTP request failed", logger.F("error", err), logger.F("url", url))
-		return 0, err
+		return nil, err
 	}
 	defer func() {
-		_ = resp.Body.Close()
+		_ = tx.Rollback()
 	}()
+	q := dbConn.Queries.WithTx(tx)
 
-	logger.Debug("HTTP Response",
-		logger.F("status", resp.StatusCode),
-		logger.F("statusText", resp.Status))
+	result := &appliedPage{synced: page.SyncVersion}
 
-	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		logger.Error("Pull failed",
-			logger.F("status", resp.StatusCode),
-			logger.F("response", string(respBody)))
-		return 0, fmt.Errorf("server error: %s", string(respBody))
+	// Reopened changes are unpushed again, the items replacing them keep off
+	for _, conflict := range page.Conflicts {
+		ok, err := c.reopen(ctx, q, conflict)
+		if err != nil {
+			return nil, fmt.Errorf("cannot reopen %s %s: %w", conflict.Type, conflict.ClientID, err)
+		}
+		if ok {
+			result.reopened++
+		}
 	}
 
-	var result SyncPullResponse
-	_ = json.NewDecoder(resp.Body).Decode(&result)
-
-	logger.Info("Received items from server",
-		logger.F("itemCount", len(result.Items)),
-		logger.F("syncVersion", result.SyncVersion))
-
-	// Apply remote changes
-	ctx := context.Background()
-	for _, item := range result.Items {
+	for _, item := range items {
 		logger.Debug("Processing sync item",
 			logger.F("type", item.Type),
 			logger.F("clientID", item.ClientID),
 			logger.F("deleted", item.Deleted))
 
-		switch item.Type {
-		case "project":
-			// Use name directly from item, encrypted_data contains legacy color info
-			name := item.Name
-			color := "#4ECDC4"
-			slug := item.Slug
-			if slug == "" {
-				slug = item.ClientID // Fallback for old data
-			}
-			if name == "" {
-				// Fallback: try to parse from encrypted data
-				data, err := base64.StdEncoding.DecodeString(item.EncryptedData)
-				if err == nil {
-					var p struct {
-						Name  string `json:"name"`
-						Color string `json:"color"`
-					}
-					_ = json.Unmarshal(data, &p)
-					name = p.Name
-					if p.Color != "" {
-						color = p.Color
-					}
-				}
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT apply_item"); err != nil {
+			return nil, err
+		}
+		err := c.applyItem(ctx, q, item, false)
+		if err != nil {
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT apply_item"); err != nil {
+				return nil, err
 			}
+		}
+		if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT apply_item"); err != nil {
+			return nil, err
+		}
 
-			// Upsert project with server sync_version
-			_, err := dbConn.GetProject(ctx, item.ClientID)
-			if err != nil {
-				// Not found, create
-				logger.Debug("Creating project from sync", logger.F("id", item.ClientID), logger.F("name", name), logger.F("syncVersion", item.SyncVersion))
-				_ = dbConn.CreateProject(ctx, database.CreateProjectParams{
-					ID:        item.ClientID,
-					Slug:      slug,
-					Name:      name,
-					Color:     sql.NullString{String: color, Valid: true},
-					CreatedAt: time.Now().Format(time.RFC3339),
-					UpdatedAt: time.Now().Format(time.RFC3339),
-				})
-				// Set sync_version from server
-				_ = dbConn.UpdateProjectSyncVersion(ctx, database.UpdateProjectSyncVersionParams{
-					ID:          item.ClientID,
-					SyncVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
-				})
-			} else {
-				// Exists, update with server data and sync_version
-				logger.Debug("Updating project from sync", logger.F("id", item.ClientID), logger.F("name", name), logger.F("syncVersion", item.SyncVersion))
-				_ = dbConn.OverwriteProject(ctx, database.OverwriteProjectParams{
-					ID:          item.ClientID,
-					Slug:        slug,
-					Name:        name,
-					Color:       sql.NullString{String: color, Valid: true},
-					UpdatedAt:   time.Now().Format(time.RFC3339),
-					SyncVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
-				})
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, ErrDecryptionFailed) || errors.Is(err, ErrUnknownKey) || errors.Is(err, ErrNoEncryptionKey):
+			// Never advance past data we cannot read
+			return nil, fmt.Errorf("cannot decrypt %s %s: %w", item.Type, item.ClientID, err)
+		case errors.Is(err, errMissingProject) && page.NextCursor != "":
+			result.pending = append(result.pending, item)
+		default:
+			logger.Error("Failed to apply sync item",
+				logger.F("type", item.Type),
+				logger.F("clientID", item.ClientID),
+				logger.F("error", err))
+			result.failed = append(result.failed, FailedItem{ClientID: item.ClientID, Type: item.Type, Error: err.Error()})
+			if result.failedFrom == 0 || item.SyncVersion < result.failedFrom {
+				result.failedFrom = item.SyncVersion
 			}
+		}
 
-		case "task":
-			// Decrypt content
-			content := ""
-			if item.EncryptedContent != "" {
-				data, err := base64.StdEncoding.DecodeString(item.EncryptedContent)
-				if err == nil {
-					var c struct {
-						Content string `json:"content"`
-					}
-					_ = json.Unmarshal(data, &c)
-					content = c.Content
-				}
-			}
+		// Resume before items still pending or failed
+		result.synced = min(result.synced, item.SyncVersion-1)
+	}
 
-			status := item.Status
-			if status == "" {
-				status = "process"
-			}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit pulled changes: %w", err)
+	}
+	return result, nil
+}
 
-			// Upsert task with server sync_version
-			tExisting, err := dbConn.GetTask(ctx, item.ClientID)
-			if err != nil {
-				// Create
-				logger.Debug("Creating task from sync", logger.F("id", item.ClientID), logger.F("content", content), logger.F("syncVersion", item.SyncVersion))
-				_ = dbConn.CreateTask(ctx, database.CreateTaskParams{
-					ID:        item.ClientID,
-					ProjectID: item.ProjectID,
-					Content:   content,
-					Status:    sql.NullString{String: status, Valid: true},
-					Priority:  item.Priority,
-					DueDate:   sql.NullString{String: item.DueDate, Valid: item.DueDate != ""},
-					CreatedAt: time.Now().Format(time.RFC3339),
-					UpdatedAt: time.Now().Format(time.RFC3339),
-				})
-				// Set sync_version from server
-				_ = dbConn.UpdateTaskSyncVersion(ctx, database.UpdateTaskSyncVersionParams{
-					ID:          item.ClientID,
-					SyncVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
-				})
-			} else {
-				// Exists, update with server data and sync_version using OverwriteTask
-				logger.Debug("Updating task from sync", logger.F("id", item.ClientID), logger.F("content", content), logger.F("syncVersion", item.SyncVersion))
-				_ = dbConn.OverwriteTask(ctx, database.OverwriteTaskParams{
-					ID:          tExisting.ID,
-					ProjectID:   item.ProjectID,
-					Content:     content,
-					Status:      sql.NullString{String: status, Valid: true},
-					Priority:    item.Priority,
-					DueDate:     sql.NullString{String: item.DueDate, Valid: item.DueDate != ""},
-					UpdatedAt:   time.Now().Format(time.RFC3339),
-					SyncVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
-				})
-			}
-		}
+// reopen marks a pushed change that a concurrent change replaced for another
+// push, which then reports the conflict. The common base of both changes
+// replaces the snapshot of the pushed one, merges compare against it. It
+// returns false if the local row changed since it was pushed.
+func (c *Client) reopen(ctx context.Context, q *database.Queries, conflict protocol.ConflictItem) (bool, error) {
+	item := conflict.ClientData
+	p, err := c.openItem(item)
+	if err != nil {
+		return false, err
+	}
+	version := sql.NullInt64{Int64: item.SyncVersion, Valid: true}
+
+	var synced sql.NullInt64
+	if item.Type == "project" {
+		var row database.Project
+		row, err = q.GetProjectForSync(ctx, p.ID)
+		synced = row.SyncVersion
+	} else {
+		var row database.Task
+		row, err = q.GetTaskForSync(ctx, p.ID)
+		synced = row.SyncVersion
+	}
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && synced != version) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
 	}
 
-	// Update last sync version
-	if result.SyncVersion > c.config.LastSync {
-		logger.Debug("Updating last sync version",
-			logger.F("old", c.config.LastSync),
-			logger.F("new", result.SyncVersion))
-		c.config.LastSync = result.SyncVersion
-		_ = c.saveConfig()
+	if item.Type == "project" {
+		err = q.RebaseProject(ctx, database.RebaseProjectParams{BaseVersion: version, ID: p.ID})
+	} else {
+		err = q.RebaseTask(ctx, database.RebaseTaskParams{BaseVersion: version, ID: p.ID})
+	}
+	if err != nil {
+		return false, err
 	}
 
-	logger.Info("Pull completed", logger.F("itemsProcessed", len(result.Items)))
-	return len(result.Items), nil
+	if conflict.BaseData == nil {
+		return true, q.DeleteSyncBase(ctx, database.DeleteSyncBaseParams{ItemType: item.Type, ItemID: p.ID})
+	}
+	base, err := c.itemState(*conflict.BaseData)
+	if err != nil {
+		return false, err
+	}
+	return true, saveBase(ctx, q, item.Type, p.ID, item.SyncVersion, base)
+}
+
+// migratePlaintextBlobs re-uploads everything once after upgrading from a version
+// that pushed unencrypted blobs.
+func (c *Client) migratePlaintextBlobs(dbConn *db.DB) error {
+	if c.config.BlobsMigrated {
+		return nil
+	}
+
+	logger.Info("Marking synced items for encrypted re-upload")
+	if err := markAllDirty(dbConn); err != nil {
+		return err
+	}
+
+	c.config.BlobsMigrated = true
+	return c.saveConfig()
+}
+
+// replaceRemote wipes the server copy ahead of a pending full re-upload, which
+// is needed when the pushed ids changed (see SetOpaque). Every local row is
+// already marked dirty at that point.
+func (c *Client) replaceRemote() error {
+	if !c.config.ReplaceRemote {
+		return nil
+	}
+
+	logger.Info("Replacing server copy with re-encrypted data")
+	if err := c.ClearRemote(); err != nil {
+		return err
+	}
+	c.config.ReplaceRemote = false
+	return c.saveConfig()
+}
+
+// markAllDirty marks every synced row for re-upload. Their edit times stay,
+// the base version is what makes the server take them.
+func markAllDirty(dbConn *db.DB) error {
+	ctx := context.Background()
+	if err := dbConn.MarkProjectsDirty(ctx); err != nil {
+		return err
+	}
+	return dbConn.MarkTasksDirty(ctx)
 }
@@ -0,0 +1,243 @@
+package sync
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/existflow/irontask/internal/database"
+	"github.com/existflow/irontask/internal/db"
+	"github.com/existflow/irontask/internal/digest"
+	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/internal/protocol"
+)
+
+// SyncDigestResponse summarizes the live items on the server, with the items
+// of the requested buckets
+type SyncDigestResponse = protocol.SyncDigestResponse
+
+// VerifyItem is an item this device and the server disagree on
+type VerifyItem struct {
+	Type          string
+	ID            string
+	Name          string // Project name or task content, if it could be read
+	LocalVersion  int64  // 0 if the item is missing locally
+	ServerVersion int64  // 0 if the item is missing on the server
+
+	item SyncItem // Server copy, for repairs
+}
+
+// VerifyReport is the result of comparing local data with the server
+type VerifyReport struct {
+	Checked    int          // Live items on the server
+	Pending    int          // Unpushed local changes, not compared
+	Missing    []VerifyItem // On the server only
+	Extra      []VerifyItem // On this device only
+	Mismatched []VerifyItem // At different versions on both sides
+}
+
+// Divergent returns the number of items to repair
+func (r *VerifyReport) Divergent() int {
+	return len(r.Missing) + len(r.Extra) + len(r.Mismatched)
+}
+
+// localDigestItem is a synced local item, by its client id on the server
+type localDigestItem struct {
+	itemType string
+	id       string
+	version  int64
+}
+
+// Verify compares the synced local items with the server. Bucket digests are
+// compared first, only the items of differing buckets are fetched.
+func (c *Client) Verify(dbConn *db.DB) (*VerifyReport, error) {
+	if !c.IsLoggedIn() {
+		return nil, fmt.Errorf("not logged in")
+	}
+	crypto, err := c.getCrypto()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkRemoteKey(); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	rows, err := dbConn.ListSyncDigestItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{}
+	local := make(map[string]localDigestItem)
+	pending := make(map[string]bool)
+	var entries []digest.Entry
+	for _, row := range rows {
+		clientID := row.ID
+		if c.config.Opaque {
+			clientID = crypto.Pseudonym(row.ItemType + ":" + row.ID)
+		}
+		key := row.ItemType + ":" + clientID
+		if !row.SyncVersion.Valid {
+			// The server copy is outdated until the change is pushed
+			pending[key] = true
+			report.Pending++
+			continue
+		}
+		local[key] = localDigestItem{itemType: row.ItemType, id: row.ID, version: row.SyncVersion.Int64}
+		entries = append(entries, digest.Entry{Type: row.ItemType, ClientID: clientID, SyncVersion: row.SyncVersion.Int64})
+	}
+
+	remote, err := c.backend().Digest(nil)
+	if err != nil {
+		return nil, err
+	}
+	report.Checked = remote.Count
+
+	sums := digest.Sum(entries)
+	if len(remote.Buckets) != len(sums) {
+		return nil, fmt.Errorf("server digest has %d buckets, expected %d", len(remote.Buckets), len(sums))
+	}
+	var differing []int
+	for i := range sums {
+		if sums[i] != remote.Buckets[i] {
+			differing = append(differing, i)
+		}
+	}
+	logger.Info("Compared sync digests",
+		logger.F("local", len(entries)),
+		logger.F("server", remote.Count),
+		logger.F("differingBuckets", len(differing)))
+	if len(differing) == 0 {
+		return report, nil
+	}
+
+	remote, err = c.backend().Digest(differing)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, item := range remote.Items {
+		key := item.Type + ":" + item.ClientID
+		seen[key] = true
+		l, ok := local[key]
+		if pending[key] || (ok && l.version == item.SyncVersion) {
+			continue
+		}
+
+		plan := c.planItem(item)
+		v := VerifyItem{Type: item.Type, ID: plan.ID, Name: plan.Name, LocalVersion: l.version, ServerVersion: item.SyncVersion, item: item}
+		if ok {
+			report.Mismatched = append(report.Mismatched, v)
+		} else {
+			report.Missing = append(report.Missing, v)
+		}
+	}
+
+	inDiffering := make(map[int]bool)
+	for _, b := range differing {
+		inDiffering[b] = true
+	}
+	for key, l := range local {
+		clientID := key[len(l.itemType)+1:]
+		if seen[key] || !inDiffering[digest.Bucket(l.itemType, clientID)] {
+			continue
+		}
+		report.Extra = append(report.Extra, VerifyItem{
+			Type:         l.itemType,
+			ID:           l.id,
+			Name:         localName(ctx, dbConn.Queries, l.itemType, l.id),
+			LocalVersion: l.version,
+		})
+	}
+
+	for _, list := range [][]VerifyItem{report.Missing, report.Extra, report.Mismatched} {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Type != list[j].Type {
+				return list[i].Type < list[j].Type
+			}
+			return list[i].ID < list[j].ID
+		})
+	}
+	return report, nil
+}
+
+// Repair fixes the items of a report. Missing and outdated local items are
+// replaced with the server copy, items the server lacks or holds at an older
+// version are pushed again by a sync, whose result is returned.
+func (c *Client) Repair(dbConn *db.DB, report *VerifyReport) (*SyncResult, error) {
+	ctx := context.Background()
+	var pull []VerifyItem
+	var push []VerifyItem
+	pull = append(pull, report.Missing...)
+	push = append(push, report.Extra...)
+	for _, v := range report.Mismatched {
+		if v.ServerVersion > v.LocalVersion {
+			pull = append(pull, v)
+		} else {
+			push = append(push, v)
+		}
+	}
+	// Projects first, tasks refer to them
+	sort.SliceStable(pull, func(i, j int) bool {
+		return pull[i].Type == "project" && pull[j].Type != "project"
+	})
+
+	tx, err := dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	q := dbConn.Queries.WithTx(tx)
+
+	for _, v := range pull {
+		if err := c.applyItem(ctx, q, v.item, true); err != nil {
+			return nil, fmt.Errorf("failed to restore %s %s: %w", v.Type, v.ID, err)
+		}
+	}
+	for _, v := range push {
+		// Based on the server version, a newer change there is a conflict
+		base := v.ServerVersion
+		if base == 0 {
+			base = v.LocalVersion
+		}
+		var err error
+		if v.Type == "project" {
+			err = q.RebaseProject(ctx, database.RebaseProjectParams{BaseVersion: sql.NullInt64{Int64: base, Valid: true}, ID: v.ID})
+		} else {
+			err = q.RebaseTask(ctx, database.RebaseTaskParams{BaseVersion: sql.NullInt64{Int64: base, Valid: true}, ID: v.ID})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to mark %s %s for push: %w", v.Type, v.ID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	logger.Info("Repaired divergent items", logger.F("pulled", len(pull)), logger.F("push", len(push)))
+	result, err := c.SyncWithTrigger(dbConn, SyncModeMerge, TriggerRepair)
+	if err != nil {
+		return nil, err
+	}
+	result.Pulled += len(pull)
+	return result, nil
+}
+
+// localName returns the name of a project or the content of a task
+func localName(ctx context.Context, q *database.Queries, itemType, id string) string {
+	if itemType == "project" {
+		if p, err := q.GetProject(ctx, id); err == nil {
+			return p.Name
+		}
+		return ""
+	}
+	if t, err := q.GetTask(ctx, id); err == nil {
+		return t.Content
+	}
+	return ""
+}
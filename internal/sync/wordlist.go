@@ -0,0 +1,38 @@
+package sync
+
+// recoveryWords encodes one byte of a recovery phrase per word. The list is
+// part of the phrase format and must never be reordered or changed.
+var recoveryWords = [256]string{
+	"acid", "acorn", "actor", "agent", "alarm", "album", "alert", "alley",
+	"alpha", "anchor", "angle", "apple", "apron", "arena", "armor", "arrow",
+	"atlas", "attic", "autumn", "avocado", "award", "bacon", "badge", "bagel",
+	"baker", "bamboo", "banjo", "basil", "basket", "beach", "bell", "bench",
+	"berry", "bicycle", "binder", "birch", "biscuit", "blade", "blanket", "border",
+	"bottle", "boulder", "bracket", "branch", "brick", "broom", "bubble", "bucket",
+	"buffalo", "bugle", "bunker", "button", "cabin", "cactus", "camel", "candle",
+	"canoe", "canyon", "carbon", "carpet", "carrot", "castle", "cedar", "cellar",
+	"cement", "cherry", "chess", "chimney", "cider", "circus", "citrus", "cliff",
+	"clock", "clover", "cobalt", "coconut", "compass", "copper", "cotton", "cradle",
+	"crater", "crayon", "cricket", "crystal", "daisy", "delta", "denim", "desert",
+	"diamond", "dinner", "dolphin", "donkey", "dragon", "drawer", "drum", "eagle",
+	"easel", "eclipse", "elbow", "ember", "emerald", "engine", "falcon", "fence",
+	"ferry", "fiddle", "finch", "flame", "fossil", "fox", "galaxy", "garden",
+	"garlic", "ginger", "giraffe", "glacier", "globe", "granite", "gravel", "hammer",
+	"harbor", "harvest", "hazel", "hiking", "honey", "hornet", "iceberg", "indigo",
+	"iris", "jacket", "jaguar", "jasmine", "jelly", "jigsaw", "jungle", "kayak",
+	"kettle", "kiwi", "koala", "ladder", "lagoon", "laptop", "lemon", "lentil",
+	"library", "lilac", "linen", "lizard", "lobster", "locket", "lotus", "lumber",
+	"mango", "maple", "marble", "meadow", "melon", "meteor", "mirror", "mitten",
+	"monsoon", "mosaic", "muffin", "mustard", "nectar", "needle", "nickel", "nugget",
+	"nutmeg", "ocean", "olive", "onion", "orbit", "orchid", "oyster", "paddle",
+	"palace", "panda", "parrot", "peach", "pebble", "pelican", "pepper", "piano",
+	"pickle", "pillow", "pilot", "planet", "plaza", "plum", "pocket", "pond",
+	"poppy", "prairie", "pumpkin", "puzzle", "quartz", "quill", "rabbit", "radar",
+	"radish", "raven", "reef", "ribbon", "robot", "rocket", "salmon", "sandal",
+	"satin", "scarf", "shadow", "shell", "signal", "silver", "sketch", "sleigh",
+	"slipper", "snail", "spider", "statue", "summit", "sunset", "swan", "tablet",
+	"teapot", "temple", "thimble", "thunder", "ticket", "tiger", "timber", "tomato",
+	"topaz", "torch", "tractor", "trumpet", "turtle", "unicorn", "valley", "velvet",
+	"violin", "volcano", "wafer", "wagon", "walnut", "walrus", "wheat", "willow",
+	"window", "winter", "wizard", "yacht", "yogurt", "zebra", "zenith", "zipper",
+}
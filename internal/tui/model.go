@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/existflow/irontask/internal/daemon"
 	"github.com/existflow/irontask/internal/database"
 	"github.com/existflow/irontask/internal/db"
 	"github.com/existflow/irontask/internal/logger"
@@ -33,6 +34,16 @@ const (
 	ModeConflict
 )
 
+// backgroundSync syncs while the TUI runs, an AutoSync of its own or the
+// daemon when one is running
+type backgroundSync interface {
+	TriggerSync()
+	IsPending() bool
+	IsOffline() bool
+	GetLastError() error
+	Status() sync.Status
+}
+
 // Model is the main TUI model
 type Model struct {
 	db       *db.DB
@@ -42,10 +53,12 @@ type Model struct {
 
 	// Sync
 	syncClient       *sync.Client
-	autoSync         *sync.AutoSync
+	autoSync         backgroundSync
 	syncRefreshChan  chan struct{}            // Channel to trigger UI refresh on remote sync
 	syncConflictChan chan []sync.ConflictItem // Channel to receive conflicts
 	conflicts        []sync.ConflictItem      // Current conflicts to resolve
+	conflictField    int                      // Selected conflicting field of the first conflict
+	keepLocal        map[string]bool          // Fields of the first conflict that keep the local value
 
 	// UI state
 	width      int
@@ -94,29 +107,39 @@ func NewModel(database *db.DB) Model {
 	if err == nil && sClient.IsLoggedIn() {
 		logger.Info("Sync client initialized and logged in")
 		m.syncClient = sClient
-		m.autoSync = sync.NewAutoSync(sClient, database)
 
-		// Set callback to signal UI refresh when remote changes are pulled
-		m.autoSync.SetOnPull(func() {
+		// Signal UI refresh when remote changes are pulled
+		onPull := func() {
 			logger.Debug("Auto-sync pull callback triggered")
 			// Non-blocking send to trigger UI refresh
 			select {
 			case m.syncRefreshChan <- struct{}{}:
 			default:
 			}
-		})
+		}
 
-		// Set callback to signal UI when conflicts occur
-		m.autoSync.SetOnConflict(func(conflicts []sync.ConflictItem) {
+		// Signal UI when conflicts occur
+		onConflict := func(conflicts []sync.ConflictItem) {
 			logger.Info("Auto-sync conflict callback triggered")
 			select {
 			case m.syncConflictChan <- conflicts:
 			default:
 			}
-		})
-
-		// Trigger initial sync
-		m.autoSync.TriggerSync()
+		}
+
+		if daemon.Running() {
+			// The daemon syncs, two syncs at once would race
+			logger.Info("Sync daemon running, leaving sync to it")
+			m.autoSync = daemon.Follow(database, onPull, onConflict)
+		} else {
+			autoSync := sync.NewAutoSync(sClient, database)
+			autoSync.SetOnPull(onPull)
+			autoSync.SetOnConflict(onConflict)
+			m.autoSync = autoSync
+
+			// Trigger initial sync
+			autoSync.TriggerSync()
+		}
 	} else if err != nil {
 		logger.Debug("Sync client not initialized", logger.F("error", err))
 	} else {
@@ -3,8 +3,6 @@ package tui
 import (
 	"context"
 	"database/sql"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -90,6 +88,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case conflictMsg:
 		m.conflicts = msg.conflicts
+		m.resetConflictChoices()
 		if len(m.conflicts) > 0 {
 			m.mode = ModeConflict
 			m.message = fmt.Sprintf("Conflict detected! (%d items)", len(m.conflicts))
@@ -249,7 +248,7 @@ func (m *Model) handlePriority(key string) {
 				Priority:  priority,
 				DueDate:   task.DueDate,
 				Tags:      task.Tags,
-				UpdatedAt: time.Now().Format(time.RFC3339),
+				UpdatedAt: sync.Now(m.db),
 			})
 			if m.autoSync != nil {
 				m.autoSync.TriggerSync()
@@ -287,7 +286,7 @@ func (m *Model) handleToggleDone() {
 			_ = m.db.UpdateTaskStatus(context.Background(), database.UpdateTaskStatusParams{
 				ID:        task.ID,
 				Status:    sql.NullString{String: newStatus, Valid: true},
-				UpdatedAt: time.Now().Format(time.RFC3339),
+				UpdatedAt: sync.Now(m.db),
 			})
 
 			if newStatus == "done" {
@@ -307,10 +306,11 @@ func (m *Model) handleDelete() {
 	if m.pane == PaneTaskList && len(m.tasks) > 0 {
 		task := m.currentTask()
 		if task != nil {
+			now := sync.Now(m.db)
 			_ = m.db.DeleteTask(context.Background(), database.DeleteTaskParams{
 				ID:        task.ID,
-				DeletedAt: sql.NullString{String: time.Now().Format(time.RFC3339), Valid: true},
-				UpdatedAt: time.Now().Format(time.RFC3339),
+				DeletedAt: sql.NullString{String: now, Valid: true},
+				UpdatedAt: now,
 			})
 			if m.autoSync != nil {
 				m.autoSync.TriggerSync()
@@ -367,7 +367,12 @@ func (m *Model) handlePrevMatch() {
 
 func (m *Model) handleLogout() {
 	if m.syncClient != nil {
-		if err := m.syncClient.Logout(); err != nil {
+		unlock, err := m.syncClient.Lock()
+		if err == nil {
+			err = m.syncClient.Logout()
+			unlock()
+		}
+		if err != nil {
 			m.message = fmt.Sprintf("Logout error: %v", err)
 		} else {
 			m.syncClient = nil
@@ -404,7 +409,7 @@ func (m Model) updateInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		case ModeAddTask:
 			proj := m.currentProject()
 			if proj != nil {
-				now := time.Now().Format(time.RFC3339)
+				now := sync.Now(m.db)
 				err := m.db.CreateTask(context.Background(), database.CreateTaskParams{
 					ID:        uuid.New().String(),
 					ProjectID: proj.ID,
@@ -424,7 +429,7 @@ func (m Model) updateInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				}
 			}
 		case ModeAddProject:
-			now := time.Now().Format(time.RFC3339)
+			now := sync.Now(m.db)
 			// Generate slug from name (lowercase, replace spaces with dashes)
 			slug := strings.ToLower(strings.ReplaceAll(value, " ", "-"))
 			err := m.db.CreateProject(context.Background(), database.CreateProjectParams{
@@ -455,7 +460,7 @@ func (m Model) updateInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 					Priority:  task.Priority,
 					DueDate:   task.DueDate,
 					Tags:      task.Tags,
-					UpdatedAt: time.Now().Format(time.RFC3339),
+					UpdatedAt: sync.Now(m.db),
 				})
 				if m.autoSync != nil {
 					m.autoSync.TriggerSync()
@@ -557,15 +562,39 @@ func (m *Model) handleConflictKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.mode = ModeNormal
 		return m, nil
 	}
+	fields := m.conflicts[0].Fields
 
 	switch msg.String() {
-	case "l", "L": // Keep Local
+	case "up", "k":
+		if m.conflictField > 0 {
+			m.conflictField--
+		}
+	case "down", "j":
+		if m.conflictField < len(fields)-1 {
+			m.conflictField++
+		}
+	case "l": // Local value for the selected field
+		if len(fields) > 0 {
+			m.keepLocal[fields[m.conflictField].Field] = true
+		}
+	case "s": // Server value for the selected field
+		if len(fields) > 0 {
+			m.keepLocal[fields[m.conflictField].Field] = false
+		}
+	case "enter":
+		if len(fields) > 0 {
+			m.resolveConflictFields()
+		}
+	case "L": // Keep Local
 		m.resolveConflict(true)
-	case "s", "S": // Keep Server
+	case "S": // Keep Server
 		m.resolveConflict(false)
 	case "q", "esc":
 		m.mode = ModeNormal
-		// Clear conflicts if ignored
+		// The conflicts stay stored until resolved
+		if len(m.conflicts) > 0 {
+			m.message = fmt.Sprintf("%d conflicts kept, resolve later with 'irontask sync conflicts'", len(m.conflicts))
+		}
 		m.conflicts = nil
 		return m, nil
 	}
@@ -579,156 +608,57 @@ func (m *Model) resolveConflict(keepLocal bool) {
 	conflict := m.conflicts[0]
 
 	if keepLocal {
-		// Update local timestamp to force push next time
-		ctx := context.Background()
-		now := time.Now().Format(time.RFC3339)
-
-		if conflict.Type == "task" {
-			t, err := m.db.GetTask(ctx, conflict.ClientID)
-			if err == nil {
-				_ = m.db.UpdateTask(ctx, database.UpdateTaskParams{
-					ID:        t.ID,
-					ProjectID: t.ProjectID,
-					Content:   t.Content,
-					Status:    t.Status,
-					Priority:  t.Priority,
-					DueDate:   t.DueDate,
-					Tags:      t.Tags,
-					UpdatedAt: now,
-				})
-			}
-		} else if conflict.Type == "project" {
-			p, err := m.db.GetProject(ctx, conflict.ClientID)
-			if err == nil {
-				_ = m.db.UpdateProject(ctx, database.UpdateProjectParams{
-					ID:        p.ID,
-					Slug:      p.Slug,
-					Name:      p.Name,
-					Color:     p.Color,
-					UpdatedAt: now,
-				})
-			}
+		// Base the local version on the server's so the next push wins
+		if err := m.syncClient.KeepLocal(m.db, conflict); err != nil {
+			m.message = fmt.Sprintf("Failed to keep local version: %v", err)
+			return
 		}
 		m.message = "Keeping local version (will resync)"
 	} else {
-		// Apply server version
-		m.applyServerItem(conflict.ServerData)
+		if err := m.syncClient.Resolve(m.db, conflict, sync.ResolveServer); err != nil {
+			m.message = fmt.Sprintf("Failed to apply server version: %v", err)
+			return
+		}
 		m.message = "Applied server version"
 	}
 
-	// Remove resolved conflict
+	m.nextConflict(keepLocal)
+}
+
+// resolveConflictFields resolves the first conflict with the chosen value of
+// each conflicting field
+func (m *Model) resolveConflictFields() {
+	conflict := m.conflicts[0]
+	if err := m.syncClient.ResolveConflict(m.db, conflict, m.keepLocal); err != nil {
+		m.message = fmt.Sprintf("Failed to resolve conflict: %v", err)
+		return
+	}
+	m.message = "Merged conflicting fields (will resync)"
+
+	anyLocal := false
+	for _, local := range m.keepLocal {
+		anyLocal = anyLocal || local
+	}
+	m.nextConflict(anyLocal)
+}
+
+// nextConflict removes the resolved conflict, push syncs the resolution once
+// the last one is done
+func (m *Model) nextConflict(push bool) {
 	m.conflicts = m.conflicts[1:]
+	m.resetConflictChoices()
 	if len(m.conflicts) == 0 {
 		m.mode = ModeNormal
 		// Trigger sync if we kept local (to push the forced update)
-		if keepLocal && m.autoSync != nil {
+		if push && m.autoSync != nil {
 			m.autoSync.TriggerSync()
 		}
 		m.loadData()
 	}
 }
 
-func (m *Model) applyServerItem(item sync.SyncItem) {
-	ctx := context.Background()
-
-	switch item.Type {
-	case "project":
-		name := item.Name
-		color := "#4ECDC4"
-		slug := item.Slug
-		if slug == "" {
-			slug = item.ClientID
-		}
-		if name == "" {
-			data, err := base64.StdEncoding.DecodeString(item.EncryptedData)
-			if err == nil {
-				var p struct {
-					Name  string `json:"name"`
-					Color string `json:"color"`
-				}
-				_ = json.Unmarshal(data, &p)
-				name = p.Name
-				if p.Color != "" {
-					color = p.Color
-				}
-			}
-		}
-
-		// Upsert project with server sync_version
-		_, err := m.db.GetProject(ctx, item.ClientID)
-		if err != nil {
-			_ = m.db.CreateProject(ctx, database.CreateProjectParams{
-				ID:        item.ClientID,
-				Slug:      slug,
-				Name:      name,
-				Color:     sql.NullString{String: color, Valid: true},
-				CreatedAt: time.Now().Format(time.RFC3339),
-				UpdatedAt: time.Now().Format(time.RFC3339),
-			})
-			// Set sync_version from server
-			_ = m.db.UpdateProjectSyncVersion(ctx, database.UpdateProjectSyncVersionParams{
-				ID:          item.ClientID,
-				SyncVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
-			})
-		} else {
-			_ = m.db.OverwriteProject(ctx, database.OverwriteProjectParams{
-				ID:          item.ClientID,
-				Slug:        slug,
-				Name:        name,
-				Color:       sql.NullString{String: color, Valid: true},
-				UpdatedAt:   time.Now().Format(time.RFC3339),
-				SyncVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
-			})
-		}
-
-	case "task":
-		content := ""
-		if item.EncryptedContent != "" {
-			data, err := base64.StdEncoding.DecodeString(item.EncryptedContent)
-			if err == nil {
-				var c struct {
-					Content string `json:"content"`
-				}
-				_ = json.Unmarshal(data, &c)
-				content = c.Content
-			}
-		}
-
-		status := item.Status
-		if status == "" {
-			status = "process"
-		}
-
-		// Upsert task with server sync_version
-		_, err := m.db.GetTask(ctx, item.ClientID)
-		if err != nil {
-			_ = m.db.CreateTask(ctx, database.CreateTaskParams{
-				ID:        item.ClientID,
-				ProjectID: item.ProjectID,
-				Content:   content,
-				Status:    sql.NullString{String: status, Valid: true},
-				Priority:  item.Priority,
-				DueDate:   sql.NullString{String: item.DueDate, Valid: item.DueDate != ""},
-				CreatedAt: time.Now().Format(time.RFC3339),
-				UpdatedAt: time.Now().Format(time.RFC3339),
-			})
-			// Set sync_version from server
-			_ = m.db.UpdateTaskSyncVersion(ctx, database.UpdateTaskSyncVersionParams{
-				ID:          item.ClientID,
-				SyncVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
-			})
-		} else {
-			_ = m.db.OverwriteTask(ctx, database.OverwriteTaskParams{
-				ID:          item.ClientID,
-				ProjectID:   item.ProjectID,
-				Content:     content,
-				Status:      sql.NullString{String: status, Valid: true},
-				Priority:    item.Priority,
-				DueDate:     sql.NullString{String: item.DueDate, Valid: item.DueDate != ""},
-				Tags:        sql.NullString{Valid: false}, // Empty tags for now
-				UpdatedAt:   time.Now().Format(time.RFC3339),
-				SyncVersion: sql.NullInt64{Int64: item.SyncVersion, Valid: true},
-			})
-		}
-	}
+// resetConflictChoices selects the server value of every field of the next conflict
+func (m *Model) resetConflictChoices() {
+	m.conflictField = 0
+	m.keepLocal = make(map[string]bool)
 }
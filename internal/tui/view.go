@@ -2,14 +2,14 @@ package tui
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/existflow/irontask/internal/database"
+	"github.com/existflow/irontask/internal/sync"
 )
 
 // View renders the UI
@@ -192,12 +192,18 @@ func (m Model) renderStatusBar() string {
 	// Append sync status (right aligned)
 	syncMsg := ""
 	if m.autoSync != nil {
-		if m.autoSync.IsPending() {
+		if m.autoSync.IsOffline() {
+			syncMsg = m.autoSync.Status().String()
+		} else if m.autoSync.IsPending() {
 			syncMsg = "Syncing..."
 		} else if err := m.autoSync.GetLastError(); err != nil {
 			syncMsg = "Sync Error!"
 			if strings.Contains(err.Error(), "401") || strings.Contains(err.Error(), "Unauthorized") {
 				syncMsg = "Auth Error (press L)"
+			} else if errors.Is(err, sync.ErrNoEncryptionKey) {
+				syncMsg = "No Key (irontask sync key)"
+			} else if errors.Is(err, sync.ErrKeyRotated) {
+				syncMsg = "Key Rotated (irontask sync key)"
 			}
 		}
 	}
@@ -327,41 +333,24 @@ func (m Model) renderConflictModal() string {
 
 	content += fmt.Sprintf("Item: %s (%s)\n", conflict.ClientID, conflict.Type)
 
-	// Local version info
-	localContent := "Unknown"
-	if conflict.Type == "task" {
-		// Try to find content in encoded data
-		if conflict.ClientData.EncryptedContent != "" {
-			data, _ := base64.StdEncoding.DecodeString(conflict.ClientData.EncryptedContent)
-			var c struct {
-				Content string `json:"content"`
-			}
-			if err := json.Unmarshal(data, &c); err == nil {
-				localContent = c.Content
-			}
-		}
-	} else {
-		localContent = conflict.ClientData.Name
+	if len(conflict.Fields) > 0 {
+		content += fmt.Sprintf("%s\n\n", truncate(m.describeSyncItem(conflict.ClientData), modalWidth-10))
+		content += m.renderConflictFields(conflict.Fields, modalWidth)
+
+		content += lipgloss.NewStyle().Foreground(Border).Render(strings.Repeat("─", modalWidth-6)) + "\n\n"
+		content += HelpStyle.Render("↑↓:field  l:local  s:server  Enter:apply") + "\n"
+		content += HelpStyle.Render("[L] Keep All Local  [S] Keep All Server  [Q] Ignore")
+		return ModalStyle.Width(modalWidth).Render(content)
 	}
+
+	// Local version info
+	localContent := m.describeSyncItem(conflict.ClientData)
 	content += lipgloss.NewStyle().Bold(true).Render("Local Version:") + "\n"
 	content += fmt.Sprintf("Last Modified: %s\n", conflict.ClientData.ClientUpdatedAt)
 	content += fmt.Sprintf("Content: %s\n\n", truncate(localContent, modalWidth-10))
 
 	// Server version info
-	serverContent := "Unknown"
-	if conflict.Type == "task" {
-		if conflict.ServerData.EncryptedContent != "" {
-			data, _ := base64.StdEncoding.DecodeString(conflict.ServerData.EncryptedContent)
-			var c struct {
-				Content string `json:"content"`
-			}
-			if err := json.Unmarshal(data, &c); err == nil {
-				serverContent = c.Content
-			}
-		}
-	} else {
-		serverContent = conflict.ServerData.Name
-	}
+	serverContent := m.describeSyncItem(conflict.ServerData)
 	content += lipgloss.NewStyle().Bold(true).Render("Server Version:") + "\n"
 	// Server doesn't send updated_at explicitly in SyncItem, but SyncVersion roughly correlates
 	content += fmt.Sprintf("Sync Version: %d\n", conflict.ServerData.SyncVersion)
@@ -375,6 +364,45 @@ func (m Model) renderConflictModal() string {
 	return ModalStyle.Width(modalWidth).Render(content)
 }
 
+// renderConflictFields lists the fields changed on both sides with the chosen value
+func (m Model) renderConflictFields(fields []sync.FieldConflict, modalWidth int) string {
+	valueWidth := (modalWidth - 22) / 2
+	content := lipgloss.NewStyle().Bold(true).Render(
+		fmt.Sprintf("  %-10s   %-*s   %-*s", "Field", valueWidth, "Local", valueWidth, "Server")) + "\n"
+
+	for i, f := range fields {
+		local, server := "  ", "● "
+		if m.keepLocal[f.Field] {
+			local, server = "● ", "  "
+		}
+
+		marker := "  "
+		style := lipgloss.NewStyle()
+		if i == m.conflictField {
+			marker = "❯ "
+			style = lipgloss.NewStyle().Bold(true).Foreground(Primary)
+		}
+
+		line := fmt.Sprintf("%s%-10s %s%-*s %s%-*s", marker, f.Field,
+			local, valueWidth, truncate(f.Local, valueWidth),
+			server, valueWidth, truncate(f.Server, valueWidth))
+		content += style.Render(line) + "\n"
+	}
+	return content + "\n"
+}
+
+// describeSyncItem returns the decrypted content or name of a sync item for display
+func (m Model) describeSyncItem(item sync.SyncItem) string {
+	if m.syncClient == nil {
+		return "Unknown"
+	}
+	text, err := m.syncClient.DescribeItem(item)
+	if err != nil || text == "" {
+		return "Unknown"
+	}
+	return text
+}
+
 func (m Model) renderHelp() string {
 	help := `
 ╭─── Keyboard Shortcuts ───╮
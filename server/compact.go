@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/server/database"
+)
+
+// Deleted items are kept as tombstones so every device learns about the
+// deletion. Each pull records how far the device got, once all devices of a
+// user pulled past a tombstone it is purged. Devices not seen for deviceTTL no
+// longer count, they pull everything again when they return.
+const (
+	compactInterval = time.Hour
+	deviceTTL       = 90 * 24 * time.Hour
+)
+
+// compactLoop purges tombstones every compactInterval until the server closes
+func (s *Server) compactLoop() {
+	ticker := time.NewTicker(compactInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.compact(context.Background()); err != nil {
+			logger.Error("compaction failed", logger.F("error", err))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// compact purges the tombstones every known device of a user pulled past
+func (s *Server) compact(ctx context.Context) error {
+	if err := s.queries.DeleteStaleSyncDevices(ctx, time.Now().Add(-deviceTTL)); err != nil {
+		return err
+	}
+
+	horizons, err := s.queries.ListPurgeHorizons(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range horizons {
+		if h.Horizon == 0 {
+			continue // A device is starting from scratch
+		}
+		if err := s.purgeUser(ctx, h); err != nil {
+			logger.Error("compaction: purge failed",
+				logger.F("user", h.UserID.String()[:8]),
+				logger.F("error", err))
+		}
+	}
+	return nil
+}
+
+// purgeUser deletes a user's tombstones up to the horizon and records it, in
+// one transaction so pulls never see a purge without the new purged version
+func (s *Server) purgeUser(ctx context.Context, h database.ListPurgeHorizonsRow) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	q := s.queries.WithTx(tx)
+
+	version := sql.NullInt64{Int64: h.Horizon, Valid: true}
+	tasks, err := q.PurgeDeletedTasks(ctx, database.PurgeDeletedTasksParams{UserID: h.UserID, SyncVersion: version})
+	if err != nil {
+		return err
+	}
+	projects, err := q.PurgeDeletedProjects(ctx, database.PurgeDeletedProjectsParams{UserID: h.UserID, SyncVersion: version})
+	if err != nil {
+		return err
+	}
+	if tasks+projects == 0 {
+		return nil
+	}
+
+	if err := q.SetPurgedVersion(ctx, database.SetPurgedVersionParams{ID: h.UserID, PurgedVersion: h.Horizon}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logger.Info("compaction: purged deletions",
+		logger.F("user", h.UserID.String()[:8]),
+		logger.F("tasks", tasks),
+		logger.F("projects", projects),
+		logger.F("through", h.Horizon))
+	return nil
+}
@@ -11,6 +11,13 @@ import (
 	"github.com/google/uuid"
 )
 
+type IrontaskEncryptionKey struct {
+	UserID    uuid.UUID    `json:"user_id"`
+	KeyData   string       `json:"key_data"`
+	CreatedAt sql.NullTime `json:"created_at"`
+	UpdatedAt sql.NullTime `json:"updated_at"`
+}
+
 type IrontaskMagicLink struct {
 	ID        uuid.UUID    `json:"id"`
 	Email     string       `json:"email"`
@@ -24,8 +31,8 @@ type IrontaskProject struct {
 	ID              uuid.UUID      `json:"id"`
 	UserID          uuid.UUID      `json:"user_id"`
 	ClientID        string         `json:"client_id"`
-	Slug            string         `json:"slug"`
-	Name            string         `json:"name"`
+	Slug            sql.NullString `json:"slug"`
+	Name            sql.NullString `json:"name"`
 	Color           sql.NullString `json:"color"`
 	EncryptedData   []byte         `json:"encrypted_data"`
 	SyncVersion     sql.NullInt64  `json:"sync_version"`
@@ -33,6 +40,8 @@ type IrontaskProject struct {
 	CreatedAt       sql.NullTime   `json:"created_at"`
 	UpdatedAt       sql.NullTime   `json:"updated_at"`
 	ClientUpdatedAt sql.NullTime   `json:"client_updated_at"`
+	Opaque          sql.NullBool   `json:"opaque"`
+	Clock           sql.NullString `json:"clock"`
 }
 
 type IrontaskSession struct {
@@ -43,11 +52,18 @@ type IrontaskSession struct {
 	CreatedAt sql.NullTime `json:"created_at"`
 }
 
+type IrontaskSyncDevice struct {
+	UserID        uuid.UUID `json:"user_id"`
+	DeviceID      string    `json:"device_id"`
+	PulledVersion int64     `json:"pulled_version"`
+	LastSeen      time.Time `json:"last_seen"`
+}
+
 type IrontaskTask struct {
 	ID               uuid.UUID      `json:"id"`
 	UserID           uuid.UUID      `json:"user_id"`
 	ClientID         string         `json:"client_id"`
-	ProjectID        string         `json:"project_id"`
+	ProjectID        sql.NullString `json:"project_id"`
 	Type             sql.NullString `json:"type"`
 	EncryptedContent []byte         `json:"encrypted_content"`
 	Status           sql.NullString `json:"status"`
@@ -58,13 +74,16 @@ type IrontaskTask struct {
 	CreatedAt        sql.NullTime   `json:"created_at"`
 	UpdatedAt        sql.NullTime   `json:"updated_at"`
 	ClientUpdatedAt  sql.NullTime   `json:"client_updated_at"`
+	Opaque           sql.NullBool   `json:"opaque"`
+	Clock            sql.NullString `json:"clock"`
 }
 
 type IrontaskUser struct {
-	ID           uuid.UUID    `json:"id"`
-	Username     string       `json:"username"`
-	Email        string       `json:"email"`
-	PasswordHash string       `json:"password_hash"`
-	CreatedAt    sql.NullTime `json:"created_at"`
-	UpdatedAt    sql.NullTime `json:"updated_at"`
+	ID            uuid.UUID    `json:"id"`
+	Username      string       `json:"username"`
+	Email         string       `json:"email"`
+	PasswordHash  string       `json:"password_hash"`
+	CreatedAt     sql.NullTime `json:"created_at"`
+	UpdatedAt     sql.NullTime `json:"updated_at"`
+	PurgedVersion int64        `json:"purged_version"`
 }
@@ -7,6 +7,7 @@ package database
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -18,17 +19,46 @@ type Querier interface {
 	CreateSession(ctx context.Context, arg CreateSessionParams) (string, error)
 	CreateUser(ctx context.Context, arg CreateUserParams) (CreateUserRow, error)
 	DeleteSession(ctx context.Context, token string) error
+	// Devices not seen for long stop holding back compaction, they pull everything again on return
+	DeleteStaleSyncDevices(ctx context.Context, lastSeen time.Time) error
+	GetEncryptionKey(ctx context.Context, userID uuid.UUID) (string, error)
 	GetMagicLink(ctx context.Context, token string) (GetMagicLinkRow, error)
+	// Latest version of any item of the user, deleted or not
+	GetMaxSyncVersion(ctx context.Context, userID uuid.UUID) (int64, error)
 	GetProjectForConflict(ctx context.Context, arg GetProjectForConflictParams) (GetProjectForConflictRow, error)
+	// One page of changes in version order, the version of the last item is the cursor of the next.
+	// Without with_deleted only live projects, for devices starting from scratch. Deleted projects
+	// live tasks refer to stay, opaque ones too as the server cannot tell.
 	GetProjectsChanged(ctx context.Context, arg GetProjectsChangedParams) ([]GetProjectsChangedRow, error)
+	GetPurgedVersion(ctx context.Context, id uuid.UUID) (int64, error)
 	GetSession(ctx context.Context, token string) (GetSessionRow, error)
 	GetTaskForConflict(ctx context.Context, arg GetTaskForConflictParams) (GetTaskForConflictRow, error)
+	// One page of changes in version order, the version of the last item is the cursor of the next.
+	// Without with_deleted only live tasks, for devices starting from scratch.
 	GetTasksChanged(ctx context.Context, arg GetTasksChangedParams) ([]GetTasksChangedRow, error)
 	GetUserByEmail(ctx context.Context, email string) (GetUserByEmailRow, error)
 	GetUserByID(ctx context.Context, id uuid.UUID) (GetUserByIDRow, error)
 	GetUserByUsername(ctx context.Context, username string) (GetUserByUsernameRow, error)
+	// Per user, the version every known device has pulled past
+	ListPurgeHorizons(ctx context.Context) ([]ListPurgeHorizonsRow, error)
+	// Held by a push until it commits, pulls of the user wait for it
+	LockUserSync(ctx context.Context, userID uuid.UUID) error
+	// Held by a pull, waits for pushes of the user in progress
+	LockUserSyncShared(ctx context.Context, userID uuid.UUID) error
 	MarkMagicLinkUsed(ctx context.Context, token string) error
+	// Deleted projects live tasks refer to are kept, opaque ones always
+	PurgeDeletedProjects(ctx context.Context, arg PurgeDeletedProjectsParams) (int64, error)
+	PurgeDeletedTasks(ctx context.Context, arg PurgeDeletedTasksParams) (int64, error)
+	// Deletions up to purged_version are gone, devices behind it must pull everything again
+	SetPurgedVersion(ctx context.Context, arg SetPurgedVersionParams) error
+	// A device pulling since a version has applied every change up to it
+	TouchSyncDevice(ctx context.Context, arg TouchSyncDeviceParams) error
+	UpsertEncryptionKey(ctx context.Context, arg UpsertEncryptionKeyParams) error
+	// Compare-and-swap: an existing row is only updated while its version is still the
+	// client's base version. Otherwise no row is returned and the push is a conflict.
 	UpsertProject(ctx context.Context, arg UpsertProjectParams) (sql.NullInt64, error)
+	// Compare-and-swap: an existing row is only updated while its version is still the
+	// client's base version. Otherwise no row is returned and the push is a conflict.
 	UpsertTask(ctx context.Context, arg UpsertTaskParams) (sql.NullInt64, error)
 }
 
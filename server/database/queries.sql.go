@@ -106,6 +106,29 @@ func (q *Queries) DeleteSession(ctx context.Context, token string) error {
 	return err
 }
 
+const deleteStaleSyncDevices = `-- name: DeleteStaleSyncDevices :exec
+DELETE FROM irontask.sync_devices WHERE last_seen < $1
+`
+
+// Devices not seen for long stop holding back compaction, they pull everything again on return
+func (q *Queries) DeleteStaleSyncDevices(ctx context.Context, lastSeen time.Time) error {
+	_, err := q.db.ExecContext(ctx, deleteStaleSyncDevices, lastSeen)
+	return err
+}
+
+const getEncryptionKey = `-- name: GetEncryptionKey :one
+SELECT key_data
+FROM irontask.encryption_keys
+WHERE user_id = $1
+`
+
+func (q *Queries) GetEncryptionKey(ctx context.Context, userID uuid.UUID) (string, error) {
+	row := q.db.QueryRowContext(ctx, getEncryptionKey, userID)
+	var key_data string
+	err := row.Scan(&key_data)
+	return key_data, err
+}
+
 const getMagicLink = `-- name: GetMagicLink :one
 SELECT email, expires_at, used
 FROM irontask.magic_links
@@ -125,8 +148,23 @@ func (q *Queries) GetMagicLink(ctx context.Context, token string) (GetMagicLinkR
 	return i, err
 }
 
+const getMaxSyncVersion = `-- name: GetMaxSyncVersion :one
+SELECT GREATEST(
+    (SELECT COALESCE(MAX(sync_version), 0) FROM irontask.projects WHERE projects.user_id = $1),
+    (SELECT COALESCE(MAX(sync_version), 0) FROM irontask.tasks WHERE tasks.user_id = $1)
+)::BIGINT AS max_version
+`
+
+// Latest version of any item of the user, deleted or not
+func (q *Queries) GetMaxSyncVersion(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getMaxSyncVersion, userID)
+	var max_version int64
+	err := row.Scan(&max_version)
+	return max_version, err
+}
+
 const getProjectForConflict = `-- name: GetProjectForConflict :one
-SELECT sync_version, updated_at, client_updated_at, slug, name, encrypted_data, deleted
+SELECT sync_version, updated_at, client_updated_at, slug, name, encrypted_data, deleted, opaque, clock
 FROM irontask.projects
 WHERE user_id = $1 AND client_id = $2
 `
@@ -137,13 +175,15 @@ type GetProjectForConflictParams struct {
 }
 
 type GetProjectForConflictRow struct {
-	SyncVersion     sql.NullInt64 `json:"sync_version"`
-	UpdatedAt       sql.NullTime  `json:"updated_at"`
-	ClientUpdatedAt sql.NullTime  `json:"client_updated_at"`
-	Slug            string        `json:"slug"`
-	Name            string        `json:"name"`
-	EncryptedData   []byte        `json:"encrypted_data"`
-	Deleted         sql.NullBool  `json:"deleted"`
+	SyncVersion     sql.NullInt64  `json:"sync_version"`
+	UpdatedAt       sql.NullTime   `json:"updated_at"`
+	ClientUpdatedAt sql.NullTime   `json:"client_updated_at"`
+	Slug            sql.NullString `json:"slug"`
+	Name            sql.NullString `json:"name"`
+	EncryptedData   []byte         `json:"encrypted_data"`
+	Deleted         sql.NullBool   `json:"deleted"`
+	Opaque          sql.NullBool   `json:"opaque"`
+	Clock           sql.NullString `json:"clock"`
 }
 
 func (q *Queries) GetProjectForConflict(ctx context.Context, arg GetProjectForConflictParams) (GetProjectForConflictRow, error) {
@@ -157,33 +197,51 @@ func (q *Queries) GetProjectForConflict(ctx context.Context, arg GetProjectForCo
 		&i.Name,
 		&i.EncryptedData,
 		&i.Deleted,
+		&i.Opaque,
+		&i.Clock,
 	)
 	return i, err
 }
 
 const getProjectsChanged = `-- name: GetProjectsChanged :many
-SELECT client_id, slug, name, 'project' as type, sync_version, encrypted_data, deleted
+SELECT client_id, slug, name, 'project' as type, sync_version, encrypted_data, deleted, opaque, clock
 FROM irontask.projects
 WHERE user_id = $1 AND sync_version > $2
+  AND ($3::BOOLEAN OR deleted IS NOT TRUE OR opaque IS TRUE
+    OR client_id IN (SELECT project_id FROM irontask.tasks WHERE tasks.user_id = $1 AND tasks.deleted IS NOT TRUE))
+ORDER BY sync_version
+LIMIT $4
 `
 
 type GetProjectsChangedParams struct {
 	UserID      uuid.UUID     `json:"user_id"`
 	SyncVersion sql.NullInt64 `json:"sync_version"`
+	WithDeleted bool          `json:"with_deleted"`
+	Limit       int32         `json:"limit"`
 }
 
 type GetProjectsChangedRow struct {
-	ClientID      string        `json:"client_id"`
-	Slug          string        `json:"slug"`
-	Name          string        `json:"name"`
-	Type          string        `json:"type"`
-	SyncVersion   sql.NullInt64 `json:"sync_version"`
-	EncryptedData []byte        `json:"encrypted_data"`
-	Deleted       sql.NullBool  `json:"deleted"`
-}
-
+	ClientID      string         `json:"client_id"`
+	Slug          sql.NullString `json:"slug"`
+	Name          sql.NullString `json:"name"`
+	Type          string         `json:"type"`
+	SyncVersion   sql.NullInt64  `json:"sync_version"`
+	EncryptedData []byte         `json:"encrypted_data"`
+	Deleted       sql.NullBool   `json:"deleted"`
+	Opaque        sql.NullBool   `json:"opaque"`
+	Clock         sql.NullString `json:"clock"`
+}
+
+// One page of changes in version order, the version of the last item is the cursor of the next.
+// Without with_deleted only live projects, for devices starting from scratch. Deleted projects
+// live tasks refer to stay, opaque ones too as the server cannot tell.
 func (q *Queries) GetProjectsChanged(ctx context.Context, arg GetProjectsChangedParams) ([]GetProjectsChangedRow, error) {
-	rows, err := q.db.QueryContext(ctx, getProjectsChanged, arg.UserID, arg.SyncVersion)
+	rows, err := q.db.QueryContext(ctx, getProjectsChanged,
+		arg.UserID,
+		arg.SyncVersion,
+		arg.WithDeleted,
+		arg.Limit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -199,6 +257,8 @@ func (q *Queries) GetProjectsChanged(ctx context.Context, arg GetProjectsChanged
 			&i.SyncVersion,
 			&i.EncryptedData,
 			&i.Deleted,
+			&i.Opaque,
+			&i.Clock,
 		); err != nil {
 			return nil, err
 		}
@@ -213,6 +273,19 @@ func (q *Queries) GetProjectsChanged(ctx context.Context, arg GetProjectsChanged
 	return items, nil
 }
 
+const getPurgedVersion = `-- name: GetPurgedVersion :one
+SELECT purged_version
+FROM irontask.users
+WHERE id = $1
+`
+
+func (q *Queries) GetPurgedVersion(ctx context.Context, id uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getPurgedVersion, id)
+	var purged_version int64
+	err := row.Scan(&purged_version)
+	return purged_version, err
+}
+
 const getSession = `-- name: GetSession :one
 SELECT user_id, expires_at
 FROM irontask.sessions
@@ -232,7 +305,7 @@ func (q *Queries) GetSession(ctx context.Context, token string) (GetSessionRow,
 }
 
 const getTaskForConflict = `-- name: GetTaskForConflict :one
-SELECT sync_version, updated_at, client_updated_at, status, priority, project_id, encrypted_content, due_date, deleted
+SELECT sync_version, updated_at, client_updated_at, status, priority, project_id, encrypted_content, due_date, deleted, opaque, clock
 FROM irontask.tasks
 WHERE user_id = $1 AND client_id = $2
 `
@@ -248,10 +321,12 @@ type GetTaskForConflictRow struct {
 	ClientUpdatedAt  sql.NullTime   `json:"client_updated_at"`
 	Status           sql.NullString `json:"status"`
 	Priority         sql.NullInt32  `json:"priority"`
-	ProjectID        string         `json:"project_id"`
+	ProjectID        sql.NullString `json:"project_id"`
 	EncryptedContent []byte         `json:"encrypted_content"`
 	DueDate          sql.NullString `json:"due_date"`
 	Deleted          sql.NullBool   `json:"deleted"`
+	Opaque           sql.NullBool   `json:"opaque"`
+	Clock            sql.NullString `json:"clock"`
 }
 
 func (q *Queries) GetTaskForConflict(ctx context.Context, arg GetTaskForConflictParams) (GetTaskForConflictRow, error) {
@@ -267,24 +342,31 @@ func (q *Queries) GetTaskForConflict(ctx context.Context, arg GetTaskForConflict
 		&i.EncryptedContent,
 		&i.DueDate,
 		&i.Deleted,
+		&i.Opaque,
+		&i.Clock,
 	)
 	return i, err
 }
 
 const getTasksChanged = `-- name: GetTasksChanged :many
-SELECT client_id, project_id, 'task' as type, sync_version, encrypted_content, status, priority, due_date, deleted
+SELECT client_id, project_id, 'task' as type, sync_version, encrypted_content, status, priority, due_date, deleted, opaque, clock
 FROM irontask.tasks
 WHERE user_id = $1 AND sync_version > $2
+  AND ($3::BOOLEAN OR deleted IS NOT TRUE)
+ORDER BY sync_version
+LIMIT $4
 `
 
 type GetTasksChangedParams struct {
 	UserID      uuid.UUID     `json:"user_id"`
 	SyncVersion sql.NullInt64 `json:"sync_version"`
+	WithDeleted bool          `json:"with_deleted"`
+	Limit       int32         `json:"limit"`
 }
 
 type GetTasksChangedRow struct {
 	ClientID         string         `json:"client_id"`
-	ProjectID        string         `json:"project_id"`
+	ProjectID        sql.NullString `json:"project_id"`
 	Type             string         `json:"type"`
 	SyncVersion      sql.NullInt64  `json:"sync_version"`
 	EncryptedContent []byte         `json:"encrypted_content"`
@@ -292,10 +374,19 @@ type GetTasksChangedRow struct {
 	Priority         sql.NullInt32  `json:"priority"`
 	DueDate          sql.NullString `json:"due_date"`
 	Deleted          sql.NullBool   `json:"deleted"`
+	Opaque           sql.NullBool   `json:"opaque"`
+	Clock            sql.NullString `json:"clock"`
 }
 
+// One page of changes in version order, the version of the last item is the cursor of the next.
+// Without with_deleted only live tasks, for devices starting from scratch.
 func (q *Queries) GetTasksChanged(ctx context.Context, arg GetTasksChangedParams) ([]GetTasksChangedRow, error) {
-	rows, err := q.db.QueryContext(ctx, getTasksChanged, arg.UserID, arg.SyncVersion)
+	rows, err := q.db.QueryContext(ctx, getTasksChanged,
+		arg.UserID,
+		arg.SyncVersion,
+		arg.WithDeleted,
+		arg.Limit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -313,6 +404,8 @@ func (q *Queries) GetTasksChanged(ctx context.Context, arg GetTasksChangedParams
 			&i.Priority,
 			&i.DueDate,
 			&i.Deleted,
+			&i.Opaque,
+			&i.Clock,
 		); err != nil {
 			return nil, err
 		}
@@ -396,6 +489,61 @@ func (q *Queries) GetUserByUsername(ctx context.Context, username string) (GetUs
 	return i, err
 }
 
+const listPurgeHorizons = `-- name: ListPurgeHorizons :many
+SELECT user_id, MIN(pulled_version)::BIGINT AS horizon
+FROM irontask.sync_devices
+GROUP BY user_id
+`
+
+type ListPurgeHorizonsRow struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Horizon int64     `json:"horizon"`
+}
+
+// Per user, the version every known device has pulled past
+func (q *Queries) ListPurgeHorizons(ctx context.Context) ([]ListPurgeHorizonsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listPurgeHorizons)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListPurgeHorizonsRow
+	for rows.Next() {
+		var i ListPurgeHorizonsRow
+		if err := rows.Scan(&i.UserID, &i.Horizon); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockUserSync = `-- name: LockUserSync :exec
+SELECT pg_advisory_xact_lock(hashtextextended($1::uuid::text, 0))
+`
+
+// Held by a push until it commits, pulls of the user wait for it
+func (q *Queries) LockUserSync(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, lockUserSync, userID)
+	return err
+}
+
+const lockUserSyncShared = `-- name: LockUserSyncShared :exec
+SELECT pg_advisory_xact_lock_shared(hashtextextended($1::uuid::text, 0))
+`
+
+// Held by a pull, waits for pushes of the user in progress
+func (q *Queries) LockUserSyncShared(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, lockUserSyncShared, userID)
+	return err
+}
+
 const markMagicLinkUsed = `-- name: MarkMagicLinkUsed :exec
 UPDATE irontask.magic_links SET used = TRUE WHERE token = $1
 `
@@ -405,11 +553,104 @@ func (q *Queries) MarkMagicLinkUsed(ctx context.Context, token string) error {
 	return err
 }
 
+const purgeDeletedProjects = `-- name: PurgeDeletedProjects :execrows
+DELETE FROM irontask.projects
+WHERE user_id = $1 AND deleted = TRUE AND sync_version <= $2
+  AND opaque IS NOT TRUE
+  AND client_id NOT IN (SELECT project_id FROM irontask.tasks WHERE tasks.user_id = $1 AND tasks.deleted IS NOT TRUE AND project_id IS NOT NULL)
+`
+
+type PurgeDeletedProjectsParams struct {
+	UserID      uuid.UUID     `json:"user_id"`
+	SyncVersion sql.NullInt64 `json:"sync_version"`
+}
+
+// Deleted projects live tasks refer to are kept, opaque ones always
+func (q *Queries) PurgeDeletedProjects(ctx context.Context, arg PurgeDeletedProjectsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedProjects, arg.UserID, arg.SyncVersion)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const purgeDeletedTasks = `-- name: PurgeDeletedTasks :execrows
+DELETE FROM irontask.tasks
+WHERE user_id = $1 AND deleted = TRUE AND sync_version <= $2
+`
+
+type PurgeDeletedTasksParams struct {
+	UserID      uuid.UUID     `json:"user_id"`
+	SyncVersion sql.NullInt64 `json:"sync_version"`
+}
+
+func (q *Queries) PurgeDeletedTasks(ctx context.Context, arg PurgeDeletedTasksParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeDeletedTasks, arg.UserID, arg.SyncVersion)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setPurgedVersion = `-- name: SetPurgedVersion :exec
+UPDATE irontask.users SET purged_version = $2
+WHERE id = $1 AND purged_version < $2
+`
+
+type SetPurgedVersionParams struct {
+	ID            uuid.UUID `json:"id"`
+	PurgedVersion int64     `json:"purged_version"`
+}
+
+// Deletions up to purged_version are gone, devices behind it must pull everything again
+func (q *Queries) SetPurgedVersion(ctx context.Context, arg SetPurgedVersionParams) error {
+	_, err := q.db.ExecContext(ctx, setPurgedVersion, arg.ID, arg.PurgedVersion)
+	return err
+}
+
+const touchSyncDevice = `-- name: TouchSyncDevice :exec
+INSERT INTO irontask.sync_devices (user_id, device_id, pulled_version, last_seen)
+VALUES ($1, $2, $3, NOW())
+ON CONFLICT (user_id, device_id) DO UPDATE
+SET pulled_version = EXCLUDED.pulled_version,
+    last_seen = NOW()
+`
+
+type TouchSyncDeviceParams struct {
+	UserID        uuid.UUID `json:"user_id"`
+	DeviceID      string    `json:"device_id"`
+	PulledVersion int64     `json:"pulled_version"`
+}
+
+// A device pulling since a version has applied every change up to it
+func (q *Queries) TouchSyncDevice(ctx context.Context, arg TouchSyncDeviceParams) error {
+	_, err := q.db.ExecContext(ctx, touchSyncDevice, arg.UserID, arg.DeviceID, arg.PulledVersion)
+	return err
+}
+
+const upsertEncryptionKey = `-- name: UpsertEncryptionKey :exec
+INSERT INTO irontask.encryption_keys (user_id, key_data)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET key_data = EXCLUDED.key_data,
+    updated_at = NOW()
+`
+
+type UpsertEncryptionKeyParams struct {
+	UserID  uuid.UUID `json:"user_id"`
+	KeyData string    `json:"key_data"`
+}
+
+func (q *Queries) UpsertEncryptionKey(ctx context.Context, arg UpsertEncryptionKeyParams) error {
+	_, err := q.db.ExecContext(ctx, upsertEncryptionKey, arg.UserID, arg.KeyData)
+	return err
+}
+
 const upsertProject = `-- name: UpsertProject :one
-INSERT INTO irontask.projects (user_id, client_id, slug, name, color, encrypted_data, sync_version, deleted, updated_at, client_updated_at)
+INSERT INTO irontask.projects (user_id, client_id, slug, name, color, encrypted_data, sync_version, deleted, updated_at, client_updated_at, opaque, clock)
 VALUES ($1, $2, $3, $4, $5, $6, 
     nextval('irontask.sync_version_seq'), 
-    $7, NOW(), $8)
+    $7, NOW(), $8, $9, $10)
 ON CONFLICT (user_id, client_id) DO UPDATE
 SET slug = EXCLUDED.slug,
     name = EXCLUDED.name,
@@ -417,21 +658,29 @@ SET slug = EXCLUDED.slug,
     deleted = EXCLUDED.deleted,
     sync_version = nextval('irontask.sync_version_seq'),
     updated_at = NOW(),
-    client_updated_at = EXCLUDED.client_updated_at
+    client_updated_at = EXCLUDED.client_updated_at,
+    opaque = EXCLUDED.opaque,
+    clock = EXCLUDED.clock
+WHERE irontask.projects.sync_version = $11
 RETURNING sync_version
 `
 
 type UpsertProjectParams struct {
 	UserID          uuid.UUID      `json:"user_id"`
 	ClientID        string         `json:"client_id"`
-	Slug            string         `json:"slug"`
-	Name            string         `json:"name"`
+	Slug            sql.NullString `json:"slug"`
+	Name            sql.NullString `json:"name"`
 	Color           sql.NullString `json:"color"`
 	EncryptedData   []byte         `json:"encrypted_data"`
 	Deleted         sql.NullBool   `json:"deleted"`
 	ClientUpdatedAt sql.NullTime   `json:"client_updated_at"`
+	Opaque          sql.NullBool   `json:"opaque"`
+	Clock           sql.NullString `json:"clock"`
+	BaseVersion     sql.NullInt64  `json:"base_version"`
 }
 
+// Compare-and-swap: an existing row is only updated while its version is still the
+// client's base version. Otherwise no row is returned and the push is a conflict.
 func (q *Queries) UpsertProject(ctx context.Context, arg UpsertProjectParams) (sql.NullInt64, error) {
 	row := q.db.QueryRowContext(ctx, upsertProject,
 		arg.UserID,
@@ -442,6 +691,9 @@ func (q *Queries) UpsertProject(ctx context.Context, arg UpsertProjectParams) (s
 		arg.EncryptedData,
 		arg.Deleted,
 		arg.ClientUpdatedAt,
+		arg.Opaque,
+		arg.Clock,
+		arg.BaseVersion,
 	)
 	var sync_version sql.NullInt64
 	err := row.Scan(&sync_version)
@@ -449,10 +701,10 @@ func (q *Queries) UpsertProject(ctx context.Context, arg UpsertProjectParams) (s
 }
 
 const upsertTask = `-- name: UpsertTask :one
-INSERT INTO irontask.tasks (user_id, client_id, project_id, type, encrypted_content, status, priority, due_date, deleted, sync_version, updated_at, client_updated_at)
+INSERT INTO irontask.tasks (user_id, client_id, project_id, type, encrypted_content, status, priority, due_date, deleted, sync_version, updated_at, client_updated_at, opaque, clock)
 VALUES ($1, $2, $3, 'task', $4, $5, $6, $7, $8, 
     nextval('irontask.sync_version_seq'),
-    NOW(), $9)
+    NOW(), $9, $10, $11)
 ON CONFLICT (user_id, client_id) DO UPDATE
 SET project_id = EXCLUDED.project_id,
     encrypted_content = EXCLUDED.encrypted_content,
@@ -462,22 +714,30 @@ SET project_id = EXCLUDED.project_id,
     deleted = EXCLUDED.deleted,
     sync_version = nextval('irontask.sync_version_seq'),
     updated_at = NOW(),
-    client_updated_at = EXCLUDED.client_updated_at
+    client_updated_at = EXCLUDED.client_updated_at,
+    opaque = EXCLUDED.opaque,
+    clock = EXCLUDED.clock
+WHERE irontask.tasks.sync_version = $12
 RETURNING sync_version
 `
 
 type UpsertTaskParams struct {
 	UserID           uuid.UUID      `json:"user_id"`
 	ClientID         string         `json:"client_id"`
-	ProjectID        string         `json:"project_id"`
+	ProjectID        sql.NullString `json:"project_id"`
 	EncryptedContent []byte         `json:"encrypted_content"`
 	Status           sql.NullString `json:"status"`
 	Priority         sql.NullInt32  `json:"priority"`
 	DueDate          sql.NullString `json:"due_date"`
 	Deleted          sql.NullBool   `json:"deleted"`
 	ClientUpdatedAt  sql.NullTime   `json:"client_updated_at"`
+	Opaque           sql.NullBool   `json:"opaque"`
+	Clock            sql.NullString `json:"clock"`
+	BaseVersion      sql.NullInt64  `json:"base_version"`
 }
 
+// Compare-and-swap: an existing row is only updated while its version is still the
+// client's base version. Otherwise no row is returned and the push is a conflict.
 func (q *Queries) UpsertTask(ctx context.Context, arg UpsertTaskParams) (sql.NullInt64, error) {
 	row := q.db.QueryRowContext(ctx, upsertTask,
 		arg.UserID,
@@ -489,6 +749,9 @@ func (q *Queries) UpsertTask(ctx context.Context, arg UpsertTaskParams) (sql.Nul
 		arg.DueDate,
 		arg.Deleted,
 		arg.ClientUpdatedAt,
+		arg.Opaque,
+		arg.Clock,
+		arg.BaseVersion,
 	)
 	var sync_version sql.NullInt64
 	err := row.Scan(&sync_version)
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/existflow/irontask/internal/digest"
+	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/internal/protocol"
+	"github.com/existflow/irontask/server/database"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// handleSyncDigest returns the digest clients compare their data with, and the
+// items of the buckets they disagree on
+func (s *Server) handleSyncDigest(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid user id"})
+	}
+
+	wanted := make(map[int]bool)
+	for _, v := range c.QueryParams()["bucket"] {
+		b, err := strconv.Atoi(v)
+		if err != nil || b < 0 || b >= digest.Buckets {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid bucket"})
+		}
+		wanted[b] = true
+	}
+
+	items, err := s.liveItems(c.Request().Context(), userUUID)
+	if err != nil {
+		logger.Error("sync digest: get items failed", logger.F("error", err), logger.F("user", userID[:8]))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+
+	resp := protocol.SyncDigestResponse{Count: len(items)}
+	entries := make([]digest.Entry, 0, len(items))
+	for _, item := range items {
+		entries = append(entries, digest.Entry{Type: item.Type, ClientID: item.ClientID, SyncVersion: item.SyncVersion})
+		if wanted[digest.Bucket(item.Type, item.ClientID)] {
+			resp.Items = append(resp.Items, item)
+		}
+	}
+	resp.Buckets = digest.Sum(entries)
+
+	logger.Debug("sync digest",
+		logger.F("user", userID[:8]),
+		logger.F("items", len(items)),
+		logger.F("buckets", len(wanted)))
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// liveItems returns every item of a user that is not deleted
+func (s *Server) liveItems(ctx context.Context, userUUID uuid.UUID) ([]protocol.SyncItem, error) {
+	projects, err := s.queries.GetProjectsChanged(ctx, database.GetProjectsChangedParams{
+		UserID:      userUUID,
+		SyncVersion: sql.NullInt64{Int64: 0, Valid: true},
+		Limit:       math.MaxInt32,
+	})
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := s.queries.GetTasksChanged(ctx, database.GetTasksChangedParams{
+		UserID:      userUUID,
+		SyncVersion: sql.NullInt64{Int64: 0, Valid: true},
+		Limit:       math.MaxInt32,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Deleted projects live tasks refer to are returned too, leave them out
+	var items []protocol.SyncItem
+	for _, p := range projects {
+		if item := projectItem(p); !item.Deleted {
+			items = append(items, item)
+		}
+	}
+	for _, t := range tasks {
+		if item := taskItem(t); !item.Deleted {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
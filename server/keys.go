@@ -0,0 +1,57 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/internal/protocol"
+	"github.com/existflow/irontask/server/database"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// handleGetKey returns the user's key material
+func (s *Server) handleGetKey(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid user id"})
+	}
+
+	keyData, err := s.queries.GetEncryptionKey(c.Request().Context(), userUUID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "no encryption key"})
+	}
+	if err != nil {
+		logger.Error("get encryption key failed", logger.F("error", err), logger.F("user", userID[:8]))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+
+	return c.JSON(http.StatusOK, protocol.KeyMaterial{KeyData: keyData})
+}
+
+// handlePutKey stores the user's key material
+func (s *Server) handlePutKey(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid user id"})
+	}
+
+	var req protocol.KeyMaterial
+	if err := c.Bind(&req); err != nil || req.KeyData == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+
+	if err := s.queries.UpsertEncryptionKey(c.Request().Context(), database.UpsertEncryptionKeyParams{
+		UserID:  userUUID,
+		KeyData: req.KeyData,
+	}); err != nil {
+		logger.Error("store encryption key failed", logger.F("error", err), logger.F("user", userID[:8]))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+
+	logger.Info("encryption key stored", logger.F("user", userID[:8]))
+	return c.JSON(http.StatusOK, map[string]string{"message": "key stored"})
+}
@@ -1,13 +1,38 @@
 package server
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/existflow/irontask/internal/protocol"
 	"github.com/labstack/echo/v4"
 )
 
+// protocolMiddleware announces the protocol version of the server and turns
+// away clients too old to talk to it. Clients without a version header
+// predate protocol versions and speak version 1.
+func protocolMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set(protocol.VersionHeader, strconv.Itoa(protocol.Version))
+
+		if v := c.Request().Header.Get(protocol.VersionHeader); v != "" {
+			version, err := strconv.Atoi(v)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid protocol version"})
+			}
+			if version < protocol.MinVersion {
+				return c.JSON(http.StatusUpgradeRequired, map[string]string{
+					"error": fmt.Sprintf("sync protocol %d is no longer supported, please upgrade IronTask", version),
+				})
+			}
+		}
+		return next(c)
+	}
+}
+
 // authMiddleware checks for valid session token
 func (s *Server) authMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -17,6 +17,10 @@ func (s *Server) migrate() error {
 		"projects",
 		"tasks",
 		"server_side_sync_version",
+		"encryption_keys",
+		"opaque_items",
+		"client_clock",
+		"sync_devices",
 	}
 
 	migrations := []string{
@@ -26,6 +30,10 @@ func (s *Server) migrate() error {
 		migrationProjects,
 		migrationTasks,
 		migrationServerSideSyncVersion, // v2: Server-side sync versioning
+		migrationEncryptionKeys,        // v3: E2E key material
+		migrationOpaqueItems,           // v4: Zero-metadata sync
+		migrationClientClock,           // v5: Hybrid logical clocks
+		migrationSyncDevices,           // v6: Tombstone compaction
 	}
 
 	for i, m := range migrations {
@@ -161,3 +169,47 @@ ALTER TABLE irontask.tasks ALTER COLUMN sync_version SET DEFAULT nextval('ironta
 UPDATE irontask.projects SET client_updated_at = updated_at WHERE client_updated_at IS NULL;
 UPDATE irontask.tasks SET client_updated_at = updated_at WHERE client_updated_at IS NULL;
 `
+
+// migrationEncryptionKeys stores the per-user key material (salt and key check)
+// so every device derives the same key from the user's encryption password.
+// The server never sees the password or the derived key.
+const migrationEncryptionKeys = `
+CREATE TABLE IF NOT EXISTS irontask.encryption_keys (
+    user_id UUID PRIMARY KEY REFERENCES irontask.users(id),
+    key_data TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+`
+
+// migrationOpaqueItems supports items whose fields are all inside the encrypted
+// blob. Opaque items leave the plaintext metadata columns NULL.
+const migrationOpaqueItems = `
+ALTER TABLE irontask.projects ADD COLUMN IF NOT EXISTS opaque BOOLEAN DEFAULT FALSE;
+ALTER TABLE irontask.projects ALTER COLUMN slug DROP NOT NULL;
+ALTER TABLE irontask.projects ALTER COLUMN name DROP NOT NULL;
+
+ALTER TABLE irontask.tasks ADD COLUMN IF NOT EXISTS opaque BOOLEAN DEFAULT FALSE;
+ALTER TABLE irontask.tasks ALTER COLUMN project_id DROP NOT NULL;
+`
+
+// migrationClientClock stores the hybrid logical clock of each client change
+const migrationClientClock = `
+ALTER TABLE irontask.projects ADD COLUMN IF NOT EXISTS clock TEXT;
+ALTER TABLE irontask.tasks ADD COLUMN IF NOT EXISTS clock TEXT;
+`
+
+// migrationSyncDevices tracks how far each device of a user has pulled, so
+// deleted items are purged only once every device has seen them. Devices that
+// pull older deletions than purged_version must pull everything again.
+const migrationSyncDevices = `
+CREATE TABLE IF NOT EXISTS irontask.sync_devices (
+    user_id UUID NOT NULL REFERENCES irontask.users(id),
+    device_id TEXT NOT NULL,
+    pulled_version BIGINT NOT NULL DEFAULT 0,
+    last_seen TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, device_id)
+);
+
+ALTER TABLE irontask.users ADD COLUMN IF NOT EXISTS purged_version BIGINT NOT NULL DEFAULT 0;
+`
@@ -4,20 +4,29 @@ import (
 	"database/sql"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/internal/protocol"
 	"github.com/existflow/irontask/server/database"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	_ "github.com/lib/pq"
 )
 
+const (
+	maxBodySize   = "16M" // Largest request body, after decompression
+	gzipMinLength = 1024  // Smaller responses are sent uncompressed
+)
+
 // Server is the sync server
 type Server struct {
 	db      *sql.DB
 	queries *database.Queries
 	echo    *echo.Echo
+	streams *notifier     // Connected sync streams
+	done    chan struct{} // Closed by Close, stops background work
 }
 
 // New creates a new server
@@ -34,6 +43,8 @@ func New(dbURL string) (*Server, error) {
 	s := &Server{
 		db:      db,
 		queries: database.New(db),
+		streams: newNotifier(),
+		done:    make(chan struct{}),
 	}
 
 	// Run migrations
@@ -59,6 +70,25 @@ func (s *Server) setupEcho() {
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORS())
 
+	// Compressed sync payloads. Gzip request bodies are inflated, the size
+	// limit applies after inflating. Responses are gzipped for clients that
+	// accept it, except the change stream which must not be buffered. Clients
+	// learn from Accept-Encoding on responses that they may gzip requests.
+	e.Use(middleware.Decompress())
+	e.Use(middleware.BodyLimit(maxBodySize))
+	e.Use(middleware.GzipWithConfig(middleware.GzipConfig{
+		Skipper: func(c echo.Context) bool {
+			return strings.HasSuffix(c.Request().URL.Path, "/sync/stream")
+		},
+		MinLength: gzipMinLength,
+	}))
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Response().Header().Set(echo.HeaderAcceptEncoding, middleware.GZIPEncoding)
+			return next(c)
+		}
+	})
+
 	// Consolidated request logging - single line with all info
 	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -99,6 +129,8 @@ func (s *Server) setupEcho() {
 
 	// API v1
 	api := e.Group("/api/v1")
+	api.Use(protocolMiddleware)
+	api.GET("/capabilities", s.handleCapabilities)
 
 	// Auth endpoints (public)
 	api.POST("/register", s.handleRegister)
@@ -113,13 +145,18 @@ func (s *Server) setupEcho() {
 	protected.POST("/logout", s.handleLogout)
 	protected.GET("/sync", s.handleSyncPull)
 	protected.POST("/sync", s.handleSyncPush)
+	protected.GET("/sync/stream", s.handleSyncStream)
+	protected.GET("/sync/digest", s.handleSyncDigest)
 	protected.POST("/clear", s.handleClear)
+	protected.GET("/keys", s.handleGetKey)
+	protected.PUT("/keys", s.handlePutKey)
 
 	s.echo = e
 }
 
-// Close closes the database connection
+// Close stops background work and closes the database connection
 func (s *Server) Close() error {
+	close(s.done)
 	return s.db.Close()
 }
 
@@ -128,11 +165,24 @@ func (s *Server) Router() http.Handler {
 	return s.echo
 }
 
-// Start starts the server
+// Start starts the server and tombstone compaction
 func (s *Server) Start(addr string) error {
+	go s.compactLoop()
 	return s.echo.Start(addr)
 }
 
 func (s *Server) handleHealth(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
 }
+
+// handleCapabilities tells clients which protocol versions and features the
+// server supports, before they log in
+func (s *Server) handleCapabilities(c echo.Context) error {
+	return c.JSON(http.StatusOK, protocol.Capabilities{
+		Version:      protocol.Version,
+		MinVersion:   protocol.MinVersion,
+		Features:     protocol.Features,
+		MaxPullLimit: maxPullLimit,
+		MaxPushItems: maxPushItems,
+	})
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/internal/protocol"
+	"github.com/labstack/echo/v4"
+)
+
+// streamHeartbeat is how often an idle stream sends a comment line, so clients
+// and proxies notice dropped connections
+const streamHeartbeat = 25 * time.Second
+
+// subscriber is one connected stream of a user's device
+type subscriber struct {
+	device   string
+	versions chan int64
+}
+
+// notifier fans new versions out to the streams of a user. It only reaches
+// clients connected to this server process.
+type notifier struct {
+	mu   sync.Mutex
+	subs map[string]map[*subscriber]struct{} // User id -> streams
+}
+
+func newNotifier() *notifier {
+	return &notifier{subs: make(map[string]map[*subscriber]struct{})}
+}
+
+// subscribe registers a stream of a user's device
+func (n *notifier) subscribe(userID, device string) *subscriber {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sub := &subscriber{device: device, versions: make(chan int64, 1)}
+	if n.subs[userID] == nil {
+		n.subs[userID] = make(map[*subscriber]struct{})
+	}
+	n.subs[userID][sub] = struct{}{}
+	return sub
+}
+
+// unsubscribe removes a stream
+func (n *notifier) unsubscribe(userID string, sub *subscriber) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.subs[userID], sub)
+	if len(n.subs[userID]) == 0 {
+		delete(n.subs, userID)
+	}
+}
+
+// publish tells every stream of a user but the pushing device about a new
+// version. Slow streams only keep the latest version.
+func (n *notifier) publish(userID, device string, version int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for sub := range n.subs[userID] {
+		if device != "" && sub.device == device {
+			continue
+		}
+		select {
+		case <-sub.versions:
+		default:
+		}
+		sub.versions <- version
+	}
+}
+
+// handleSyncStream sends a server-sent event whenever another device pushed
+// changes, clients pull right away instead of waiting for the next poll
+func (s *Server) handleSyncStream(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	device := c.Request().Header.Get("X-Device-ID")
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	sub := s.streams.subscribe(userID, device)
+	defer s.streams.unsubscribe(userID, sub)
+
+	logger.Debug("sync stream opened", logger.F("user", userID[:8]))
+	defer logger.Debug("sync stream closed", logger.F("user", userID[:8]))
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case version := <-sub.versions:
+			data, _ := json.Marshal(protocol.StreamEvent{SyncVersion: version})
+			if _, err := fmt.Fprintf(res, "event: version\ndata: %s\n\n", data); err != nil {
+				return nil
+			}
+			res.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(res, ": ping\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		case <-c.Request().Context().Done():
+			return nil
+		case <-s.done:
+			return nil
+		}
+	}
+}
@@ -1,62 +1,32 @@
 package server
 
 import (
+	"context"
 	"database/sql"
 	"encoding/base64"
+	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/existflow/irontask/internal/logger"
+	"github.com/existflow/irontask/internal/protocol"
 	"github.com/existflow/irontask/server/database"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
-// SyncItem represents an encrypted item for sync
-type SyncItem struct {
-	ID               string `json:"id"`
-	ClientID         string `json:"client_id"`
-	Type             string `json:"type"` // "project" or "task"
-	Slug             string `json:"slug,omitempty"`
-	Name             string `json:"name,omitempty"`
-	ProjectID        string `json:"project_id,omitempty"`
-	EncryptedData    string `json:"encrypted_data,omitempty"`    // For projects
-	EncryptedContent string `json:"encrypted_content,omitempty"` // For tasks (content only)
-	Status           string `json:"status,omitempty"`
-	Priority         int32  `json:"priority,omitempty"`
-	DueDate          string `json:"due_date,omitempty"`
-	SyncVersion      int64  `json:"sync_version"`
-	Deleted          bool   `json:"deleted"`
-	ClientUpdatedAt  string `json:"client_updated_at,omitempty"` // Client timestamp for conflict detection
-}
-
-// SyncPullResponse is the response for pull requests
-type SyncPullResponse struct {
-	Items       []SyncItem `json:"items"`
-	SyncVersion int64      `json:"sync_version"`
-}
-
-// SyncPushRequest is the request for push
-type SyncPushRequest struct {
-	Items []SyncItem `json:"items"`
-}
-
-// SyncPushResponse is the response for push requests
-// ConflictItem represents a conflicting item
-type ConflictItem struct {
-	ClientID      string   `json:"client_id"`
-	Type          string   `json:"type"`
-	ServerVersion int64    `json:"server_version"`
-	ServerData    SyncItem `json:"server_data"`
-	ClientData    SyncItem `json:"client_data"`
-}
+// Page sizes of the sync protocol
+const (
+	defaultPullLimit = 500  // Items per pull page unless the client asks for fewer or more
+	maxPullLimit     = 1000 // Largest pull page
+	maxPushItems     = 1000 // Largest push batch
+)
 
-// SyncPushResponse is the response for push requests
-type SyncPushResponse struct {
-	Updated   []SyncItem     `json:"updated"`
-	Conflicts []ConflictItem `json:"conflicts,omitempty"`
-}
+// liveCursorPrefix marks cursors of pulls that skip deleted items
+const liveCursorPrefix = "live."
 
 // handleSyncPull returns items changed since last_sync_version
 func (s *Server) handleSyncPull(c echo.Context) error {
@@ -66,41 +36,112 @@ func (s *Server) handleSyncPull(c echo.Context) error {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid user id"})
 	}
 
-	// Get last sync version from query param
-	lastVersion := int64(0)
+	ctx := c.Request().Context()
+
+	// Get last sync version from query param, a cursor continues a paged pull
+	since := int64(0)
 	if v := c.QueryParam("since"); v != "" {
 		val, _ := strconv.ParseInt(v, 10, 64)
+		since = val
+	}
+
+	// Starting from scratch skips deleted items, the cursor keeps that mode
+	lastVersion := since
+	withDeleted := since > 0
+	if v := c.QueryParam("cursor"); v != "" {
+		live, isLive := strings.CutPrefix(v, liveCursorPrefix)
+		val, err := strconv.ParseInt(live, 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+		}
 		lastVersion = val
+		withDeleted = !isLive
 	}
 
-	// Get projects changed
-	projects, err := s.queries.GetProjectsChanged(c.Request().Context(), database.GetProjectsChangedParams{
+	limit := defaultPullLimit
+	if v := c.QueryParam("limit"); v != "" {
+		val, err := strconv.Atoi(v)
+		if err != nil || val < 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid limit"})
+		}
+		limit = min(val, maxPullLimit)
+	}
+
+	if c.QueryParam("cursor") == "" {
+		// The device applied every change up to since, compaction waits for it
+		if device := c.Request().Header.Get("X-Device-ID"); device != "" {
+			if err := s.queries.TouchSyncDevice(ctx, database.TouchSyncDeviceParams{
+				UserID:        userUUID,
+				DeviceID:      device,
+				PulledVersion: since,
+			}); err != nil {
+				logger.Error("sync pull: touch device failed", logger.F("error", err), logger.F("user", userID[:8]))
+			}
+		}
+
+		// Deletions after since may be purged, the device must start over
+		if since > 0 {
+			purged, err := s.queries.GetPurgedVersion(ctx, userUUID)
+			if err != nil {
+				logger.Error("sync pull: get purged version failed", logger.F("error", err), logger.F("user", userID[:8]))
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+			}
+			if since < purged {
+				return c.JSON(http.StatusGone, map[string]string{"error": "deletions since last sync were purged, pull everything again"})
+			}
+		}
+	}
+
+	// A push in progress holds versions below committed ones, read once it
+	// committed so the page cannot skip them
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		logger.Error("sync pull: begin transaction failed", logger.F("error", err), logger.F("user", userID[:8]))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	q := s.queries.WithTx(tx)
+	if err := q.LockUserSyncShared(ctx, userUUID); err != nil {
+		logger.Error("sync pull: lock failed", logger.F("error", err), logger.F("user", userID[:8]))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+
+	// Without deleted items the last page ends at the latest version, so the
+	// next pull does not fetch the skipped deletions
+	var latest int64
+	if !withDeleted {
+		latest, err = q.GetMaxSyncVersion(ctx, userUUID)
+		if err != nil {
+			logger.Error("sync pull: get max version failed", logger.F("error", err), logger.F("user", userID[:8]))
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
+		}
+	}
+
+	// Get projects changed, one more than the page holds to see if more follow
+	projects, err := q.GetProjectsChanged(ctx, database.GetProjectsChangedParams{
 		UserID:      userUUID,
 		SyncVersion: sql.NullInt64{Int64: lastVersion, Valid: true},
+		WithDeleted: withDeleted,
+		Limit:       int32(limit + 1),
 	})
 	if err != nil && err != sql.ErrNoRows {
 		logger.Error("sync pull: get projects failed", logger.F("error", err), logger.F("user", userID[:8]))
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
 	}
 
-	var items []SyncItem
+	var items []protocol.SyncItem
 	for _, p := range projects {
-		items = append(items, SyncItem{
-			ID:            p.ClientID,
-			ClientID:      p.ClientID,
-			Type:          p.Type,
-			Slug:          p.Slug,
-			Name:          p.Name,
-			EncryptedData: base64.StdEncoding.EncodeToString(p.EncryptedData),
-			SyncVersion:   p.SyncVersion.Int64,
-			Deleted:       p.Deleted.Bool,
-		})
+		items = append(items, projectItem(p))
 	}
 
 	// Get tasks changed
-	tasks, err := s.queries.GetTasksChanged(c.Request().Context(), database.GetTasksChangedParams{
+	tasks, err := q.GetTasksChanged(ctx, database.GetTasksChangedParams{
 		UserID:      userUUID,
 		SyncVersion: sql.NullInt64{Int64: lastVersion, Valid: true},
+		WithDeleted: withDeleted,
+		Limit:       int32(limit + 1),
 	})
 	if err != nil && err != sql.ErrNoRows {
 		logger.Error("sync pull: get tasks failed", logger.F("error", err), logger.F("user", userID[:8]))
@@ -108,48 +149,102 @@ func (s *Server) handleSyncPull(c echo.Context) error {
 	}
 
 	for _, t := range tasks {
-		dueDate := ""
-		if t.DueDate.Valid {
-			dueDate = t.DueDate.String
-		}
-		status := "process"
-		if t.Status.Valid {
-			status = t.Status.String
-		}
+		items = append(items, taskItem(t))
+	}
 
-		items = append(items, SyncItem{
-			ID:               t.ClientID,
-			ClientID:         t.ClientID,
-			ProjectID:        t.ProjectID,
-			Type:             t.Type,
-			EncryptedContent: base64.StdEncoding.EncodeToString(t.EncryptedContent),
-			Status:           status,
-			Priority:         t.Priority.Int32,
-			DueDate:          dueDate,
-			SyncVersion:      t.SyncVersion.Int64,
-			Deleted:          t.Deleted.Bool,
-		})
+	// Versions are unique across projects and tasks, the page ends at the
+	// limit-th smallest one
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].SyncVersion < items[j].SyncVersion
+	})
+	nextCursor := ""
+	if len(items) > limit {
+		items = items[:limit]
+		nextCursor = strconv.FormatInt(items[limit-1].SyncVersion, 10)
+		if !withDeleted {
+			nextCursor = liveCursorPrefix + nextCursor
+		}
 	}
 
 	// Calculate max version
 	maxVersion := lastVersion
-	for _, item := range items {
-		if item.SyncVersion > maxVersion {
-			maxVersion = item.SyncVersion
-		}
+	if len(items) > 0 {
+		maxVersion = items[len(items)-1].SyncVersion
+	}
+	if nextCursor == "" {
+		maxVersion = max(maxVersion, latest)
 	}
 
 	logger.Debug("sync pull",
 		logger.F("user", userID[:8]),
 		logger.F("since", lastVersion),
-		logger.F("items", len(items)))
+		logger.F("items", len(items)),
+		logger.F("more", nextCursor != ""))
 
-	return c.JSON(http.StatusOK, SyncPullResponse{
+	return c.JSON(http.StatusOK, protocol.SyncPullResponse{
 		Items:       items,
 		SyncVersion: maxVersion,
+		NextCursor:  nextCursor,
 	})
 }
 
+// projectItem returns the sync item of a changed project
+func projectItem(p database.GetProjectsChangedRow) protocol.SyncItem {
+	item := protocol.SyncItem{
+		ID:          p.ClientID,
+		ClientID:    p.ClientID,
+		Type:        p.Type,
+		SyncVersion: p.SyncVersion.Int64,
+		Deleted:     p.Deleted.Bool,
+	}
+	if p.Opaque.Bool {
+		item.Blob = base64.StdEncoding.EncodeToString(p.EncryptedData)
+	} else {
+		item.Slug = p.Slug.String
+		item.Name = p.Name.String
+		item.EncryptedData = base64.StdEncoding.EncodeToString(p.EncryptedData)
+		item.Clock = p.Clock.String
+	}
+	return item
+}
+
+// taskItem returns the sync item of a changed task
+func taskItem(t database.GetTasksChangedRow) protocol.SyncItem {
+	if t.Opaque.Bool {
+		return protocol.SyncItem{
+			ID:          t.ClientID,
+			ClientID:    t.ClientID,
+			Type:        t.Type,
+			Blob:        base64.StdEncoding.EncodeToString(t.EncryptedContent),
+			SyncVersion: t.SyncVersion.Int64,
+			Deleted:     t.Deleted.Bool,
+		}
+	}
+
+	dueDate := ""
+	if t.DueDate.Valid {
+		dueDate = t.DueDate.String
+	}
+	status := "process"
+	if t.Status.Valid {
+		status = t.Status.String
+	}
+
+	return protocol.SyncItem{
+		ID:               t.ClientID,
+		ClientID:         t.ClientID,
+		ProjectID:        t.ProjectID.String,
+		Type:             t.Type,
+		EncryptedContent: base64.StdEncoding.EncodeToString(t.EncryptedContent),
+		Status:           status,
+		Priority:         int(t.Priority.Int32),
+		DueDate:          dueDate,
+		SyncVersion:      t.SyncVersion.Int64,
+		Deleted:          t.Deleted.Bool,
+		Clock:            t.Clock.String,
+	}
+}
+
 func (s *Server) handleSyncPush(c echo.Context) error {
 	userID := c.Get("user_id").(string)
 	userUUID, err := uuid.Parse(userID)
@@ -157,202 +252,282 @@ func (s *Server) handleSyncPush(c echo.Context) error {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid user id"})
 	}
 
-	var req SyncPushRequest
+	var req protocol.SyncPushRequest
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
 	}
+	if len(req.Items) > maxPushItems {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{
+			"error": fmt.Sprintf("too many items, push at most %d per request", maxPushItems),
+		})
+	}
 
 	logger.Debug("sync push received",
 		logger.F("user", userID[:8]),
 		logger.F("items", len(req.Items)))
 
-	var updated []SyncItem
-	var conflicts []ConflictItem
+	// The whole push is one transaction, each item a savepoint within it, so a
+	// failing item is rolled back alone and reported while the others commit
+	ctx := c.Request().Context()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Error("sync push: begin transaction failed", logger.F("error", err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "push failed"})
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	q := s.queries.WithTx(tx)
+
+	// Versions come from a global sequence and show up when the push commits,
+	// pulls of the user wait until then instead of moving past them
+	if err := q.LockUserSync(ctx, userUUID); err != nil {
+		logger.Error("sync push: lock failed", logger.F("error", err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "push failed"})
+	}
+
+	var updated []protocol.SyncItem
+	var conflicts []protocol.ConflictItem
+	var failed []protocol.FailedItem
 
 	for _, item := range req.Items {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT push_item"); err != nil {
+			logger.Error("sync push: savepoint failed", logger.F("error", err))
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "push failed"})
+		}
 
-		// Check for conflicts
-		var clientTime time.Time
-		if item.ClientUpdatedAt != "" {
-			var err error
-			clientTime, err = time.Parse(time.RFC3339, item.ClientUpdatedAt)
-			if err != nil {
-				logger.Warn("sync push: invalid timestamp", logger.F("timestamp", item.ClientUpdatedAt))
+		version, conflict, err := s.pushItem(ctx, q, userUUID, item)
+		if err != nil {
+			logger.Error("sync push: item failed",
+				logger.F("type", item.Type),
+				logger.F("id", item.ClientID),
+				logger.F("error", err))
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT push_item"); err != nil {
+				logger.Error("sync push: rollback to savepoint failed", logger.F("error", err))
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "push failed"})
 			}
+			failed = append(failed, protocol.FailedItem{ClientID: item.ClientID, Type: item.Type, Error: err.Error()})
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT push_item"); err != nil {
+			logger.Error("sync push: release savepoint failed", logger.F("error", err))
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "push failed"})
 		}
 
-		// Conflict Detection Logic
-		hasConflict := false
-		var serverItem SyncItem
-		var serverUpdatedAt time.Time
-
-		if item.Type == "task" {
-			current, err := s.queries.GetTaskForConflict(c.Request().Context(), database.GetTaskForConflictParams{
-				UserID:   userUUID,
-				ClientID: item.ClientID,
-			})
-			if err == nil {
-				// Item exists on server
-				if current.UpdatedAt.Valid {
-					serverUpdatedAt = current.UpdatedAt.Time
-					// If server has a newer version AND client timestamp is valid
-					if !clientTime.IsZero() && serverUpdatedAt.After(clientTime) {
-						hasConflict = true
-						// Populate full server data for conflict response
-						dueDate := ""
-						if current.DueDate.Valid {
-							dueDate = current.DueDate.String
-						}
-						serverItem = SyncItem{
-							ID:               item.ClientID,
-							ClientID:         item.ClientID,
-							Type:             "task",
-							ProjectID:        current.ProjectID,
-							EncryptedContent: base64.StdEncoding.EncodeToString(current.EncryptedContent),
-							Status:           current.Status.String,
-							Priority:         current.Priority.Int32,
-							DueDate:          dueDate,
-							SyncVersion:      current.SyncVersion.Int64,
-							Deleted:          current.Deleted.Bool,
-						}
-					}
-				}
-			}
-		} else if item.Type == "project" {
-			current, err := s.queries.GetProjectForConflict(c.Request().Context(), database.GetProjectForConflictParams{
-				UserID:   userUUID,
-				ClientID: item.ClientID,
-			})
-			if err == nil {
-				if current.UpdatedAt.Valid {
-					serverUpdatedAt = current.UpdatedAt.Time
-					if !clientTime.IsZero() && serverUpdatedAt.After(clientTime) {
-						hasConflict = true
-						// Populate full server data for conflict response
-						serverItem = SyncItem{
-							ID:            item.ClientID,
-							ClientID:      item.ClientID,
-							Type:          "project",
-							Slug:          current.Slug,
-							Name:          current.Name,
-							EncryptedData: base64.StdEncoding.EncodeToString(current.EncryptedData),
-							SyncVersion:   current.SyncVersion.Int64,
-							Deleted:       current.Deleted.Bool,
-						}
-					}
-				}
-			}
+		if conflict != nil {
+			conflicts = append(conflicts, *conflict)
+			continue
 		}
+		item.SyncVersion = version
+		updated = append(updated, item)
+	}
 
-		if hasConflict {
-			logger.Info("sync conflict detected",
-				logger.F("type", item.Type),
-				logger.F("id", item.ClientID[:8]),
-				logger.F("serverTime", serverUpdatedAt.Format(time.RFC3339)),
-				logger.F("clientTime", clientTime.Format(time.RFC3339)))
-
-			conflicts = append(conflicts, ConflictItem{
-				ClientID:      item.ClientID,
-				Type:          item.Type,
-				ServerVersion: serverItem.SyncVersion,
-				ServerData:    serverItem,
-				ClientData:    item,
-			})
-			continue // Skip upsert for conflicting item
+	if err := tx.Commit(); err != nil {
+		logger.Error("sync push: commit failed", logger.F("user", userID[:8]), logger.F("error", err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "push failed"})
+	}
+
+	logger.Info("sync push complete",
+		logger.F("user", userID[:8]),
+		logger.F("updated", len(updated)),
+		logger.F("conflicts", len(conflicts)),
+		logger.F("failed", len(failed)))
+
+	// Let the user's other devices pull right away
+	if len(updated) > 0 {
+		s.streams.publish(userID, c.Request().Header.Get("X-Device-ID"), updated[len(updated)-1].SyncVersion)
+	}
+
+	return c.JSON(http.StatusOK, protocol.SyncPushResponse{
+		Updated:   updated,
+		Conflicts: conflicts,
+		Failed:    failed,
+	})
+}
+
+// pushItem stores one pushed item and returns its new version, or the conflict
+// if the stored version moved on since the client's base
+func (s *Server) pushItem(ctx context.Context, q *database.Queries, userUUID uuid.UUID, item protocol.SyncItem) (int64, *protocol.ConflictItem, error) {
+	// Client timestamp is stored for display only, versions decide conflicts
+	var clientUpdatedAt sql.NullTime
+	if item.ClientUpdatedAt != "" {
+		if t, err := time.Parse(time.RFC3339, item.ClientUpdatedAt); err == nil {
+			clientUpdatedAt = sql.NullTime{Time: t, Valid: true}
+		} else {
+			logger.Warn("sync push: invalid timestamp", logger.F("timestamp", item.ClientUpdatedAt))
 		}
+	}
+	baseVersion := sql.NullInt64{Int64: item.BaseVersion, Valid: true}
 
-		switch item.Type {
-		case "project":
-			data, err := base64.StdEncoding.DecodeString(item.EncryptedData)
-			if err != nil {
-				logger.Error("sync push: base64 decode failed", logger.F("id", item.ClientID[:8]))
-				continue
-			}
+	// Opaque items carry every field in the blob, the metadata columns stay NULL
+	opaque := item.Blob != ""
+	clock := sql.NullString{String: item.Clock, Valid: item.Clock != "" && !opaque}
 
-			slug := item.Slug
-			if slug == "" {
-				slug = item.ClientID // Fallback
-			}
-			name := item.Name
-			if name == "" {
-				name = slug
-			}
+	switch item.Type {
+	case "project":
+		encoded := item.EncryptedData
+		if opaque {
+			encoded = item.Blob
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid encrypted data: %w", err)
+		}
 
-			// Parse client timestamp
-			var clientUpdatedAt sql.NullTime
-			if !clientTime.IsZero() {
-				clientUpdatedAt = sql.NullTime{Time: clientTime, Valid: true}
+		var slug, name sql.NullString
+		if !opaque {
+			slug = sql.NullString{String: item.Slug, Valid: true}
+			if slug.String == "" {
+				slug.String = item.ClientID // Fallback
 			}
-
-			version, err := s.queries.UpsertProject(c.Request().Context(), database.UpsertProjectParams{
-				UserID:          userUUID,
-				ClientID:        item.ClientID,
-				Slug:            slug,
-				Name:            name,
-				Color:           sql.NullString{String: "", Valid: true},
-				EncryptedData:   data,
-				Deleted:         sql.NullBool{Bool: item.Deleted, Valid: true},
-				ClientUpdatedAt: clientUpdatedAt,
-			})
-			if err != nil {
-				logger.Error("sync push: upsert project failed",
-					logger.F("id", item.ClientID[:8]),
-					logger.F("error", err))
-			} else {
-				item.SyncVersion = version.Int64
-				updated = append(updated, item)
+			name = sql.NullString{String: item.Name, Valid: true}
+			if name.String == "" {
+				name.String = slug.String
 			}
+		}
 
-		case "task":
-			contentData, err := base64.StdEncoding.DecodeString(item.EncryptedContent)
+		version, err := q.UpsertProject(ctx, database.UpsertProjectParams{
+			UserID:          userUUID,
+			ClientID:        item.ClientID,
+			Slug:            slug,
+			Name:            name,
+			Color:           sql.NullString{String: "", Valid: true},
+			EncryptedData:   data,
+			Deleted:         sql.NullBool{Bool: item.Deleted, Valid: true},
+			ClientUpdatedAt: clientUpdatedAt,
+			Opaque:          sql.NullBool{Bool: opaque, Valid: true},
+			Clock:           clock,
+			BaseVersion:     baseVersion,
+		})
+		if err == sql.ErrNoRows {
+			current, err := q.GetProjectForConflict(ctx, database.GetProjectForConflictParams{
+				UserID:   userUUID,
+				ClientID: item.ClientID,
+			})
 			if err != nil {
-				logger.Error("sync push: base64 decode failed", logger.F("id", item.ClientID[:8]))
-				continue
+				return 0, nil, fmt.Errorf("get project for conflict: %w", err)
 			}
+			conflict := newConflict(item, projectConflictItem(item.ClientID, current))
+			return 0, &conflict, nil
+		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("upsert project: %w", err)
+		}
+		return version.Int64, nil, nil
 
-			status := item.Status
-			if status == "" {
-				status = "process"
-			}
+	case "task":
+		encoded := item.EncryptedContent
+		if opaque {
+			encoded = item.Blob
+		}
+		contentData, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid encrypted content: %w", err)
+		}
 
-			// Parse client timestamp for task
-			var taskClientUpdatedAt sql.NullTime
-			if !clientTime.IsZero() {
-				taskClientUpdatedAt = sql.NullTime{Time: clientTime, Valid: true}
+		var projectID, status, dueDate sql.NullString
+		var priority sql.NullInt32
+		if !opaque {
+			projectID = sql.NullString{String: item.ProjectID, Valid: true}
+			status = sql.NullString{String: item.Status, Valid: true}
+			if status.String == "" {
+				status.String = "process"
 			}
+			priority = sql.NullInt32{Int32: int32(item.Priority), Valid: true}
+			dueDate = sql.NullString{String: item.DueDate, Valid: item.DueDate != ""}
+		}
 
-			version, err := s.queries.UpsertTask(c.Request().Context(), database.UpsertTaskParams{
-				UserID:           userUUID,
-				ClientID:         item.ClientID,
-				ProjectID:        item.ProjectID,
-				EncryptedContent: contentData,
-				Status:           sql.NullString{String: status, Valid: true},
-				Priority:         sql.NullInt32{Int32: item.Priority, Valid: true},
-				DueDate:          sql.NullString{String: item.DueDate, Valid: item.DueDate != ""},
-				Deleted:          sql.NullBool{Bool: item.Deleted, Valid: true},
-				ClientUpdatedAt:  taskClientUpdatedAt,
+		version, err := q.UpsertTask(ctx, database.UpsertTaskParams{
+			UserID:           userUUID,
+			ClientID:         item.ClientID,
+			ProjectID:        projectID,
+			EncryptedContent: contentData,
+			Status:           status,
+			Priority:         priority,
+			DueDate:          dueDate,
+			Deleted:          sql.NullBool{Bool: item.Deleted, Valid: true},
+			ClientUpdatedAt:  clientUpdatedAt,
+			Opaque:           sql.NullBool{Bool: opaque, Valid: true},
+			Clock:            clock,
+			BaseVersion:      baseVersion,
+		})
+		if err == sql.ErrNoRows {
+			current, err := q.GetTaskForConflict(ctx, database.GetTaskForConflictParams{
+				UserID:   userUUID,
+				ClientID: item.ClientID,
 			})
-
 			if err != nil {
-				logger.Error("sync push: upsert task failed",
-					logger.F("id", item.ClientID[:8]),
-					logger.F("error", err))
-			} else {
-				item.SyncVersion = version.Int64
-				updated = append(updated, item)
+				return 0, nil, fmt.Errorf("get task for conflict: %w", err)
 			}
+			conflict := newConflict(item, taskConflictItem(item.ClientID, current))
+			return 0, &conflict, nil
 		}
+		if err != nil {
+			return 0, nil, fmt.Errorf("upsert task: %w", err)
+		}
+		return version.Int64, nil, nil
 	}
 
-	logger.Info("sync push complete",
-		logger.F("user", userID[:8]),
-		logger.F("updated", len(updated)),
-		logger.F("conflicts", len(conflicts)))
+	return 0, nil, fmt.Errorf("unknown item type %q", item.Type)
+}
 
-	return c.JSON(http.StatusOK, SyncPushResponse{
-		Updated:   updated,
-		Conflicts: conflicts,
-	})
+// newConflict pairs a rejected client item with the current server version
+func newConflict(item, serverItem protocol.SyncItem) protocol.ConflictItem {
+	logger.Info("sync conflict detected",
+		logger.F("type", item.Type),
+		logger.F("id", item.ClientID[:8]),
+		logger.F("baseVersion", item.BaseVersion),
+		logger.F("serverVersion", serverItem.SyncVersion))
+
+	return protocol.ConflictItem{
+		ClientID:      item.ClientID,
+		Type:          item.Type,
+		ServerVersion: serverItem.SyncVersion,
+		ServerData:    serverItem,
+		ClientData:    item,
+	}
+}
+
+// projectConflictItem builds the sync item of the stored project
+func projectConflictItem(clientID string, current database.GetProjectForConflictRow) protocol.SyncItem {
+	item := protocol.SyncItem{
+		ID:          clientID,
+		ClientID:    clientID,
+		Type:        "project",
+		SyncVersion: current.SyncVersion.Int64,
+		Deleted:     current.Deleted.Bool,
+	}
+	if current.Opaque.Bool {
+		item.Blob = base64.StdEncoding.EncodeToString(current.EncryptedData)
+	} else {
+		item.Slug = current.Slug.String
+		item.Name = current.Name.String
+		item.EncryptedData = base64.StdEncoding.EncodeToString(current.EncryptedData)
+		item.Clock = current.Clock.String
+	}
+	return item
+}
+
+// taskConflictItem builds the sync item of the stored task
+func taskConflictItem(clientID string, current database.GetTaskForConflictRow) protocol.SyncItem {
+	item := protocol.SyncItem{
+		ID:          clientID,
+		ClientID:    clientID,
+		Type:        "task",
+		SyncVersion: current.SyncVersion.Int64,
+		Deleted:     current.Deleted.Bool,
+	}
+	if current.Opaque.Bool {
+		item.Blob = base64.StdEncoding.EncodeToString(current.EncryptedContent)
+	} else {
+		item.ProjectID = current.ProjectID.String
+		item.EncryptedContent = base64.StdEncoding.EncodeToString(current.EncryptedContent)
+		item.Status = current.Status.String
+		item.Priority = int(current.Priority.Int32)
+		item.DueDate = current.DueDate.String
+		item.Clock = current.Clock.String
+	}
+	return item
 }
 
 // handleClear wipes all data for the user
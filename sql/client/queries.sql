@@ -24,14 +24,24 @@ UPDATE projects
 SET slug = ?, name = ?, color = ?, updated_at = ?, sync_version = NULL
 WHERE id = ?;
 
+-- name: GetProjectForSync :one
+-- Including deleted projects, a pulled change may delete or restore them
+SELECT * FROM projects
+WHERE id = ? LIMIT 1;
+
 -- name: OverwriteProject :exec
 UPDATE projects
-SET slug = ?, name = ?, color = ?, updated_at = ?, sync_version = ?
+SET slug = ?, name = ?, color = ?, archived = ?, updated_at = ?, deleted_at = ?, sync_version = ?, base_version = ?
 WHERE id = ?;
 
+-- name: GetTaskForSync :one
+-- Including deleted tasks, a pulled change may delete or restore them
+SELECT * FROM tasks
+WHERE id = ? LIMIT 1;
+
 -- name: OverwriteTask :exec
 UPDATE tasks
-SET project_id = ?, content = ?, status = ?, priority = ?, due_date = ?, tags = ?, updated_at = ?, sync_version = ?
+SET project_id = ?, content = ?, status = ?, priority = ?, due_date = ?, tags = ?, updated_at = ?, deleted_at = ?, sync_version = ?, base_version = ?
 WHERE id = ?;
 
 
@@ -74,6 +84,11 @@ SET deleted_at = ?, updated_at = ?, sync_version = NULL
 WHERE id = ?;
 
 
+-- name: ProjectExists :one
+-- Deleted projects count, tasks may still reference them
+SELECT COUNT(*) FROM projects
+WHERE id = ?;
+
 -- name: CountTasks :one
 SELECT 
     COUNT(*) FILTER (WHERE status = 'process'),
@@ -99,8 +114,145 @@ SELECT * FROM tasks
 WHERE sync_version IS NULL
 ORDER BY updated_at;
 
+-- name: CountUnsynced :one
+-- Local changes not pushed yet
+SELECT
+    (SELECT COUNT(*) FROM projects WHERE projects.sync_version IS NULL)
+  + (SELECT COUNT(*) FROM tasks WHERE tasks.sync_version IS NULL);
+
+-- name: GetUnsyncedMark :one
+-- Count and latest edit of local changes not pushed yet, it moves with every
+-- local change
+SELECT COUNT(*) AS count, CAST(COALESCE(MAX(updated_at), '') AS TEXT) AS latest
+FROM (
+    SELECT updated_at FROM projects WHERE sync_version IS NULL
+    UNION ALL
+    SELECT updated_at FROM tasks WHERE sync_version IS NULL
+);
+
+-- name: ListSyncDigestItems :many
+-- Live and unpushed items, compared with the server digest
+SELECT 'project' AS item_type, id, sync_version, deleted_at FROM projects
+WHERE deleted_at IS NULL OR sync_version IS NULL
+UNION ALL
+SELECT 'task' AS item_type, id, sync_version, deleted_at FROM tasks
+WHERE deleted_at IS NULL OR sync_version IS NULL;
+
 -- name: UpdateProjectSyncVersion :exec
-UPDATE projects SET sync_version = ? WHERE id = ?;
+-- base_version is the server version local changes are based on, sent as base for the next push
+UPDATE projects SET sync_version = ?, base_version = ? WHERE id = ?;
 
 -- name: UpdateTaskSyncVersion :exec
-UPDATE tasks SET sync_version = ? WHERE id = ?;
+-- base_version is the server version local changes are based on, sent as base for the next push
+UPDATE tasks SET sync_version = ?, base_version = ? WHERE id = ?;
+
+-- name: RebaseProject :exec
+-- Keep a conflicting local change: base it on the server version and push it again
+UPDATE projects SET base_version = ?, sync_version = NULL WHERE id = ?;
+
+-- name: RebaseTask :exec
+-- Keep a conflicting local change: base it on the server version and push it again
+UPDATE tasks SET base_version = ?, sync_version = NULL WHERE id = ?;
+
+-- name: MarkProjectsDirty :exec
+-- Mark every synced project as "needs push", e.g. to re-upload it encrypted
+UPDATE projects SET sync_version = NULL WHERE sync_version IS NOT NULL;
+
+-- name: MarkTasksDirty :exec
+-- Mark every synced task as "needs push", e.g. to re-upload it encrypted
+UPDATE tasks SET sync_version = NULL WHERE sync_version IS NOT NULL;
+
+-- name: PurgeDeletedTasks :execrows
+-- Hard-delete tasks whose deletion the server acknowledged
+DELETE FROM tasks
+WHERE deleted_at IS NOT NULL AND sync_version IS NOT NULL;
+
+-- name: PurgeDeletedProjects :execrows
+-- Hard-delete projects whose deletion the server acknowledged, once no task refers to them
+DELETE FROM projects
+WHERE deleted_at IS NOT NULL AND sync_version IS NOT NULL
+  AND id NOT IN (SELECT project_id FROM tasks);
+
+-- name: DeleteSyncedTasks :exec
+-- Drop every task without unpushed changes, before pulling everything again
+DELETE FROM tasks WHERE sync_version IS NOT NULL;
+
+-- name: DeleteSyncedProjects :exec
+-- Drop every project without unpushed changes that no task refers to
+DELETE FROM projects
+WHERE sync_version IS NOT NULL
+  AND id NOT IN (SELECT project_id FROM tasks);
+
+-- name: GetSyncBase :one
+-- Last synced version of an item, the base of three-way merges
+SELECT * FROM sync_base
+WHERE item_type = ? AND item_id = ?;
+
+-- name: UpsertSyncBase :exec
+INSERT INTO sync_base (item_type, item_id, version, deleted, data)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (item_type, item_id) DO UPDATE
+SET version = excluded.version, deleted = excluded.deleted, data = excluded.data;
+
+-- name: ClearSyncBase :exec
+DELETE FROM sync_base;
+
+-- name: DeleteSyncBase :exec
+-- Drop the base snapshot of an item, its next merge has none
+DELETE FROM sync_base
+WHERE item_type = ? AND item_id = ?;
+
+-- name: DeleteOrphanSyncBase :exec
+-- Drop the base snapshots of items that no longer exist
+DELETE FROM sync_base
+WHERE (item_type = 'project' AND item_id NOT IN (SELECT id FROM projects))
+   OR (item_type = 'task' AND item_id NOT IN (SELECT id FROM tasks));
+
+-- name: GetSyncState :one
+SELECT value FROM sync_state
+WHERE key = ?;
+
+-- name: SetSyncState :exec
+INSERT INTO sync_state (key, value)
+VALUES (?, ?)
+ON CONFLICT (key) DO UPDATE
+SET value = excluded.value;
+
+-- name: StartSyncRun :one
+INSERT INTO sync_runs (started_at, source, mode, from_version)
+VALUES (?, ?, ?, ?)
+RETURNING id;
+
+-- name: FinishSyncRun :exec
+UPDATE sync_runs
+SET finished_at = ?, pushed = ?, pulled = ?, conflicts = ?, failed = ?, error = ?, to_version = ?
+WHERE id = ?;
+
+-- name: ListSyncRuns :many
+-- Runs started since a time, newest first
+SELECT * FROM sync_runs
+WHERE started_at >= ?
+ORDER BY id DESC;
+
+-- name: PruneSyncRuns :exec
+-- Keep only the most recent runs
+DELETE FROM sync_runs
+WHERE id NOT IN (SELECT id FROM sync_runs ORDER BY id DESC LIMIT ?);
+
+-- name: ListSyncConflicts :many
+SELECT * FROM sync_conflicts
+ORDER BY detected_at, item_type, item_id;
+
+-- name: UpsertSyncConflict :exec
+-- Keeps the time a conflict was first detected
+INSERT INTO sync_conflicts (item_type, item_id, server_version, detected_at, data)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (item_type, item_id) DO UPDATE
+SET server_version = excluded.server_version, data = excluded.data;
+
+-- name: DeleteSyncConflict :exec
+DELETE FROM sync_conflicts
+WHERE item_type = ? AND item_id = ?;
+
+-- name: ClearSyncConflicts :exec
+DELETE FROM sync_conflicts;
@@ -7,7 +7,8 @@ CREATE TABLE projects (
     created_at TEXT NOT NULL,
     updated_at TEXT NOT NULL,
     deleted_at TEXT,
-    sync_version INTEGER  -- NULL means "needs sync", set by server after push
+    sync_version INTEGER,  -- NULL means "needs sync", set by server after push
+    base_version INTEGER   -- Server version local changes are based on, for conflict detection
 );
 
 CREATE INDEX idx_projects_slug ON projects(slug);
@@ -28,6 +29,7 @@ CREATE TABLE tasks (
     updated_at TEXT NOT NULL,
     deleted_at TEXT,
     sync_version INTEGER,  -- NULL means "needs sync", set by server after push
+    base_version INTEGER,  -- Server version local changes are based on, for conflict detection
     FOREIGN KEY (project_id) REFERENCES projects(id)
 );
 
@@ -38,3 +40,41 @@ CREATE TABLE sync_state (
     key TEXT PRIMARY KEY,
     value TEXT
 );
+
+-- Last synced version of each item, the base of three-way merges
+CREATE TABLE sync_base (
+    item_type TEXT NOT NULL,
+    item_id TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    deleted INTEGER NOT NULL DEFAULT 0,
+    data TEXT NOT NULL,  -- JSON of the synced fields
+    PRIMARY KEY (item_type, item_id)
+);
+
+-- History of every sync, for debugging
+CREATE TABLE sync_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    started_at TEXT NOT NULL,
+    finished_at TEXT,  -- NULL while running, or if the run was interrupted
+    source TEXT NOT NULL,  -- What started the sync: manual, auto, cli, ...
+    mode TEXT NOT NULL,  -- merge, pull or push
+    pushed INTEGER NOT NULL DEFAULT 0,
+    pulled INTEGER NOT NULL DEFAULT 0,
+    conflicts INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    from_version INTEGER NOT NULL,  -- Server version synced up to before the run
+    to_version INTEGER NOT NULL DEFAULT 0  -- and after it
+);
+
+CREATE INDEX idx_sync_runs_started ON sync_runs(started_at);
+
+-- Conflicts of the last sync that are not resolved yet
+CREATE TABLE sync_conflicts (
+    item_type TEXT NOT NULL,
+    item_id TEXT NOT NULL,
+    server_version INTEGER NOT NULL,  -- Server version the local change conflicts with
+    detected_at TEXT NOT NULL,  -- First sync that reported the conflict
+    data TEXT NOT NULL,  -- JSON of the conflict with both sides, as encrypted when pushed and pulled
+    PRIMARY KEY (item_type, item_id)
+);
@@ -44,10 +44,12 @@ WHERE token = $1;
 UPDATE irontask.magic_links SET used = TRUE WHERE token = $1;
 
 -- name: UpsertProject :one
-INSERT INTO irontask.projects (user_id, client_id, slug, name, color, encrypted_data, sync_version, deleted, updated_at, client_updated_at)
+-- Compare-and-swap: an existing row is only updated while its version is still the
+-- client's base version. Otherwise no row is returned and the push is a conflict.
+INSERT INTO irontask.projects (user_id, client_id, slug, name, color, encrypted_data, sync_version, deleted, updated_at, client_updated_at, opaque, clock)
 VALUES ($1, $2, $3, $4, $5, $6, 
     nextval('irontask.sync_version_seq'), 
-    $7, NOW(), $8)
+    $7, NOW(), $8, $9, $10)
 ON CONFLICT (user_id, client_id) DO UPDATE
 SET slug = EXCLUDED.slug,
     name = EXCLUDED.name,
@@ -55,24 +57,36 @@ SET slug = EXCLUDED.slug,
     deleted = EXCLUDED.deleted,
     sync_version = nextval('irontask.sync_version_seq'),
     updated_at = NOW(),
-    client_updated_at = EXCLUDED.client_updated_at
+    client_updated_at = EXCLUDED.client_updated_at,
+    opaque = EXCLUDED.opaque,
+    clock = EXCLUDED.clock
+WHERE irontask.projects.sync_version = sqlc.arg(base_version)
 RETURNING sync_version;
 
 -- name: GetProjectsChanged :many
-SELECT client_id, slug, name, 'project' as type, sync_version, encrypted_data, deleted
+-- One page of changes in version order, the version of the last item is the cursor of the next.
+-- Without with_deleted only live projects, for devices starting from scratch. Deleted projects
+-- live tasks refer to stay, opaque ones too as the server cannot tell.
+SELECT client_id, slug, name, 'project' as type, sync_version, encrypted_data, deleted, opaque, clock
 FROM irontask.projects
-WHERE user_id = $1 AND sync_version > $2;
+WHERE user_id = $1 AND sync_version > $2
+  AND (sqlc.arg(with_deleted)::BOOLEAN OR deleted IS NOT TRUE OR opaque IS TRUE
+    OR client_id IN (SELECT project_id FROM irontask.tasks WHERE tasks.user_id = $1 AND tasks.deleted IS NOT TRUE))
+ORDER BY sync_version
+LIMIT $4;
 
 -- name: GetProjectForConflict :one
-SELECT sync_version, updated_at, client_updated_at, slug, name, encrypted_data, deleted
+SELECT sync_version, updated_at, client_updated_at, slug, name, encrypted_data, deleted, opaque, clock
 FROM irontask.projects
 WHERE user_id = $1 AND client_id = $2;
 
 -- name: UpsertTask :one
-INSERT INTO irontask.tasks (user_id, client_id, project_id, type, encrypted_content, status, priority, due_date, deleted, sync_version, updated_at, client_updated_at)
+-- Compare-and-swap: an existing row is only updated while its version is still the
+-- client's base version. Otherwise no row is returned and the push is a conflict.
+INSERT INTO irontask.tasks (user_id, client_id, project_id, type, encrypted_content, status, priority, due_date, deleted, sync_version, updated_at, client_updated_at, opaque, clock)
 VALUES ($1, $2, $3, 'task', $4, $5, $6, $7, $8, 
     nextval('irontask.sync_version_seq'),
-    NOW(), $9)
+    NOW(), $9, $10, $11)
 ON CONFLICT (user_id, client_id) DO UPDATE
 SET project_id = EXCLUDED.project_id,
     encrypted_content = EXCLUDED.encrypted_content,
@@ -82,16 +96,24 @@ SET project_id = EXCLUDED.project_id,
     deleted = EXCLUDED.deleted,
     sync_version = nextval('irontask.sync_version_seq'),
     updated_at = NOW(),
-    client_updated_at = EXCLUDED.client_updated_at
+    client_updated_at = EXCLUDED.client_updated_at,
+    opaque = EXCLUDED.opaque,
+    clock = EXCLUDED.clock
+WHERE irontask.tasks.sync_version = sqlc.arg(base_version)
 RETURNING sync_version;
 
 -- name: GetTasksChanged :many
-SELECT client_id, project_id, 'task' as type, sync_version, encrypted_content, status, priority, due_date, deleted
+-- One page of changes in version order, the version of the last item is the cursor of the next.
+-- Without with_deleted only live tasks, for devices starting from scratch.
+SELECT client_id, project_id, 'task' as type, sync_version, encrypted_content, status, priority, due_date, deleted, opaque, clock
 FROM irontask.tasks
-WHERE user_id = $1 AND sync_version > $2;
+WHERE user_id = $1 AND sync_version > $2
+  AND (sqlc.arg(with_deleted)::BOOLEAN OR deleted IS NOT TRUE)
+ORDER BY sync_version
+LIMIT $4;
 
 -- name: GetTaskForConflict :one
-SELECT sync_version, updated_at, client_updated_at, status, priority, project_id, encrypted_content, due_date, deleted
+SELECT sync_version, updated_at, client_updated_at, status, priority, project_id, encrypted_content, due_date, deleted, opaque, clock
 FROM irontask.tasks
 WHERE user_id = $1 AND client_id = $2;
 
@@ -101,4 +123,68 @@ DELETE FROM irontask.tasks WHERE user_id = $1;
 -- name: ClearProjects :exec
 DELETE FROM irontask.projects WHERE user_id = $1;
 
-
+-- name: GetEncryptionKey :one
+SELECT key_data
+FROM irontask.encryption_keys
+WHERE user_id = $1;
+
+-- name: UpsertEncryptionKey :exec
+INSERT INTO irontask.encryption_keys (user_id, key_data)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET key_data = EXCLUDED.key_data,
+    updated_at = NOW();
+
+-- name: GetMaxSyncVersion :one
+-- Latest version of any item of the user, deleted or not
+SELECT GREATEST(
+    (SELECT COALESCE(MAX(sync_version), 0) FROM irontask.projects WHERE projects.user_id = $1),
+    (SELECT COALESCE(MAX(sync_version), 0) FROM irontask.tasks WHERE tasks.user_id = $1)
+)::BIGINT AS max_version;
+
+-- name: LockUserSync :exec
+-- Held by a push until it commits, pulls of the user wait for it
+SELECT pg_advisory_xact_lock(hashtextextended(sqlc.arg(user_id)::uuid::text, 0));
+
+-- name: LockUserSyncShared :exec
+-- Held by a pull, waits for pushes of the user in progress
+SELECT pg_advisory_xact_lock_shared(hashtextextended(sqlc.arg(user_id)::uuid::text, 0));
+
+-- name: TouchSyncDevice :exec
+-- A device pulling since a version has applied every change up to it
+INSERT INTO irontask.sync_devices (user_id, device_id, pulled_version, last_seen)
+VALUES ($1, $2, $3, NOW())
+ON CONFLICT (user_id, device_id) DO UPDATE
+SET pulled_version = EXCLUDED.pulled_version,
+    last_seen = NOW();
+
+-- name: DeleteStaleSyncDevices :exec
+-- Devices not seen for long stop holding back compaction, they pull everything again on return
+DELETE FROM irontask.sync_devices WHERE last_seen < $1;
+
+-- name: ListPurgeHorizons :many
+-- Per user, the version every known device has pulled past
+SELECT user_id, MIN(pulled_version)::BIGINT AS horizon
+FROM irontask.sync_devices
+GROUP BY user_id;
+
+-- name: PurgeDeletedProjects :execrows
+-- Deleted projects live tasks refer to are kept, opaque ones always
+DELETE FROM irontask.projects
+WHERE user_id = $1 AND deleted = TRUE AND sync_version <= $2
+  AND opaque IS NOT TRUE
+  AND client_id NOT IN (SELECT project_id FROM irontask.tasks WHERE tasks.user_id = $1 AND tasks.deleted IS NOT TRUE AND project_id IS NOT NULL);
+
+-- name: PurgeDeletedTasks :execrows
+DELETE FROM irontask.tasks
+WHERE user_id = $1 AND deleted = TRUE AND sync_version <= $2;
+
+-- name: SetPurgedVersion :exec
+-- Deletions up to purged_version are gone, devices behind it must pull everything again
+UPDATE irontask.users SET purged_version = $2
+WHERE id = $1 AND purged_version < $2;
+
+-- name: GetPurgedVersion :one
+SELECT purged_version
+FROM irontask.users
+WHERE id = $1;
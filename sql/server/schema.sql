@@ -13,7 +13,8 @@ CREATE TABLE IF NOT EXISTS irontask.users (
     email VARCHAR(255) UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
     created_at TIMESTAMP DEFAULT NOW(),
-    updated_at TIMESTAMP DEFAULT NOW()
+    updated_at TIMESTAMP DEFAULT NOW(),
+    purged_version BIGINT NOT NULL DEFAULT 0  -- Deletions up to this version are purged
 );
 
 CREATE TABLE IF NOT EXISTS irontask.sessions (
@@ -39,15 +40,17 @@ CREATE TABLE IF NOT EXISTS irontask.projects (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     user_id UUID NOT NULL REFERENCES irontask.users(id),
     client_id TEXT NOT NULL,
-    slug TEXT NOT NULL,
-    name TEXT NOT NULL,
+    slug TEXT,  -- NULL for opaque items
+    name TEXT,  -- NULL for opaque items
     color TEXT DEFAULT '#4ECDC4',
     encrypted_data BYTEA,
     sync_version BIGINT DEFAULT nextval('irontask.sync_version_seq'),
     deleted BOOLEAN DEFAULT FALSE,
     created_at TIMESTAMP DEFAULT NOW(),
     updated_at TIMESTAMP DEFAULT NOW(),
-    client_updated_at TIMESTAMP,  -- Timestamp from client for conflict detection
+    client_updated_at TIMESTAMP,  -- Timestamp from client, for display only
+    opaque BOOLEAN DEFAULT FALSE,  -- All fields are inside encrypted_data
+    clock TEXT,  -- Hybrid logical clock of the client change, NULL for opaque items
     UNIQUE(user_id, client_id),
     UNIQUE(user_id, slug)
 );
@@ -60,7 +63,7 @@ CREATE TABLE IF NOT EXISTS irontask.tasks (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
     user_id UUID NOT NULL REFERENCES irontask.users(id),
     client_id TEXT NOT NULL,
-    project_id TEXT NOT NULL,
+    project_id TEXT,  -- NULL for opaque items
     type TEXT DEFAULT 'task',  -- For sync item type identification
     encrypted_content BYTEA,
     status TEXT DEFAULT 'process',
@@ -70,7 +73,9 @@ CREATE TABLE IF NOT EXISTS irontask.tasks (
     deleted BOOLEAN DEFAULT FALSE,
     created_at TIMESTAMP DEFAULT NOW(),
     updated_at TIMESTAMP DEFAULT NOW(),
-    client_updated_at TIMESTAMP,  -- Timestamp from client for conflict detection
+    client_updated_at TIMESTAMP,  -- Timestamp from client, for display only
+    opaque BOOLEAN DEFAULT FALSE,  -- All fields are inside encrypted_content
+    clock TEXT,  -- Hybrid logical clock of the client change, NULL for opaque items
     UNIQUE(user_id, client_id)
 );
 
@@ -78,3 +83,19 @@ CREATE INDEX IF NOT EXISTS idx_tasks_user ON irontask.tasks(user_id);
 CREATE INDEX IF NOT EXISTS idx_tasks_sync ON irontask.tasks(user_id, sync_version);
 CREATE INDEX IF NOT EXISTS idx_tasks_status ON irontask.tasks(user_id, status);
 
+-- Per-user key material for end-to-end encryption (opaque to the server)
+CREATE TABLE IF NOT EXISTS irontask.encryption_keys (
+    user_id UUID PRIMARY KEY REFERENCES irontask.users(id),
+    key_data TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- How far each device of a user pulled, deletions all of them saw are purged
+CREATE TABLE IF NOT EXISTS irontask.sync_devices (
+    user_id UUID NOT NULL REFERENCES irontask.users(id),
+    device_id TEXT NOT NULL,
+    pulled_version BIGINT NOT NULL DEFAULT 0,
+    last_seen TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, device_id)
+);